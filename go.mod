@@ -3,14 +3,16 @@ module github.com/bitrise-steplib/bitrise-step-autofix-ci
 go 1.21
 
 require (
+	github.com/BurntSushi/toml v1.6.0
 	github.com/bitrise-io/go-steputils/v2 v2.0.0-alpha.30
 	github.com/bitrise-io/go-utils/v2 v2.0.0-alpha.22
 	github.com/stretchr/testify v1.11.1
+	gopkg.in/yaml.v3 v3.0.1
+	howett.net/plist v1.0.1
 )
 
 require (
 	github.com/bitrise-io/go-utils v1.0.1 // indirect
 	github.com/davecgh/go-spew v1.1.1 // indirect
 	github.com/pmezard/go-difflib v1.0.0 // indirect
-	gopkg.in/yaml.v3 v3.0.1 // indirect
 )
@@ -3,8 +3,14 @@
 package integrationtests
 
 import (
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
 	"testing"
 
+	"github.com/bitrise-steplib/bitrise-step-autofix-ci/step"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 )
@@ -18,6 +24,8 @@ func TestNoChanges(t *testing.T) {
 	require.NoError(t, err)
 	assert.False(t, result.AutofixNeeded)
 	assert.False(t, result.AutofixPushed)
+	assert.Equal(t, step.OutcomeNoChanges, result.Outcome)
+	assert.FileExists(t, result.ReportPath)
 }
 
 func TestDryRun_ChangesDetected(t *testing.T) {
@@ -36,6 +44,11 @@ func TestDryRun_ChangesDetected(t *testing.T) {
 	assert.Equal(t, 1, result.FileCount)
 	assert.Equal(t, "Test Autofix", latestCommitSubject(t, repo.workdir))
 	assert.Equal(t, initialCount, commitCount(t, repo.remoteDir), "remote should be unchanged")
+
+	summary, err := os.ReadFile(result.SummaryPath)
+	require.NoError(t, err)
+	assert.Contains(t, string(summary), "| `generated.txt` | untracked | +1 | -0 |")
+	assert.Contains(t, string(summary), "+new content")
 }
 
 func TestRealPush_ChangesDetected(t *testing.T) {
@@ -51,6 +64,26 @@ func TestRealPush_ChangesDetected(t *testing.T) {
 	assert.True(t, result.AutofixPushed)
 	assert.Equal(t, 1, result.FileCount)
 	assert.Equal(t, initialCount+1, commitCount(t, repo.remoteDir))
+	assert.Equal(t, step.OutcomePushed, result.Outcome)
+	assert.Equal(t, runGit(t, repo.remoteDir, "rev-parse", "main"), result.CommitSHA)
+	assert.True(t, result.OnPush.FailsBuild())
+	assert.False(t, result.SkipCI)
+}
+
+func TestRealPush_SucceedWithSkipCI(t *testing.T) {
+	repo := setupRepo(t)
+	writeFile(t, repo.workdir, "generated.txt", "new content")
+	setCommonEnvs(t, repo)
+	t.Setenv("on_push", "succeed_with_skip_ci")
+
+	result, err := runStep(t, repo.workdir)
+
+	require.NoError(t, err)
+	assert.True(t, result.AutofixPushed)
+	assert.True(t, result.SkipCI)
+	assert.Equal(t, step.OnPushSucceedWithSkipCI, result.OnPush)
+	assert.False(t, result.OnPush.FailsBuild())
+	assert.Equal(t, "Test Autofix [skip ci]", latestCommitSubject(t, repo.remoteDir))
 }
 
 func TestNonPRBuild_Skipped(t *testing.T) {
@@ -77,6 +110,7 @@ func TestForkPR_Skipped(t *testing.T) {
 
 	require.NoError(t, err)
 	assert.False(t, result.AutofixNeeded)
+	assert.Equal(t, step.OutcomeFork, result.Outcome)
 }
 
 func TestCIConfigChange_SecurityError(t *testing.T) {
@@ -94,6 +128,80 @@ func TestCIConfigChange_SecurityError(t *testing.T) {
 	assert.Equal(t, "Initial commit", latestCommitSubject(t, repo.workdir))
 }
 
+func TestCheckOnly_ChangesDetected(t *testing.T) {
+	repo := setupRepo(t)
+	writeFile(t, repo.workdir, "README.md", "# Test\nreformatted\n")
+	writeFile(t, repo.workdir, "generated.txt", "new content")
+	setCommonEnvs(t, repo)
+	t.Setenv("check_only", "true")
+	// Check-only mode needs neither credentials nor a PR.
+	t.Setenv("git_token", "")
+	t.Setenv("BITRISE_PULL_REQUEST", "")
+
+	headBefore := runGit(t, repo.workdir, "rev-parse", "HEAD")
+	result, err := runStep(t, repo.workdir)
+
+	require.NoError(t, err)
+	assert.Equal(t, step.OutcomeCheckFailed, result.Outcome)
+	assert.True(t, result.AutofixNeeded)
+	assert.False(t, result.AutofixPushed)
+	assert.Empty(t, result.CommitSHA)
+	assert.Equal(t, headBefore, runGit(t, repo.workdir, "rev-parse", "HEAD"), "no commit should be created")
+	assert.Equal(t, "main", currentBranch(t, repo.workdir))
+	assert.Empty(t, runGit(t, repo.workdir, "diff", "--cached", "--name-only"), "nothing should be staged")
+
+	// The exported patch reproduces the changes on a clean checkout.
+	require.FileExists(t, result.PatchPath)
+	runGit(t, repo.workdir, "reset", "--hard")
+	runGit(t, repo.workdir, "clean", "-fd")
+	runGit(t, repo.workdir, "apply", result.PatchPath)
+	assert.Equal(t, "new content", readFile(t, repo.workdir, "generated.txt"))
+	assert.Equal(t, "# Test\nreformatted\n", readFile(t, repo.workdir, "README.md"))
+}
+
+func TestCheckOnly_NoChanges(t *testing.T) {
+	repo := setupRepo(t)
+	setCommonEnvs(t, repo)
+	t.Setenv("check_only", "true")
+
+	result, err := runStep(t, repo.workdir)
+
+	require.NoError(t, err)
+	assert.False(t, result.AutofixNeeded)
+	assert.Equal(t, step.OutcomeNoChanges, result.Outcome)
+}
+
+// TestCheckOnly_PRWithoutRemote checks that check-only PR builds read the
+// config from the base branch in the clone instead of fetching it.
+func TestCheckOnly_PRWithoutRemote(t *testing.T) {
+	repo := setupRepo(t)
+	writeFile(t, repo.workdir, ".autofix.yml", "paths:\n  exclude:\n    - \"*.log\"\n")
+	runGit(t, repo.workdir, "add", ".")
+	runGit(t, repo.workdir, "commit", "-m", "Add autofix config")
+	runGit(t, repo.workdir, "push", "origin", "main")
+	runGit(t, repo.workdir, "checkout", "-b", "feature")
+	writeFile(t, repo.workdir, ".autofix.yml", "commit:\n  subject: \"style: autofix\"\n")
+	runGit(t, repo.workdir, "commit", "-am", "Loosen autofix config")
+
+	writeFile(t, repo.workdir, "generated.txt", "new content")
+	writeFile(t, repo.workdir, "debug.log", "noise")
+	setCommonEnvs(t, repo)
+	t.Setenv("check_only", "true")
+	t.Setenv("git_token", "")
+	t.Setenv("BITRISE_GIT_BRANCH", "feature")
+	unreachable := "file://" + filepath.Join(t.TempDir(), "missing.git")
+	t.Setenv("GIT_REPOSITORY_URL", unreachable)
+	runGit(t, repo.workdir, "remote", "set-url", "origin", unreachable)
+
+	result, err := runStep(t, repo.workdir)
+
+	require.NoError(t, err)
+	assert.Equal(t, step.OutcomeCheckFailed, result.Outcome)
+	assert.Equal(t, 1, result.FileCount)
+	require.Len(t, result.Excluded, 1)
+	assert.Equal(t, "debug.log", result.Excluded[0].Path, "the config of the base branch applies")
+}
+
 // TestDetachedHEAD simulates a PR build where Bitrise checks out a temporary
 // merge ref instead of the actual branch tip, leaving the repo in detached HEAD.
 // The step must commit on the merge ref and cherry-pick onto the PR branch.
@@ -247,6 +355,1239 @@ func TestMergeRefConflict_FormatterAndBaseChangeSameFile(t *testing.T) {
 
 	require.Error(t, err)
 	assert.ErrorContains(t, err, "cherry-pick failed")
+	assert.Equal(t, step.OutcomeConflict, result.Outcome)
 	assert.True(t, result.AutofixNeeded)
 	assert.False(t, result.AutofixPushed)
 }
+
+// TestConfigFile_ReadFromBaseBranch checks that .autofix.yml is taken from the
+// PR's base branch: the PR branch's own (loosened) copy must be ignored.
+func TestConfigFile_ReadFromBaseBranch(t *testing.T) {
+	repo := setupRepo(t)
+	writeFile(t, repo.workdir, ".autofix.yml", "commit:\n  subject: \"style: autofix\"\npaths:\n  exclude:\n    - \"*.log\"\n")
+	runGit(t, repo.workdir, "add", ".")
+	runGit(t, repo.workdir, "commit", "-m", "Add autofix config")
+	runGit(t, repo.workdir, "push", "origin", "main")
+
+	runGit(t, repo.workdir, "checkout", "-b", "feature")
+	writeFile(t, repo.workdir, ".autofix.yml", "commit:\n  subject: \"style: autofix\"\n")
+	runGit(t, repo.workdir, "commit", "-am", "Loosen autofix config")
+	runGit(t, repo.workdir, "push", "origin", "feature")
+
+	writeFile(t, repo.workdir, "generated.txt", "new content")
+	writeFile(t, repo.workdir, "debug.log", "noise")
+	setCommonEnvs(t, repo)
+	t.Setenv("BITRISE_GIT_BRANCH", "feature")
+	t.Setenv("commit_subject", "") // let the config file provide it
+
+	result, err := runStep(t, repo.workdir)
+
+	require.NoError(t, err)
+	assert.Equal(t, step.OutcomePushed, result.Outcome)
+	assert.Equal(t, 1, result.FileCount)
+	require.Len(t, result.Excluded, 1)
+	assert.Equal(t, "debug.log", result.Excluded[0].Path)
+	assert.Equal(t, "style: autofix", latestCommitSubject(t, repo.workdir))
+	assert.Equal(t, "generated.txt", runGit(t, repo.remoteDir, "diff-tree", "--no-commit-id", "--name-only", "-r", "feature"))
+	assert.NoFileExists(t, repo.workdir+"/debug.log", "excluded changes are reverted")
+}
+
+func TestConfigFile_InputOverridesFile(t *testing.T) {
+	repo := setupRepo(t)
+	writeFile(t, repo.workdir, ".autofix.yml", "commit:\n  subject: \"style: autofix\"\n")
+	runGit(t, repo.workdir, "add", ".")
+	runGit(t, repo.workdir, "commit", "-m", "Add autofix config")
+	runGit(t, repo.workdir, "push", "origin", "main")
+
+	writeFile(t, repo.workdir, "generated.txt", "new content")
+	setCommonEnvs(t, repo)
+
+	_, err := runStep(t, repo.workdir)
+
+	require.NoError(t, err)
+	assert.Equal(t, "Test Autofix", latestCommitSubject(t, repo.workdir))
+}
+
+func TestConfigFile_ChangedByAutofix_SecurityError(t *testing.T) {
+	repo := setupRepo(t)
+	writeFile(t, repo.workdir, ".autofix.yml", "protected_paths:\n  - \"*.lock\"\n")
+	runGit(t, repo.workdir, "add", ".")
+	runGit(t, repo.workdir, "commit", "-m", "Add autofix config")
+	runGit(t, repo.workdir, "push", "origin", "main")
+
+	writeFile(t, repo.workdir, ".autofix.yml", "")
+	setCommonEnvs(t, repo)
+
+	result, err := runStep(t, repo.workdir)
+
+	require.Error(t, err)
+	assert.ErrorContains(t, err, "protected path \".autofix.yml\"")
+	assert.Equal(t, step.OutcomeSecurityBlocked, result.Outcome)
+}
+
+func TestMaxAutofixCommits_LoopLimit(t *testing.T) {
+	repo := setupRepo(t)
+	writeFile(t, repo.workdir, "generated.txt", "first")
+	setCommonEnvs(t, repo)
+	t.Setenv("max_autofix_commits", "1")
+
+	result, err := runStep(t, repo.workdir)
+	require.NoError(t, err)
+	require.Equal(t, step.OutcomePushed, result.Outcome)
+	assert.Contains(t, runGit(t, repo.remoteDir, "log", "-1", "--format=%B", "main"), "Autofix-Round: 1")
+
+	// The next build of the autofix commit finds changes again: the tools oscillate.
+	writeFile(t, repo.workdir, "generated.txt", "second")
+	result, err = runStep(t, repo.workdir)
+
+	require.Error(t, err)
+	assert.Equal(t, step.OutcomeLoopLimit, result.Outcome)
+	assert.Equal(t, 2, commitCount(t, repo.remoteDir), "nothing should be pushed")
+}
+
+func TestFixCommands_AttributeChangesPerTool(t *testing.T) {
+	repo := setupRepo(t)
+	setCommonEnvs(t, repo)
+	t.Setenv("fix_commands", "upper: printf '# TEST REPO' > README.md\nnotes: printf 'generated' > notes.txt")
+
+	result, err := runStep(t, repo.workdir)
+
+	require.NoError(t, err)
+	require.Equal(t, step.OutcomePushed, result.Outcome)
+	require.Len(t, result.Files, 2)
+	assert.Equal(t, "README.md", result.Files[0].Path)
+	assert.Equal(t, []string{"upper"}, result.Files[0].Tools)
+	assert.Equal(t, "notes.txt", result.Files[1].Path)
+	assert.Equal(t, []string{"notes"}, result.Files[1].Tools)
+
+	body := runGit(t, repo.remoteDir, "log", "-1", "--format=%B", "main")
+	assert.Contains(t, body, "- README.md (upper)")
+	assert.Contains(t, body, "- notes.txt (notes)")
+}
+
+func TestFixCommands_Failure(t *testing.T) {
+	repo := setupRepo(t)
+	setCommonEnvs(t, repo)
+	t.Setenv("fix_commands", "broken: exit 3")
+
+	result, err := runStep(t, repo.workdir)
+
+	require.Error(t, err)
+	assert.Equal(t, step.OutcomeFixFailed, result.Outcome)
+	assert.Equal(t, 1, commitCount(t, repo.remoteDir), "nothing should be pushed")
+}
+
+func TestFixCommands_UntilStable(t *testing.T) {
+	repo := setupRepo(t)
+	setCommonEnvs(t, repo)
+	// The second command only has something to do after the first one ran,
+	// and the first one's input only exists after the second one ran.
+	t.Setenv("fix_commands", "copy: if [ -f b.txt ]; then cp b.txt c.txt; fi\ncreate: printf 'b' > b.txt")
+	t.Setenv("fix_until_stable", "true")
+
+	result, err := runStep(t, repo.workdir)
+
+	require.NoError(t, err)
+	require.Equal(t, step.OutcomePushed, result.Outcome)
+	assert.Equal(t, "b", readFile(t, repo.workdir, "c.txt"))
+}
+
+func TestFixCommands_Oscillating(t *testing.T) {
+	repo := setupRepo(t)
+	setCommonEnvs(t, repo)
+	t.Setenv("fix_commands", "tabs: printf 'tabs' > style.txt\nspaces: printf 'spaces' > style.txt")
+	t.Setenv("fix_until_stable", "true")
+	t.Setenv("fix_max_passes", "3")
+
+	result, err := runStep(t, repo.workdir)
+
+	require.Error(t, err)
+	assert.ErrorContains(t, err, "did not reach a fixed point")
+	assert.Equal(t, step.OutcomeFixFailed, result.Outcome)
+	assert.Equal(t, 1, commitCount(t, repo.remoteDir), "nothing should be pushed")
+}
+
+func TestFixCommands_UntilStableNeedsTwoPasses(t *testing.T) {
+	repo := setupRepo(t)
+	setCommonEnvs(t, repo)
+	t.Setenv("fix_commands", "create: printf 'b' > b.txt")
+	t.Setenv("fix_until_stable", "true")
+	t.Setenv("fix_max_passes", "1")
+
+	_, err := runStep(t, repo.workdir)
+
+	require.Error(t, err)
+	assert.ErrorContains(t, err, "fix_max_passes must be at least 2 with fix_until_stable")
+	assert.NoFileExists(t, filepath.Join(repo.workdir, "b.txt"))
+}
+
+func TestValidateCommands_Passed(t *testing.T) {
+	repo := setupRepo(t)
+	writeFile(t, repo.workdir, "generated.txt", "valid code")
+	setCommonEnvs(t, repo)
+	t.Setenv("validate_commands", "check: grep -q valid generated.txt")
+
+	result, err := runStep(t, repo.workdir)
+
+	require.NoError(t, err)
+	assert.Equal(t, step.OutcomePushed, result.Outcome)
+	assert.Equal(t, 2, commitCount(t, repo.remoteDir))
+}
+
+func TestValidateCommands_Failed(t *testing.T) {
+	repo := setupRepo(t)
+	writeFile(t, repo.workdir, "generated.txt", "broken code")
+	setCommonEnvs(t, repo)
+	t.Setenv("validate_commands", "check: ! grep -q broken generated.txt")
+
+	initialHead := runGit(t, repo.remoteDir, "rev-parse", "main")
+	result, err := runStep(t, repo.workdir)
+
+	require.Error(t, err)
+	assert.Equal(t, step.OutcomeValidationFailed, result.Outcome)
+	assert.Empty(t, result.CommitSHA)
+	assert.Equal(t, 1, commitCount(t, repo.remoteDir), "nothing should be pushed")
+	assert.Equal(t, initialHead, runGit(t, repo.workdir, "rev-parse", "HEAD"), "the autofix commit should be discarded")
+	assert.NoFileExists(t, filepath.Join(repo.workdir, "generated.txt"))
+}
+
+func TestHooks_PreCommitChangesAreCommitted(t *testing.T) {
+	repo := setupRepo(t)
+	writeFile(t, repo.workdir, "generated.txt", "new content")
+	setCommonEnvs(t, repo)
+	t.Setenv("pre_commit_command", `sed 's/^/- /' "$AUTOFIX_FILE_LIST_PATH" > CHANGELOG.md`)
+
+	result, err := runStep(t, repo.workdir)
+
+	require.NoError(t, err)
+	require.Equal(t, step.OutcomePushed, result.Outcome)
+	assert.Equal(t, "- generated.txt", runGit(t, repo.remoteDir, "show", "main:CHANGELOG.md"))
+}
+
+func TestHooks_PreCommitChangesAreChecked(t *testing.T) {
+	tests := []struct {
+		name    string
+		envs    map[string]string
+		hook    string
+		outcome step.Outcome
+		errMsg  string
+	}{
+		{
+			name:    "CI config",
+			hook:    "printf 'format_version: 13' > bitrise.yml",
+			outcome: step.OutcomeSecurityBlocked,
+			errMsg:  "bitrise.yml",
+		},
+		{
+			name:    "protected path",
+			envs:    map[string]string{"protected_paths": "*.lock"},
+			hook:    "printf 'lock' > deps.lock",
+			outcome: step.OutcomeSecurityBlocked,
+			errMsg:  "deps.lock",
+		},
+		{
+			name:    "excluded path",
+			envs:    map[string]string{"exclude_paths": "*.log"},
+			hook:    "printf 'log' > build.log",
+			outcome: step.OutcomeHookFailed,
+			errMsg:  "build.log",
+		},
+		{
+			name:    "deletion",
+			envs:    map[string]string{"deletions": "deny"},
+			hook:    "rm README.md",
+			outcome: step.OutcomeDeletionBlocked,
+			errMsg:  "README.md",
+		},
+		{
+			name:    "limit",
+			envs:    map[string]string{"max_files": "1"},
+			hook:    "printf 'changes' > CHANGELOG.md",
+			outcome: step.OutcomeLimitExceeded,
+			errMsg:  "limit",
+		},
+		{
+			name:    "syntax",
+			hook:    "printf '{\"broken\": ' > config.json",
+			outcome: step.OutcomeInvalidFiles,
+			errMsg:  "no longer parse",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			repo := setupRepo(t)
+			writeFile(t, repo.workdir, "generated.txt", "new content")
+			setCommonEnvs(t, repo)
+			for k, v := range tt.envs {
+				t.Setenv(k, v)
+			}
+			t.Setenv("pre_commit_command", tt.hook)
+
+			result, err := runStep(t, repo.workdir)
+
+			require.Error(t, err)
+			assert.Contains(t, err.Error(), tt.errMsg)
+			assert.Equal(t, tt.outcome, result.Outcome)
+			assert.Equal(t, 1, commitCount(t, repo.remoteDir), "nothing should be pushed")
+		})
+	}
+}
+
+func TestHooks_PrePushFailureAborts(t *testing.T) {
+	repo := setupRepo(t)
+	writeFile(t, repo.workdir, "generated.txt", "new content")
+	setCommonEnvs(t, repo)
+	t.Setenv("pre_push_command", `test "$AUTOFIX_BRANCH" = main && test -n "$AUTOFIX_COMMIT_SHA" && exit 1`)
+
+	result, err := runStep(t, repo.workdir)
+
+	require.Error(t, err)
+	assert.Equal(t, step.OutcomeHookFailed, result.Outcome)
+	assert.Equal(t, 1, commitCount(t, repo.remoteDir), "nothing should be pushed")
+}
+
+func TestHooks_PostPushFailureIsIgnored(t *testing.T) {
+	repo := setupRepo(t)
+	writeFile(t, repo.workdir, "generated.txt", "new content")
+	setCommonEnvs(t, repo)
+	out := filepath.Join(t.TempDir(), "pushed.txt")
+	t.Setenv("post_push_command", `echo "$AUTOFIX_COMMIT_SHA" > `+out+` && exit 1`)
+
+	result, err := runStep(t, repo.workdir)
+
+	require.NoError(t, err)
+	assert.Equal(t, step.OutcomePushed, result.Outcome)
+	assert.Equal(t, result.CommitSHA+"\n", readFile(t, filepath.Dir(out), "pushed.txt"))
+}
+
+// fakePreCommit stands in for `pre-commit run <id> --config <file> --color never <scope...>`.
+// It logs the hook and scope it was run with, and only knows hooks of the given config.
+const fakePreCommit = `id="$2"; cfg="$4"; shift 6
+echo "$id $*" >> "$PRE_COMMIT_LOG"
+grep -q "id: $id" "$cfg" || exit 3
+case "$id" in
+  upper) printf '# TEST REPO' > README.md; exit 1;;
+  lint) exit 1;;
+esac
+`
+
+func TestPreCommit_PRFiles(t *testing.T) {
+	repo := setupRepo(t)
+	writeFile(t, repo.workdir, ".pre-commit-config.yaml", "repos:\n  - repo: local\n    hooks:\n      - id: upper\n      - id: lint\n      - id: clean\n")
+	runGit(t, repo.workdir, "add", ".")
+	runGit(t, repo.workdir, "commit", "-m", "Add pre-commit config")
+	runGit(t, repo.workdir, "push", "origin", "main")
+
+	// The PR drops the hooks from its own copy of the config, which must be ignored.
+	runGit(t, repo.workdir, "checkout", "-b", "feature")
+	writeFile(t, repo.workdir, ".pre-commit-config.yaml", "repos:\n  - repo: local\n    hooks:\n      - id: clean\n")
+	writeFile(t, repo.workdir, "feature.txt", "feature work")
+	runGit(t, repo.workdir, "add", ".")
+	runGit(t, repo.workdir, "commit", "-m", "Feature commit")
+	runGit(t, repo.workdir, "push", "origin", "feature")
+	runGit(t, repo.workdir, "checkout", "main")
+	runGit(t, repo.workdir, "merge", "--no-ff", "feature", "-m", "Local merge commit")
+
+	installFakeTool(t, "pre-commit", fakePreCommit)
+	preCommitLog := filepath.Join(t.TempDir(), "pre-commit.log")
+	setCommonEnvs(t, repo)
+	t.Setenv("PRE_COMMIT_LOG", preCommitLog)
+	t.Setenv("BITRISE_GIT_BRANCH", "feature")
+	t.Setenv("pre_commit", "pr_files")
+
+	result, err := runStep(t, repo.workdir)
+
+	require.NoError(t, err)
+	require.Equal(t, step.OutcomePushed, result.Outcome)
+	require.Len(t, result.Files, 1)
+	assert.Equal(t, "README.md", result.Files[0].Path)
+	assert.Equal(t, []string{"upper"}, result.Files[0].Tools)
+
+	ran := readFile(t, filepath.Dir(preCommitLog), "pre-commit.log")
+	assert.Equal(t, "upper --files .pre-commit-config.yaml feature.txt\nlint --files .pre-commit-config.yaml feature.txt\nclean --files .pre-commit-config.yaml feature.txt\n", ran)
+
+	body := runGit(t, repo.remoteDir, "log", "-1", "--format=%B", "feature")
+	assert.Contains(t, body, "- README.md (upper)")
+	assert.Contains(t, body, "pre-commit hooks:\n- upper: modified 1 file(s)\n- lint: failed\n- clean: passed")
+}
+
+func TestPreCommit_MissingConfig(t *testing.T) {
+	repo := setupRepo(t)
+	installFakeTool(t, "pre-commit", fakePreCommit)
+	setCommonEnvs(t, repo)
+	t.Setenv("pre_commit", "all_files")
+
+	result, err := runStep(t, repo.workdir)
+
+	require.Error(t, err)
+	assert.ErrorContains(t, err, "no .pre-commit-config.yaml found")
+	assert.Equal(t, step.OutcomeFixFailed, result.Outcome)
+}
+
+func TestScope_PRFiles(t *testing.T) {
+	repo := setupRepo(t)
+	setupPRMergeRef(t, repo, map[string]string{"base.txt": "base\n"}, map[string]string{"feature.txt": "feature\n"})
+	writeFile(t, repo.workdir, "base.txt", "BASE\n")
+	writeFile(t, repo.workdir, "feature.txt", "FEATURE\n")
+	setCommonEnvs(t, repo)
+	t.Setenv("BITRISE_GIT_BRANCH", "feature")
+	t.Setenv("scope", "pr_files")
+
+	result, err := runStep(t, repo.workdir)
+
+	require.NoError(t, err)
+	require.Equal(t, step.OutcomePushed, result.Outcome)
+	assert.Equal(t, 1, result.FileCount)
+	require.Len(t, result.Excluded, 1)
+	assert.Equal(t, "base.txt", result.Excluded[0].Path)
+	assert.Equal(t, "not changed by the PR", result.Excluded[0].Reason)
+	assert.Equal(t, "feature.txt", runGit(t, repo.remoteDir, "diff-tree", "--no-commit-id", "--name-only", "-r", "feature"))
+}
+
+// numberedLines returns "line 1\n" ... "line n\n", with the given lines replaced.
+func numberedLines(n int, replace map[int]string) string {
+	var sb strings.Builder
+	for i := 1; i <= n; i++ {
+		if r, ok := replace[i]; ok {
+			sb.WriteString(r + "\n")
+		} else {
+			fmt.Fprintf(&sb, "line %d\n", i)
+		}
+	}
+	return sb.String()
+}
+
+func TestScope_PRHunks(t *testing.T) {
+	repo := setupRepo(t)
+	setupPRMergeRef(t, repo,
+		map[string]string{"code.txt": numberedLines(30, nil), "other.txt": "other\n"},
+		map[string]string{"code.txt": numberedLines(30, map[int]string{5: "pr change"})},
+	)
+	// The formatter touches the line the PR changed, unrelated lines of the
+	// same file around it, and a file the PR didn't change.
+	writeFile(t, repo.workdir, "code.txt", numberedLines(30, map[int]string{2: "LINE 2\nINSERTED", 5: "PR CHANGE", 25: "LINE 25"}))
+	writeFile(t, repo.workdir, "other.txt", "OTHER\n")
+	setCommonEnvs(t, repo)
+	t.Setenv("BITRISE_GIT_BRANCH", "feature")
+	t.Setenv("scope", "pr_hunks")
+
+	result, err := runStep(t, repo.workdir)
+
+	require.NoError(t, err)
+	require.Equal(t, step.OutcomePushed, result.Outcome)
+	assert.Equal(t, 1, result.FileCount)
+	require.Len(t, result.Excluded, 2)
+	assert.Equal(t, "other.txt", result.Excluded[0].Path)
+	assert.Equal(t, "code.txt", result.Excluded[1].Path)
+	assert.Equal(t, 2, result.Excluded[1].Hunks)
+
+	committed := runGit(t, repo.remoteDir, "show", "feature:code.txt") + "\n"
+	assert.Equal(t, numberedLines(30, map[int]string{5: "PR CHANGE"}), committed)
+	assert.Equal(t, "code.txt", runGit(t, repo.remoteDir, "diff-tree", "--no-commit-id", "--name-only", "-r", "feature"))
+}
+
+func TestScope_PRHunks_CheckOnlyKeepsWorkingTree(t *testing.T) {
+	repo := setupRepo(t)
+	setupPRMergeRef(t, repo,
+		map[string]string{"code.txt": numberedLines(30, nil)},
+		map[string]string{"code.txt": numberedLines(30, map[int]string{5: "pr change"})},
+	)
+	formatted := numberedLines(30, map[int]string{5: "PR CHANGE", 25: "LINE 25"})
+	writeFile(t, repo.workdir, "code.txt", formatted)
+	setCommonEnvs(t, repo)
+	t.Setenv("scope", "pr_hunks")
+	t.Setenv("check_only", "true")
+
+	result, err := runStep(t, repo.workdir)
+
+	require.NoError(t, err)
+	assert.Equal(t, step.OutcomeCheckFailed, result.Outcome)
+	require.Len(t, result.Diff, 1)
+	assert.Equal(t, 1, result.Diff[0].Added, "only the hunk inside the PR's changes is reported")
+	assert.Equal(t, formatted, readFile(t, repo.workdir, "code.txt"))
+}
+
+func TestSnapshot_IgnoresEarlierChanges(t *testing.T) {
+	repo := setupRepo(t)
+	writeFile(t, repo.workdir, "code.txt", numberedLines(30, nil))
+	writeFile(t, repo.workdir, "deps.lock", "v1\n")
+	runGit(t, repo.workdir, "add", ".")
+	runGit(t, repo.workdir, "commit", "-m", "Add code")
+	runGit(t, repo.workdir, "push", "origin", "main")
+
+	// Dependency install and cache restore, before the snapshot.
+	writeFile(t, repo.workdir, "deps.lock", "v2\n")
+	writeFile(t, repo.workdir, "code.txt", numberedLines(30, map[int]string{2: "local patch"}))
+	writeFile(t, repo.workdir, "cache.bin", "cache")
+	setCommonEnvs(t, repo)
+	t.Setenv("mode", "snapshot")
+
+	result, err := runStep(t, repo.workdir)
+	require.NoError(t, err)
+	require.Equal(t, step.OutcomeSnapshot, result.Outcome)
+	require.FileExists(t, result.SnapshotPath)
+
+	// The formatter, after the snapshot.
+	writeFile(t, repo.workdir, "code.txt", numberedLines(30, map[int]string{2: "local patch", 20: "LINE 20"}))
+	writeFile(t, repo.workdir, "generated.txt", "generated")
+	t.Setenv("mode", "run")
+	t.Setenv("snapshot_path", result.SnapshotPath)
+
+	result, err = runStep(t, repo.workdir)
+
+	require.NoError(t, err)
+	require.Equal(t, step.OutcomePushed, result.Outcome)
+	assert.Equal(t, 2, result.FileCount)
+	var excluded []string
+	for _, f := range result.Excluded {
+		excluded = append(excluded, f.Path)
+		assert.Equal(t, "unchanged since the snapshot", f.Reason)
+	}
+	assert.ElementsMatch(t, []string{"deps.lock", "cache.bin"}, excluded)
+
+	committed := runGit(t, repo.remoteDir, "show", "main:code.txt") + "\n"
+	assert.Equal(t, numberedLines(30, map[int]string{20: "LINE 20"}), committed, "changes from before the snapshot are left out")
+	assert.Equal(t, "code.txt\ngenerated.txt", runGit(t, repo.remoteDir, "diff-tree", "--no-commit-id", "--name-only", "-r", "main"))
+}
+
+func TestSnapshot_HeadMoved(t *testing.T) {
+	repo := setupRepo(t)
+	setCommonEnvs(t, repo)
+	t.Setenv("mode", "snapshot")
+	result, err := runStep(t, repo.workdir)
+	require.NoError(t, err)
+
+	writeFile(t, repo.workdir, "new.txt", "new")
+	runGit(t, repo.workdir, "add", ".")
+	runGit(t, repo.workdir, "commit", "-m", "Commit after the snapshot")
+	writeFile(t, repo.workdir, "generated.txt", "generated")
+	t.Setenv("mode", "run")
+	t.Setenv("snapshot_path", result.SnapshotPath)
+
+	_, err = runStep(t, repo.workdir)
+
+	assert.ErrorContains(t, err, "the snapshot was taken at commit")
+}
+
+func TestLimits_Exceeded(t *testing.T) {
+	repo := setupRepo(t)
+	writeFile(t, repo.workdir, "a.txt", "a")
+	writeFile(t, repo.workdir, "b.txt", "b")
+	writeFile(t, repo.workdir, "image.bin", "\x00\x01\x02")
+	setCommonEnvs(t, repo)
+	t.Setenv("max_files", "2")
+	t.Setenv("allow_binary_files", "false")
+
+	result, err := runStep(t, repo.workdir)
+
+	require.Error(t, err)
+	assert.Equal(t, step.OutcomeLimitExceeded, result.Outcome)
+	assert.Equal(t, []step.LimitViolation{
+		{Limit: "max_files", Value: 3, Max: 2},
+		{Limit: "allow_binary_files", Path: "image.bin"},
+	}, result.LimitViolations)
+	assert.Equal(t, 1, commitCount(t, repo.remoteDir), "nothing should be pushed")
+	assert.Contains(t, readFile(t, filepath.Dir(result.ReportPath), filepath.Base(result.ReportPath)), `"limit": "max_files"`)
+}
+
+func TestLimits_PatchOnly(t *testing.T) {
+	repo := setupRepo(t)
+	writeFile(t, repo.workdir, "generated.txt", "one\ntwo\nthree\n")
+	setCommonEnvs(t, repo)
+	t.Setenv("max_changed_lines", "2")
+	t.Setenv("on_limit_exceeded", "patch_only")
+
+	result, err := runStep(t, repo.workdir)
+
+	require.NoError(t, err)
+	assert.Equal(t, step.OutcomeLimitExceeded, result.Outcome)
+	assert.True(t, result.PatchOnly)
+	assert.FileExists(t, result.PatchPath)
+	assert.Equal(t, 1, commitCount(t, repo.remoteDir), "nothing should be pushed")
+}
+
+func setupRepoWithFiles(t *testing.T, files map[string]string) gitRepo {
+	t.Helper()
+	repo := setupRepo(t)
+	for name, content := range files {
+		writeFile(t, repo.workdir, name, content)
+	}
+	runGit(t, repo.workdir, "add", ".")
+	runGit(t, repo.workdir, "commit", "-m", "Add files")
+	runGit(t, repo.workdir, "push", "origin", "main")
+	return repo
+}
+
+func TestDeletions_Deny(t *testing.T) {
+	repo := setupRepoWithFiles(t, map[string]string{"app.go": "package main\n"})
+	require.NoError(t, os.Remove(filepath.Join(repo.workdir, "app.go")))
+	writeFile(t, repo.workdir, "README.md", "# Test repo\n")
+	setCommonEnvs(t, repo)
+	t.Setenv("deletions", "deny")
+
+	result, err := runStep(t, repo.workdir)
+
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "app.go")
+	assert.Equal(t, step.OutcomeDeletionBlocked, result.Outcome)
+	assert.Equal(t, 2, commitCount(t, repo.remoteDir), "nothing should be pushed")
+}
+
+func TestDeletions_DenyRename(t *testing.T) {
+	repo := setupRepoWithFiles(t, map[string]string{"app.go": "package main\n"})
+	// A fix command that moves the file out of the way.
+	runGit(t, repo.workdir, "mv", "app.go", "app_gen.go")
+	setCommonEnvs(t, repo)
+	t.Setenv("deletions", "deny")
+
+	result, err := runStep(t, repo.workdir)
+
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "app.go -> app_gen.go")
+	assert.Equal(t, step.OutcomeDeletionBlocked, result.Outcome)
+	assert.Equal(t, 2, commitCount(t, repo.remoteDir), "nothing should be pushed")
+}
+
+func TestDeletions_ReportOnly(t *testing.T) {
+	repo := setupRepoWithFiles(t, map[string]string{
+		"app.go":    "package main\n",
+		"old.pb.go": "package main\n",
+	})
+	require.NoError(t, os.Remove(filepath.Join(repo.workdir, "app.go")))
+	require.NoError(t, os.Remove(filepath.Join(repo.workdir, "old.pb.go")))
+	writeFile(t, repo.workdir, "README.md", "# Test repo\n")
+	setCommonEnvs(t, repo)
+	t.Setenv("deletions", "report_only")
+	t.Setenv("deletion_allowlist", "*.pb.go")
+
+	result, err := runStep(t, repo.workdir)
+
+	require.NoError(t, err)
+	assert.Equal(t, step.OutcomePushed, result.Outcome)
+	require.Len(t, result.Excluded, 1)
+	assert.Equal(t, "app.go", result.Excluded[0].Path)
+
+	tree := runGit(t, repo.remoteDir, "ls-tree", "-r", "--name-only", "main")
+	assert.Contains(t, tree, "app.go", "the deletion should not be committed")
+	assert.NotContains(t, tree, "old.pb.go", "allowlisted deletions are committed")
+	message := runGit(t, repo.remoteDir, "log", "-1", "--format=%B", "main")
+	assert.Contains(t, message, "Modified files:\n- README.md\n\nDeleted files:\n- old.pb.go\n")
+}
+
+func TestExcludeNoise(t *testing.T) {
+	repo := setupRepoWithFiles(t, map[string]string{
+		"crlf.txt":   "one\ntwo\n",
+		"eof.txt":    "one\ntwo\n",
+		"spaces.txt": "a  b\n",
+		"bom.txt":    "hello\n",
+		"bom-ws.txt": "hello world\n",
+		"run.sh":     "echo hi\n",
+		"real.txt":   "one\n",
+		"indent.py":  "if x:\n    y()\nz()\n",
+		"joined.txt": "a b\n",
+	})
+	writeFile(t, repo.workdir, "crlf.txt", "one\r\ntwo\r\n")
+	writeFile(t, repo.workdir, "eof.txt", "one\ntwo")
+	writeFile(t, repo.workdir, "spaces.txt", "a  b \n\n")
+	writeFile(t, repo.workdir, "bom.txt", "\xef\xbb\xbfhello\n")
+	writeFile(t, repo.workdir, "bom-ws.txt", "\xef\xbb\xbfhello world  \r\n")
+	require.NoError(t, os.Chmod(filepath.Join(repo.workdir, "run.sh"), 0755))
+	writeFile(t, repo.workdir, "real.txt", "two\n")
+	writeFile(t, repo.workdir, "indent.py", "if x:\n    y()\n    z()\n")
+	writeFile(t, repo.workdir, "joined.txt", "ab\n")
+	setCommonEnvs(t, repo)
+	t.Setenv("exclude_noise", "true")
+
+	result, err := runStep(t, repo.workdir)
+
+	require.NoError(t, err)
+	assert.Equal(t, step.OutcomePushed, result.Outcome)
+	var committed []string
+	for _, f := range result.Files {
+		committed = append(committed, f.Path)
+	}
+	assert.Equal(t, []string{"indent.py", "joined.txt", "real.txt"}, committed, "indentation and spaces within a line are real changes")
+	reasons := map[string]string{}
+	for _, f := range result.Excluded {
+		reasons[f.Path] = f.Reason
+	}
+	assert.Equal(t, map[string]string{
+		"crlf.txt":   "line ending change only",
+		"eof.txt":    "line ending change only",
+		"spaces.txt": "whitespace change only",
+		"bom.txt":    "byte order mark change only",
+		"bom-ws.txt": "byte order mark and whitespace change only",
+		"run.sh":     "file mode change only",
+	}, reasons)
+	assert.Equal(t, "", runGit(t, repo.workdir, "status", "--porcelain"), "the noise should be reverted")
+}
+
+func TestExcludeNoise_GlobCharactersInFileName(t *testing.T) {
+	repo := setupRepoWithFiles(t, map[string]string{
+		"[id].tsx": "export default 1\n",
+		"i.tsx":    "export default 2\n",
+	})
+	writeFile(t, repo.workdir, "[id].tsx", "export default 1\r\n")
+	writeFile(t, repo.workdir, "i.tsx", "export default 3\n")
+	setCommonEnvs(t, repo)
+	t.Setenv("exclude_noise", "true")
+
+	result, err := runStep(t, repo.workdir)
+
+	require.NoError(t, err)
+	assert.Equal(t, step.OutcomePushed, result.Outcome)
+	require.Len(t, result.Excluded, 1, "the real change of i.tsx must not count for [id].tsx")
+	assert.Equal(t, "[id].tsx", result.Excluded[0].Path)
+	assert.Equal(t, "i.tsx", runGit(t, repo.remoteDir, "show", "--format=", "--name-only", "HEAD"))
+}
+
+func TestIgnoreHunkPatterns_OnlyIgnoredChanges(t *testing.T) {
+	repo := setupRepoWithFiles(t, map[string]string{"api.pb.go": "// Generated at 2026-01-01\npackage api\n"})
+	writeFile(t, repo.workdir, "api.pb.go", "// Generated at 2026-10-18\npackage api\n")
+	setCommonEnvs(t, repo)
+	t.Setenv("ignore_hunk_patterns", "^// Generated at ")
+
+	result, err := runStep(t, repo.workdir)
+
+	require.NoError(t, err)
+	assert.Equal(t, step.OutcomeNoChanges, result.Outcome)
+	require.Len(t, result.Excluded, 1)
+	assert.Equal(t, "matching ignore_hunk_patterns", result.Excluded[0].Reason)
+	assert.Equal(t, 2, commitCount(t, repo.remoteDir), "nothing should be pushed")
+}
+
+func TestIgnoreHunkPatterns_HunkMode(t *testing.T) {
+	original := "// Generated at 2026-01-01\n" + numberedLines(20, nil)
+	repo := setupRepoWithFiles(t, map[string]string{"api.pb.go": original})
+	writeFile(t, repo.workdir, "api.pb.go", "// Generated at 2026-10-18\n"+numberedLines(20, map[int]string{15: "fixed 15"}))
+	setCommonEnvs(t, repo)
+	t.Setenv("ignore_hunk_patterns", "^// Generated at ")
+	t.Setenv("ignore_hunk_mode", "hunk")
+
+	result, err := runStep(t, repo.workdir)
+
+	require.NoError(t, err)
+	assert.Equal(t, step.OutcomePushed, result.Outcome)
+	require.Len(t, result.Excluded, 1)
+	assert.Equal(t, "1 hunk(s) matching ignore_hunk_patterns", result.Excluded[0].Reason)
+	committed := runGit(t, repo.remoteDir, "show", "main:api.pb.go")
+	assert.True(t, strings.HasPrefix(committed, "// Generated at 2026-01-01\n"), "the timestamp change should be reverted")
+	assert.Contains(t, committed, "fixed 15")
+}
+
+func TestValidateSyntax_Broken(t *testing.T) {
+	repo := setupRepoWithFiles(t, map[string]string{
+		"config.json":         `{"a": 1}`,
+		"Localizable.strings": "\xff\xfe\"\x00a\x00\"\x00",
+		"settings.toml":       "name = \"x\"\n",
+	})
+	writeFile(t, repo.workdir, "config.json", `{"a": 1, "b": `)
+	writeFile(t, repo.workdir, "Localizable.strings", `"a" = "b";`)
+	writeFile(t, repo.workdir, "settings.toml", "name = \"y\"\n")
+	writeFile(t, repo.workdir, "new.yml", "a: [1\n")
+	setCommonEnvs(t, repo)
+
+	result, err := runStep(t, repo.workdir)
+
+	require.Error(t, err)
+	assert.Equal(t, step.OutcomeInvalidFiles, result.Outcome)
+	paths := map[string]string{}
+	for _, f := range result.InvalidFiles {
+		paths[f.Path] = f.Problem
+	}
+	require.Len(t, paths, 3, result.InvalidFiles)
+	assert.Contains(t, paths["config.json"], "doesn't parse")
+	assert.Equal(t, "the encoding changed from UTF-16LE to UTF-8", paths["Localizable.strings"])
+	assert.Contains(t, paths["new.yml"], "doesn't parse")
+	assert.Equal(t, 2, commitCount(t, repo.remoteDir), "nothing should be pushed")
+}
+
+func TestValidateSyntax_PathWithSpace(t *testing.T) {
+	repo := setupRepoWithFiles(t, map[string]string{"app config.json": `{"a": 1}`, "notes ü.txt": "one\n"})
+	writeFile(t, repo.workdir, "app config.json", `{"a": 2}`)
+	writeFile(t, repo.workdir, "notes ü.txt", "two\n")
+	setCommonEnvs(t, repo)
+	t.Setenv("validate_syntax", "true")
+
+	result, err := runStep(t, repo.workdir)
+
+	require.NoError(t, err)
+	assert.Equal(t, step.OutcomePushed, result.Outcome)
+	assert.Empty(t, result.InvalidFiles)
+}
+
+func TestValidateSyntax_AlreadyBroken(t *testing.T) {
+	repo := setupRepoWithFiles(t, map[string]string{"template.json": `{"name": {{NAME}}}`})
+	writeFile(t, repo.workdir, "template.json", `{"name": {{NAME}}, "id": {{ID}}}`)
+	setCommonEnvs(t, repo)
+
+	result, err := runStep(t, repo.workdir)
+
+	require.NoError(t, err)
+	assert.Equal(t, step.OutcomePushed, result.Outcome)
+	assert.Empty(t, result.InvalidFiles)
+}
+
+// fakeGitLFS stands in for git-lfs: the clean filter stores the content under
+// .git/lfs/objects and outputs a pointer, push logs its arguments and the
+// password the credential helper gives it.
+const fakeGitLFS = `
+case "$1" in
+version) echo "git-lfs/3.5.1 (fake)" ;;
+install)
+  git config filter.lfs.clean "git-lfs clean -- %f"
+  git config filter.lfs.smudge "git-lfs smudge -- %f"
+  git config filter.lfs.required true ;;
+clean)
+  tmp=$(mktemp); cat > "$tmp"
+  if head -n1 "$tmp" | grep -q '^version https://git-lfs'; then cat "$tmp"; rm "$tmp"; exit 0; fi
+  oid=$(sha256sum "$tmp" | cut -d' ' -f1); size=$(wc -c < "$tmp" | tr -d ' ')
+  dir="$(git rev-parse --git-dir)/lfs/objects"; mkdir -p "$dir"; mv "$tmp" "$dir/$oid"
+  printf 'version https://git-lfs.github.com/spec/v1\noid sha256:%s\nsize %s\n' "$oid" "$size" ;;
+smudge)
+  tmp=$(mktemp); cat > "$tmp"
+  oid=$(sed -n 's/^oid sha256://p' "$tmp"); obj="$(git rev-parse --git-dir)/lfs/objects/$oid"
+  if [ -n "$oid" ] && [ -f "$obj" ]; then cat "$obj"; else cat "$tmp"; fi; rm "$tmp" ;;
+push)
+  shift; echo "push $*" >> "$FAKE_LFS_LOG"
+  printf 'protocol=https\nhost=example.com\n\n' | GIT_TERMINAL_PROMPT=0 git credential fill | grep '^password=' >> "$FAKE_LFS_LOG" ;;
+esac
+`
+
+func TestLFS_CommitsPointerAndPushesObject(t *testing.T) {
+	installFakeTool(t, "git-lfs", fakeGitLFS)
+	repo := setupRepo(t)
+	runGit(t, repo.workdir, "lfs", "install", "--local")
+	writeFile(t, repo.workdir, ".gitattributes", "*.bin filter=lfs diff=lfs merge=lfs -text\n")
+	writeFile(t, repo.workdir, "model.bin", "weights v1")
+	runGit(t, repo.workdir, "add", ".")
+	runGit(t, repo.workdir, "commit", "-m", "Add model")
+	runGit(t, repo.workdir, "push", "origin", "main")
+	writeFile(t, repo.workdir, "model.bin", "weights v2")
+	setCommonEnvs(t, repo)
+	lfsLog := filepath.Join(t.TempDir(), "lfs.log")
+	t.Setenv("FAKE_LFS_LOG", lfsLog)
+
+	result, err := runStep(t, repo.workdir)
+
+	require.NoError(t, err)
+	assert.Equal(t, step.OutcomePushed, result.Outcome)
+	assert.Equal(t, []string{"model.bin"}, result.LFSFiles)
+	committed := runGit(t, repo.remoteDir, "cat-file", "blob", "main:model.bin")
+	assert.True(t, strings.HasPrefix(committed, "version https://git-lfs.github.com/spec/v1"), "an LFS pointer should be committed, got %q", committed)
+	assert.Equal(t, "push origin main\npassword=dummy\n", readFile(t, filepath.Dir(lfsLog), "lfs.log"))
+}
+
+func TestLFS_GitLFSMissing(t *testing.T) {
+	if _, err := exec.LookPath("git-lfs"); err == nil {
+		t.Skip("git-lfs is installed")
+	}
+	repo := setupRepoWithFiles(t, map[string]string{".gitattributes": "*.bin filter=lfs diff=lfs merge=lfs -text\n"})
+	writeFile(t, repo.workdir, "model.bin", "weights")
+	setCommonEnvs(t, repo)
+
+	result, err := runStep(t, repo.workdir)
+
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "git-lfs is not installed")
+	assert.Equal(t, []string{"model.bin"}, result.LFSFiles)
+	assert.Equal(t, 2, commitCount(t, repo.remoteDir), "nothing should be pushed")
+}
+
+// setupRepoWithSubmodule adds a submodule at lib to the repo, cloned from its
+// own bare remote, which is returned. .gitmodules tracks the lib's main branch.
+func setupRepoWithSubmodule(t *testing.T) (gitRepo, string) {
+	t.Helper()
+	dir := t.TempDir()
+	libRemote := filepath.Join(dir, "lib.git")
+	libClone := filepath.Join(dir, "lib")
+	runGit(t, dir, "-c", "init.defaultBranch=main", "init", "--bare", "lib.git")
+	runGit(t, dir, "clone", libRemote, "lib")
+	writeFile(t, libClone, "lib.go", "package lib\n")
+	runGit(t, libClone, "add", ".")
+	runGit(t, libClone, "-c", "user.name=Test", "-c", "user.email=test@test.com", "commit", "-m", "Initial lib commit")
+	runGit(t, libClone, "push", "origin", "main")
+
+	repo := setupRepo(t)
+	runGit(t, repo.workdir, "-c", "protocol.file.allow=always", "submodule", "add", libRemote, "lib")
+	runGit(t, repo.workdir, "config", "-f", ".gitmodules", "submodule.lib.branch", "main")
+	runGit(t, repo.workdir, "add", ".")
+	runGit(t, repo.workdir, "commit", "-m", "Add lib submodule")
+	runGit(t, repo.workdir, "push", "origin", "main")
+	return repo, libRemote
+}
+
+func TestSubmodules_Ignore(t *testing.T) {
+	repo, libRemote := setupRepoWithSubmodule(t)
+	gitlink := runGit(t, repo.remoteDir, "rev-parse", "main:lib")
+	libDir := filepath.Join(repo.workdir, "lib")
+	// A local-only commit in the submodule, which must not end up in a gitlink.
+	writeFile(t, libDir, "lib.go", "package lib // formatted\n")
+	runGit(t, libDir, "-c", "user.name=Test", "-c", "user.email=test@test.com", "commit", "-am", "Local commit")
+	writeFile(t, repo.workdir, "README.md", "# Test repo\n")
+	setCommonEnvs(t, repo)
+
+	result, err := runStep(t, repo.workdir)
+
+	require.NoError(t, err)
+	assert.Equal(t, step.OutcomePushed, result.Outcome)
+	require.Len(t, result.Excluded, 1)
+	assert.Equal(t, "lib", result.Excluded[0].Path)
+	assert.Equal(t, gitlink, runGit(t, repo.remoteDir, "rev-parse", "main:lib"), "the gitlink should not change")
+	assert.Equal(t, 1, commitCount(t, libRemote))
+}
+
+func TestSubmodules_Refuse(t *testing.T) {
+	repo, _ := setupRepoWithSubmodule(t)
+	writeFile(t, filepath.Join(repo.workdir, "lib"), "lib.go", "package lib // formatted\n")
+	setCommonEnvs(t, repo)
+	t.Setenv("submodules", "refuse")
+
+	result, err := runStep(t, repo.workdir)
+
+	require.Error(t, err)
+	assert.Equal(t, step.OutcomeSubmoduleBlocked, result.Outcome)
+	assert.Equal(t, 2, commitCount(t, repo.remoteDir), "nothing should be pushed")
+}
+
+func TestSubmodules_Recurse(t *testing.T) {
+	repo, libRemote := setupRepoWithSubmodule(t)
+	writeFile(t, filepath.Join(repo.workdir, "lib"), "lib.go", "package lib // formatted\n")
+	writeFile(t, repo.workdir, "README.md", "# Test repo\n")
+	setCommonEnvs(t, repo)
+	t.Setenv("submodules", "recurse")
+
+	result, err := runStep(t, repo.workdir)
+
+	require.NoError(t, err)
+	assert.Equal(t, step.OutcomePushed, result.Outcome)
+	require.Len(t, result.Submodules, 1)
+	assert.Equal(t, "lib", result.Submodules[0].Path)
+	assert.Equal(t, "main", result.Submodules[0].Branch)
+	assert.True(t, result.Submodules[0].Pushed)
+
+	libTip := runGit(t, libRemote, "rev-parse", "main")
+	assert.Equal(t, result.Submodules[0].CommitSHA, libTip)
+	assert.Equal(t, "package lib // formatted\n", runGit(t, libRemote, "show", "main:lib.go")+"\n")
+	assert.Equal(t, libTip, runGit(t, repo.remoteDir, "rev-parse", "main:lib"), "the superproject should point to the pushed submodule commit")
+	assert.Equal(t, "Test Autofix", latestCommitSubject(t, libRemote))
+}
+
+func TestSubmodules_RecurseChecksSubmoduleChanges(t *testing.T) {
+	tests := []struct {
+		name        string
+		change      func(t *testing.T, libDir string)
+		env         map[string]string
+		wantOutcome step.Outcome
+	}{
+		{
+			name:        "CI config",
+			change:      func(t *testing.T, libDir string) { writeFile(t, libDir, "bitrise.yml", "format_version: 13\n") },
+			wantOutcome: step.OutcomeSecurityBlocked,
+		},
+		{
+			name:        "protected path",
+			change:      func(t *testing.T, libDir string) { writeFile(t, libDir, "deps.lock", "lock\n") },
+			env:         map[string]string{"protected_paths": "lib/deps.lock"},
+			wantOutcome: step.OutcomeSecurityBlocked,
+		},
+		{
+			name:        "denied deletion",
+			change:      func(t *testing.T, libDir string) { require.NoError(t, os.Remove(filepath.Join(libDir, "lib.go"))) },
+			env:         map[string]string{"deletions": "deny"},
+			wantOutcome: step.OutcomeDeletionBlocked,
+		},
+		{
+			name:        "broken file",
+			change:      func(t *testing.T, libDir string) { writeFile(t, libDir, "config.json", `{"broken": `) },
+			wantOutcome: step.OutcomeInvalidFiles,
+		},
+		{
+			name: "limits",
+			change: func(t *testing.T, libDir string) {
+				writeFile(t, libDir, "lib.go", "package lib\n"+strings.Repeat("// comment\n", 10))
+			},
+			env:         map[string]string{"max_changed_lines": "8"},
+			wantOutcome: step.OutcomeLimitExceeded,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			repo, libRemote := setupRepoWithSubmodule(t)
+			tt.change(t, filepath.Join(repo.workdir, "lib"))
+			writeFile(t, repo.workdir, "README.md", "# Test repo\n")
+			setCommonEnvs(t, repo)
+			t.Setenv("submodules", "recurse")
+			for k, v := range tt.env {
+				t.Setenv(k, v)
+			}
+
+			result, err := runStep(t, repo.workdir)
+
+			require.Error(t, err)
+			assert.Equal(t, tt.wantOutcome, result.Outcome)
+			assert.Equal(t, 1, commitCount(t, libRemote), "nothing should be pushed to the submodule")
+			assert.Equal(t, 2, commitCount(t, repo.remoteDir), "nothing should be pushed")
+		})
+	}
+}
+
+func TestSubmodules_RecurseRevertsExcludedSubmoduleChanges(t *testing.T) {
+	repo, libRemote := setupRepoWithSubmodule(t)
+	libDir := filepath.Join(repo.workdir, "lib")
+	writeFile(t, libDir, "lib.go", "package lib // formatted\n")
+	writeFile(t, libDir, "gen.pb.go", "package lib // generated\n")
+	setCommonEnvs(t, repo)
+	t.Setenv("submodules", "recurse")
+	t.Setenv("exclude_paths", "lib/*.pb.go")
+
+	result, err := runStep(t, repo.workdir)
+
+	require.NoError(t, err)
+	assert.Equal(t, step.OutcomePushed, result.Outcome)
+	require.Len(t, result.Submodules, 1)
+	assert.Equal(t, "lib.go", runGit(t, libRemote, "diff-tree", "--no-commit-id", "--name-only", "-r", "main"))
+	var excluded []string
+	for _, f := range result.Excluded {
+		excluded = append(excluded, f.Path)
+	}
+	assert.Equal(t, []string{"lib/gen.pb.go"}, excluded)
+	assert.NoFileExists(t, filepath.Join(libDir, "gen.pb.go"))
+}
+
+// The branch of a submodule comes from the base branch's .gitmodules, the PR
+// can't make the step push to another branch of the submodule's remote.
+func TestSubmodules_RecurseIgnoresBranchSetByThePR(t *testing.T) {
+	repo, libRemote := setupRepoWithSubmodule(t)
+	runGit(t, repo.workdir, "config", "-f", ".gitmodules", "--unset", "submodule.lib.branch")
+	runGit(t, repo.workdir, "commit", "-am", "Track no branch of lib")
+	runGit(t, repo.workdir, "push", "origin", "main")
+	runGit(t, repo.workdir, "checkout", "-b", "feature")
+	runGit(t, repo.workdir, "config", "-f", ".gitmodules", "submodule.lib.branch", "main")
+	runGit(t, repo.workdir, "commit", "-am", "Push lib fixes to main")
+	runGit(t, repo.workdir, "push", "origin", "feature")
+	writeFile(t, filepath.Join(repo.workdir, "lib"), "lib.go", "package lib // formatted\n")
+	writeFile(t, repo.workdir, "README.md", "# Test repo\n")
+	setCommonEnvs(t, repo)
+	t.Setenv("BITRISE_GIT_BRANCH", "feature")
+	t.Setenv("submodules", "recurse")
+
+	result, err := runStep(t, repo.workdir)
+
+	require.NoError(t, err)
+	assert.Equal(t, step.OutcomePushed, result.Outcome)
+	assert.Empty(t, result.Submodules)
+	require.Len(t, result.Excluded, 1)
+	assert.Equal(t, "lib", result.Excluded[0].Path)
+	assert.Equal(t, 1, commitCount(t, libRemote), "nothing should be pushed to the submodule")
+}
+
+func TestPathspec_LimitsToOneProject(t *testing.T) {
+	repo := setupRepo(t)
+	require.NoError(t, os.MkdirAll(filepath.Join(repo.workdir, "apps", "ios"), 0755))
+	require.NoError(t, os.MkdirAll(filepath.Join(repo.workdir, "apps", "android"), 0755))
+	writeFile(t, repo.workdir, "apps/ios/App.swift", "let a = 1\n")
+	writeFile(t, repo.workdir, "apps/android/App.kt", "val a = 1\n")
+	writeFile(t, repo.workdir, "apps/android/Staged.kt", "val b = 1\n")
+	// Staged by another project's step, must not end up in this project's commit.
+	runGit(t, repo.workdir, "add", "apps/android/Staged.kt")
+	setCommonEnvs(t, repo)
+	t.Setenv("pathspec", "apps/ios")
+
+	result, err := runStep(t, repo.workdir)
+
+	require.NoError(t, err)
+	assert.Equal(t, step.OutcomePushed, result.Outcome)
+	require.Len(t, result.Files, 1)
+	assert.Equal(t, "apps/ios/App.swift", result.Files[0].Path)
+	assert.Equal(t, "apps/ios/App.swift", runGit(t, repo.remoteDir, "diff-tree", "--no-commit-id", "--name-only", "-r", "main"))
+}
+
+func TestRepoPath(t *testing.T) {
+	repo := setupRepo(t)
+	writeFile(t, repo.workdir, "README.md", "# Test repo\n")
+	setCommonEnvs(t, repo)
+	t.Setenv("repo_path", "workdir")
+
+	result, err := runStep(t, filepath.Dir(repo.workdir))
+
+	require.NoError(t, err)
+	assert.Equal(t, step.OutcomePushed, result.Outcome)
+	assert.Equal(t, 2, commitCount(t, repo.remoteDir))
+	wd, err := os.Getwd()
+	require.NoError(t, err)
+	assert.Equal(t, filepath.Dir(repo.workdir), wd, "the working directory should not change")
+}
+
+func TestRepoPath_Missing(t *testing.T) {
+	repo := setupRepo(t)
+	setCommonEnvs(t, repo)
+	t.Setenv("repo_path", "missing")
+
+	result, err := runStep(t, filepath.Dir(repo.workdir))
+
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "repo_path:")
+	assert.Equal(t, step.OutcomeError, result.Outcome)
+	assert.FileExists(t, result.ReportPath, "the failure should still be reported")
+}
+
+func TestRepositories(t *testing.T) {
+	app := setupRepo(t)
+	dep := setupRepo(t)
+	writeFile(t, app.workdir, "README.md", "# App\n")
+	writeFile(t, dep.workdir, "README.md", "# Dependency\n")
+	setCommonEnvs(t, app)
+	t.Setenv("DEP_TOKEN", "dep-dummy")
+	t.Setenv("repositories", app.workdir+"\n"+dep.workdir+" branch=main token_env=DEP_TOKEN\n")
+
+	workspace := t.TempDir()
+	result, err := runStep(t, workspace)
+
+	require.NoError(t, err)
+	assert.Equal(t, step.OutcomePushed, result.Outcome)
+	assert.True(t, result.AutofixPushed)
+	assert.Equal(t, 2, result.FileCount)
+	require.Len(t, result.Repositories, 2)
+	for _, r := range result.Repositories {
+		assert.Equal(t, step.OutcomePushed, r.Outcome, r.Path)
+		assert.FileExists(t, r.ReportPath)
+	}
+	assert.Equal(t, 2, commitCount(t, app.remoteDir))
+	assert.Equal(t, 2, commitCount(t, dep.remoteDir))
+	wd, err := os.Getwd()
+	require.NoError(t, err)
+	assert.Equal(t, workspace, wd, "the working directory should not change")
+}
+
+func TestRepositories_HooksSeeTheRepositoryEnv(t *testing.T) {
+	app := setupRepo(t)
+	writeFile(t, app.workdir, "README.md", "# App\n")
+	setCommonEnvs(t, app)
+	deployDir := t.TempDir()
+	t.Setenv("BITRISE_DEPLOY_DIR", deployDir)
+	t.Setenv("repositories", app.workdir+"\n")
+	outDir := t.TempDir()
+	t.Setenv("post_push_command", `printf '%s\n%s' "$BITRISE_DEPLOY_DIR" "$(pwd)" > `+filepath.Join(outDir, "hook.out"))
+
+	result, err := runStep(t, t.TempDir())
+
+	require.NoError(t, err)
+	assert.Equal(t, step.OutcomePushed, result.Outcome)
+	require.Len(t, result.Repositories, 1)
+	repoDeployDir := filepath.Dir(result.Repositories[0].ReportPath)
+	assert.True(t, strings.HasPrefix(repoDeployDir, filepath.Join(deployDir, "repositories")), repoDeployDir)
+	workdir, err := filepath.EvalSymlinks(app.workdir)
+	require.NoError(t, err)
+	assert.Equal(t, repoDeployDir+"\n"+workdir, readFile(t, outDir, "hook.out"))
+}
+
+func TestRepositories_failureDoesNotStopOthers(t *testing.T) {
+	app := setupRepo(t)
+	dep := setupRepo(t)
+	writeFile(t, app.workdir, "README.md", "# App\n")
+	writeFile(t, dep.workdir, "README.md", "# Dependency\n")
+	setCommonEnvs(t, app)
+	t.Setenv("repositories", dep.workdir+" token_env=MISSING_TOKEN\n"+app.workdir+"\n")
+
+	result, err := runStep(t, t.TempDir())
+
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "token_env: $MISSING_TOKEN is empty")
+	assert.Equal(t, step.OutcomeError, result.Outcome)
+	require.Len(t, result.Repositories, 2)
+	assert.Equal(t, step.OutcomePushed, result.Repositories[1].Outcome)
+	assert.Equal(t, 1, commitCount(t, dep.remoteDir))
+	assert.Equal(t, 2, commitCount(t, app.remoteDir))
+}
+
+func TestRepositories_RejectsSnapshotPath(t *testing.T) {
+	app := setupRepo(t)
+	writeFile(t, app.workdir, "README.md", "# App\n")
+	setCommonEnvs(t, app)
+	t.Setenv("repositories", app.workdir+"\n")
+	t.Setenv("snapshot_path", filepath.Join(t.TempDir(), "autofix-snapshot.json"))
+
+	_, err := runStep(t, t.TempDir())
+
+	require.EqualError(t, err, "parse inputs: repositories can't be combined with snapshot_path")
+	assert.Equal(t, 1, commitCount(t, app.remoteDir))
+}
+
+func TestCommitGroups(t *testing.T) {
+	repo := setupRepoWithFiles(t, map[string]string{
+		".autofix.yml": `commit:
+  groups:
+    - name: docs
+      paths: ["*.md"]
+      subject: "docs: autofix"
+    - name: swift
+      paths: ["*.swift"]
+`,
+		"OLD.md":     "# Old\n",
+		"main.swift": "let a=1\n",
+		"notes.txt":  "notes\n",
+	})
+	writeFile(t, repo.workdir, "README.md", "# Test repo\n")
+	require.NoError(t, os.Remove(filepath.Join(repo.workdir, "OLD.md")))
+	writeFile(t, repo.workdir, "main.swift", "let a = 1\n")
+	writeFile(t, repo.workdir, "notes.txt", "Notes\n")
+	setCommonEnvs(t, repo)
+
+	result, err := runStep(t, repo.workdir)
+
+	require.NoError(t, err)
+	assert.Equal(t, step.OutcomePushed, result.Outcome)
+	assert.Equal(t, 2+3, commitCount(t, repo.remoteDir))
+	require.Len(t, result.Commits, 3)
+	assert.Equal(t, result.Commits[2].CommitSHA, result.CommitSHA)
+
+	log := runGit(t, repo.remoteDir, "log", "-3", "--reverse", "--format=%s", "--name-only")
+	assert.Equal(t, "docs: autofix\n\nOLD.md\nREADME.md\nTest Autofix (swift)\n\nmain.swift\nTest Autofix\n\nnotes.txt", log)
+	body := runGit(t, repo.remoteDir, "log", "-1", "--skip=2", "--format=%B")
+	assert.Contains(t, body, "Modified files:\n- README.md")
+	assert.Contains(t, body, "Deleted files:\n- OLD.md")
+}
+
+func TestSpecialCharactersInFileName(t *testing.T) {
+	repo := setupRepoWithFiles(t, map[string]string{
+		"a b.txt":    "one\n",
+		"Résumé.md":  "# CV\n",
+		"skip me.go": "package main\n",
+	})
+	writeFile(t, repo.workdir, "a b.txt", "two\n")
+	writeFile(t, repo.workdir, "Résumé.md", "# Curriculum vitae\n")
+	writeFile(t, repo.workdir, "skip me.go", "package main\n\nfunc main() {}\n")
+	writeFile(t, repo.workdir, "ünïcode new.txt", "new\n")
+	setCommonEnvs(t, repo)
+	t.Setenv("exclude_paths", "skip *.go")
+
+	result, err := runStep(t, repo.workdir)
+
+	require.NoError(t, err)
+	assert.Equal(t, step.OutcomePushed, result.Outcome)
+	assert.Equal(t, "Résumé.md\na b.txt\nünïcode new.txt", runGit(t, repo.remoteDir, "-c", "core.quotePath=false", "show", "--format=", "--name-only", "HEAD"))
+	require.Len(t, result.Excluded, 1)
+	assert.Equal(t, "skip me.go", result.Excluded[0].Path)
+	var diffPaths []string
+	for _, d := range result.Diff {
+		diffPaths = append(diffPaths, d.Path)
+	}
+	assert.ElementsMatch(t, []string{"a b.txt", "Résumé.md", "ünïcode new.txt"}, diffPaths)
+}
+
+func TestExcludePaths_GlobCharactersInFileName(t *testing.T) {
+	repo := setupRepo(t)
+	writeFile(t, repo.workdir, "[id].tsx", "export default 1\n")
+	writeFile(t, repo.workdir, "i.tsx", "export default 2\n")
+	writeFile(t, repo.workdir, "d.tsx", "export default 3\n")
+	setCommonEnvs(t, repo)
+	t.Setenv("exclude_paths", `\[id\].tsx`)
+
+	result, err := runStep(t, repo.workdir)
+
+	require.NoError(t, err)
+	assert.Equal(t, step.OutcomePushed, result.Outcome)
+	assert.Equal(t, "d.tsx\ni.tsx", runGit(t, repo.remoteDir, "show", "--format=", "--name-only", "HEAD"))
+	assert.NoFileExists(t, filepath.Join(repo.workdir, "[id].tsx"))
+}
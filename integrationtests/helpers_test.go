@@ -92,11 +92,43 @@ func setCommonEnvs(t *testing.T, r gitRepo) {
 	t.Helper()
 	t.Setenv("git_token", "dummy")
 	t.Setenv("commit_subject", "Test Autofix")
+	t.Setenv("include_untracked", "true")
+	t.Setenv("step_summary", "true")
+	t.Setenv("diff_max_lines", "500")
+	t.Setenv("diff_max_lines_per_file", "50")
+	t.Setenv("junit_report", "false")
+	t.Setenv("sarif_report", "false")
+	t.Setenv("check_only", "false")
+	t.Setenv("pre_commit", "off")
+	t.Setenv("scope", "all")
+	t.Setenv("mode", "run")
+	t.Setenv("max_files", "0")
+	t.Setenv("max_changed_lines", "0")
+	t.Setenv("max_file_size_kb", "0")
+	t.Setenv("allow_binary_files", "true")
+	t.Setenv("on_limit_exceeded", "fail")
+	t.Setenv("exclude_noise", "false")
+	t.Setenv("ignore_hunk_patterns", "")
+	t.Setenv("ignore_hunk_mode", "file")
+	t.Setenv("validate_syntax", "true")
+	t.Setenv("submodules", "ignore")
+	t.Setenv("repo_path", "")
+	t.Setenv("pathspec", "")
+	t.Setenv("repositories", "")
+	t.Setenv("deletions", "allow")
+	t.Setenv("deletion_allowlist", "")
+	t.Setenv("snapshot_path", "")
+	t.Setenv("fix_until_stable", "false")
+	t.Setenv("fix_max_passes", "5")
+	t.Setenv("on_push", "fail")
 	t.Setenv("dry_run", "false")
 	t.Setenv("verbose", "false")
+	t.Setenv("config_file", ".autofix.yml")
 	t.Setenv("BITRISE_GIT_BRANCH", "main")
+	t.Setenv("BITRISEIO_GIT_BRANCH_DEST", "main")
 	t.Setenv("BITRISE_PULL_REQUEST", "123")
 	t.Setenv("GIT_REPOSITORY_URL", "file://"+r.remoteDir)
+	t.Setenv("BITRISE_DEPLOY_DIR", t.TempDir())
 }
 
 // runStep changes the working directory to workdir for the duration of the test
@@ -135,6 +167,45 @@ func writeFile(t *testing.T, dir, name, content string) {
 	require.NoError(t, os.WriteFile(filepath.Join(dir, name), []byte(content), 0644))
 }
 
+// setupPRMergeRef commits baseFiles to main, then featureFiles to a pushed
+// feature branch, and leaves workdir on a local merge of feature into main, the
+// way Bitrise checks out a PR's merge ref.
+func setupPRMergeRef(t *testing.T, r gitRepo, baseFiles, featureFiles map[string]string) {
+	t.Helper()
+	for name, content := range baseFiles {
+		writeFile(t, r.workdir, name, content)
+	}
+	runGit(t, r.workdir, "add", ".")
+	runGit(t, r.workdir, "commit", "-m", "Base commit")
+	runGit(t, r.workdir, "push", "origin", "main")
+
+	runGit(t, r.workdir, "checkout", "-b", "feature")
+	for name, content := range featureFiles {
+		writeFile(t, r.workdir, name, content)
+	}
+	runGit(t, r.workdir, "add", ".")
+	runGit(t, r.workdir, "commit", "-m", "Feature commit")
+	runGit(t, r.workdir, "push", "origin", "feature")
+
+	runGit(t, r.workdir, "checkout", "main")
+	runGit(t, r.workdir, "merge", "--no-ff", "feature", "-m", "Local merge commit")
+}
+
+// installFakeTool puts an executable shell script named name first on the PATH.
+func installFakeTool(t *testing.T, name, script string) {
+	t.Helper()
+	dir := t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(dir, name), []byte("#!/bin/sh\n"+script), 0755))
+	t.Setenv("PATH", dir+string(os.PathListSeparator)+os.Getenv("PATH"))
+}
+
+func readFile(t *testing.T, dir, name string) string {
+	t.Helper()
+	data, err := os.ReadFile(filepath.Join(dir, name))
+	require.NoError(t, err)
+	return string(data)
+}
+
 func latestCommitSubject(t *testing.T, dir string) string {
 	t.Helper()
 	return runGit(t, dir, "log", "--format=%s", "-1")
@@ -39,9 +39,7 @@ func run() exitcode.ExitCode {
 		return exitcode.Failure
 	}
 
-	if result.AutofixNeeded && !result.DryRun {
-		// A new build will be triggered by the push; fail this one intentionally
-		// so CI gates don't pass on the unfixed commit.
+	if result.FailsBuild() {
 		return exitcode.Failure
 	}
 
@@ -65,5 +63,35 @@ func exportOutputs(exporter export.Exporter, result step.Result) error {
 	if err := exporter.ExportOutput("AUTOFIX_FILE_COUNT", fmt.Sprintf("%d", result.FileCount)); err != nil {
 		return fmt.Errorf("export AUTOFIX_FILE_COUNT: %w", err)
 	}
+	if err := exporter.ExportOutput("AUTOFIX_OUTCOME", string(result.Outcome)); err != nil {
+		return fmt.Errorf("export AUTOFIX_OUTCOME: %w", err)
+	}
+	if err := exporter.ExportOutput("AUTOFIX_COMMIT_SHA", result.CommitSHA); err != nil {
+		return fmt.Errorf("export AUTOFIX_COMMIT_SHA: %w", err)
+	}
+	if err := exporter.ExportOutput("AUTOFIX_REPORT_PATH", result.ReportPath); err != nil {
+		return fmt.Errorf("export AUTOFIX_REPORT_PATH: %w", err)
+	}
+	if err := exporter.ExportOutput("AUTOFIX_SUMMARY_PATH", result.SummaryPath); err != nil {
+		return fmt.Errorf("export AUTOFIX_SUMMARY_PATH: %w", err)
+	}
+	if err := exporter.ExportOutput("AUTOFIX_SARIF_PATH", result.SARIFPath); err != nil {
+		return fmt.Errorf("export AUTOFIX_SARIF_PATH: %w", err)
+	}
+	if err := exporter.ExportOutput("AUTOFIX_ON_PUSH", string(result.OnPush)); err != nil {
+		return fmt.Errorf("export AUTOFIX_ON_PUSH: %w", err)
+	}
+	if err := exporter.ExportOutput("AUTOFIX_SKIP_CI", boolStr(result.SkipCI)); err != nil {
+		return fmt.Errorf("export AUTOFIX_SKIP_CI: %w", err)
+	}
+	if err := exporter.ExportOutput("AUTOFIX_PATCH_PATH", result.PatchPath); err != nil {
+		return fmt.Errorf("export AUTOFIX_PATCH_PATH: %w", err)
+	}
+	if result.SnapshotPath != "" {
+		// Only snapshot mode sets it; the regular run must not clear it for later steps.
+		if err := exporter.ExportOutput("AUTOFIX_SNAPSHOT_PATH", result.SnapshotPath); err != nil {
+			return fmt.Errorf("export AUTOFIX_SNAPSHOT_PATH: %w", err)
+		}
+	}
 	return nil
 }
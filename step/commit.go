@@ -1,10 +1,67 @@
 package step
 
-import "strings"
+import (
+	"fmt"
+	"strings"
+	"text/template"
+)
 
-const stepRepoURL = "https://github.com/bitrise-steplib/bitrise-step-autofix-ci"
+const (
+	stepRepoURL = "https://github.com/bitrise-steplib/bitrise-step-autofix-ci"
+	// skipCIMarker is recognized by Bitrise, GitHub Actions, GitLab CI and most other CI services.
+	skipCIMarker = "[skip ci]"
+	// autofixRoundTrailer numbers consecutive autofix commits on a branch. It is
+	// read back from the branch tip, which works on shallow clones too.
+	autofixRoundTrailer = "Autofix-Round"
+)
 
-func buildCommitMessage(subject string, changedFiles []string) string {
+// commitMessageData is what commit templates can refer to.
+type commitMessageData struct {
+	Subject string
+	// Group is the name of the commit group, empty for the files no group matched.
+	Group string
+	Files []string
+	// Deleted lists the deleted files, they are not part of Files.
+	Deleted []string
+	// ToolSummary has one line per pre-commit hook with its result.
+	ToolSummary []string
+	Branch      string
+	StepURL     string
+}
+
+func parseCommitTemplate(text string) (*template.Template, error) {
+	return template.New("commit").Option("missingkey=error").Parse(text)
+}
+
+// renderCommitMessage renders the commit template, or the built-in message when there is none.
+func renderCommitMessage(templateText string, data commitMessageData) (string, error) {
+	if templateText == "" {
+		msg := buildCommitMessage(data.Subject, data.Files, data.Deleted)
+		if len(data.ToolSummary) > 0 {
+			msg += "\npre-commit hooks:\n- " + strings.Join(data.ToolSummary, "\n- ") + "\n"
+		}
+		return msg, nil
+	}
+	tmpl, err := parseCommitTemplate(templateText)
+	if err != nil {
+		return "", err
+	}
+	var sb strings.Builder
+	if err := tmpl.Execute(&sb, data); err != nil {
+		return "", err
+	}
+	if strings.TrimSpace(sb.String()) == "" {
+		return "", fmt.Errorf("commit template rendered an empty message")
+	}
+	return sb.String(), nil
+}
+
+// withTrailer appends a git trailer, separated from the message by a blank line.
+func withTrailer(message, key, value string) string {
+	return strings.TrimRight(message, "\n") + "\n\n" + key + ": " + value + "\n"
+}
+
+func buildCommitMessage(subject string, changedFiles, deletedFiles []string) string {
 	var sb strings.Builder
 	sb.WriteString(subject)
 	sb.WriteString("\n\nPrevious steps in this CI workflow created uncommitted file changes\n")
@@ -12,11 +69,59 @@ func buildCommitMessage(subject string, changedFiles []string) string {
 	sb.WriteString("captures those changes.\n")
 	sb.WriteString("\n")
 	sb.WriteString(stepRepoURL)
-	sb.WriteString("\n\nModified files:\n")
-	for _, f := range changedFiles {
+	sb.WriteString("\n")
+	if len(changedFiles) > 0 {
+		sb.WriteString("\nModified files:\n")
+		writeBulletList(&sb, changedFiles)
+	}
+	if len(deletedFiles) > 0 {
+		sb.WriteString("\nDeleted files:\n")
+		writeBulletList(&sb, deletedFiles)
+	}
+	return sb.String()
+}
+
+func writeBulletList(sb *strings.Builder, items []string) {
+	for _, item := range items {
 		sb.WriteString("- ")
-		sb.WriteString(f)
+		sb.WriteString(item)
 		sb.WriteString("\n")
 	}
-	return sb.String()
+}
+
+// withSkipCI appends the skip CI marker to the subject, unless it already has one.
+func withSkipCI(subject string) string {
+	lower := strings.ToLower(subject)
+	if strings.Contains(lower, skipCIMarker) || strings.Contains(lower, "[ci skip]") {
+		return subject
+	}
+	return subject + " " + skipCIMarker
+}
+
+// commitFileList formats the changed files for the commit message, with the
+// fix commands that changed them. Deleted files are listed by deletedFileList.
+func commitFileList(files []FileStatus) []string {
+	lines := make([]string, 0, len(files))
+	for _, f := range files {
+		if f.Status == FileDeleted {
+			continue
+		}
+		line := f.String()
+		if len(f.Tools) > 0 {
+			line += " (" + strings.Join(f.Tools, ", ") + ")"
+		}
+		lines = append(lines, line)
+	}
+	return lines
+}
+
+// deletedFileList lists the deleted files for the commit message.
+func deletedFileList(files []FileStatus) []string {
+	var lines []string
+	for _, f := range files {
+		if f.Status == FileDeleted {
+			lines = append(lines, f.String())
+		}
+	}
+	return lines
 }
@@ -5,10 +5,11 @@ import (
 	"testing"
 
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 )
 
 func Test_buildCommitMessage(t *testing.T) {
-	msg := buildCommitMessage("Bitrise CI Autofix", []string{"main.go", "step/step.go"})
+	msg := buildCommitMessage("Bitrise CI Autofix", []string{"main.go", "step/step.go"}, nil)
 
 	assert.True(t, strings.HasPrefix(msg, "Bitrise CI Autofix\n"), "message should start with the subject line")
 	assert.Contains(t, msg, "Previous steps in this CI workflow")
@@ -20,4 +21,70 @@ func Test_buildCommitMessage(t *testing.T) {
 	urlPos := strings.Index(msg, stepRepoURL)
 	filesPos := strings.Index(msg, "- main.go")
 	assert.Greater(t, filesPos, urlPos, "file list should appear after the step URL")
+	assert.NotContains(t, msg, "Deleted files:")
+}
+
+func Test_buildCommitMessage_deletedFiles(t *testing.T) {
+	msg := buildCommitMessage("Bitrise CI Autofix", []string{"main.go"}, []string{"gen/old.pb.go"})
+
+	assert.True(t, strings.HasSuffix(msg, "Modified files:\n- main.go\n\nDeleted files:\n- gen/old.pb.go\n"), msg)
+
+	msg = buildCommitMessage("Bitrise CI Autofix", nil, []string{"gen/old.pb.go"})
+	assert.NotContains(t, msg, "Modified files:")
+	assert.Contains(t, msg, "Deleted files:\n- gen/old.pb.go\n")
+}
+
+func Test_withSkipCI(t *testing.T) {
+	tests := []struct {
+		subject string
+		want    string
+	}{
+		{subject: "Bitrise CI Autofix", want: "Bitrise CI Autofix [skip ci]"},
+		{subject: "Autofix [skip ci]", want: "Autofix [skip ci]"},
+		{subject: "[CI SKIP] Autofix", want: "[CI SKIP] Autofix"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.subject, func(t *testing.T) {
+			assert.Equal(t, tt.want, withSkipCI(tt.subject))
+		})
+	}
+}
+
+func Test_renderCommitMessage(t *testing.T) {
+	data := commitMessageData{Subject: "style: autofix", Files: []string{"a.go", "b.go"}, Branch: "feature"}
+
+	msg, err := renderCommitMessage("", data)
+	require.NoError(t, err)
+	assert.Equal(t, buildCommitMessage("style: autofix", data.Files, nil), msg, "no template means the built-in message")
+
+	msg, err = renderCommitMessage("{{.Subject}} on {{.Branch}}\n\n{{range .Files}}* {{.}}\n{{end}}", data)
+	require.NoError(t, err)
+	assert.Equal(t, "style: autofix on feature\n\n* a.go\n* b.go\n", msg)
+
+	data.ToolSummary = []string{"end-of-file-fixer: modified 1 file(s)", "flake8: passed"}
+	msg, err = renderCommitMessage("", data)
+	require.NoError(t, err)
+	assert.True(t, strings.HasSuffix(msg, "- b.go\n\npre-commit hooks:\n- end-of-file-fixer: modified 1 file(s)\n- flake8: passed\n"), msg)
+
+	_, err = renderCommitMessage("{{if false}}x{{end}}", data)
+	assert.ErrorContains(t, err, "empty message")
+
+	_, err = renderCommitMessage("{{.Unknown}}", data)
+	assert.Error(t, err)
+}
+
+func Test_withTrailer(t *testing.T) {
+	assert.Equal(t, "Subject\n\nBody\n\nAutofix-Round: 2\n", withTrailer("Subject\n\nBody\n", autofixRoundTrailer, "2"))
+}
+
+func Test_commitFileList(t *testing.T) {
+	files := []FileStatus{
+		{Path: "a.go", Status: FileModified, Tools: []string{"gofmt"}},
+		{Path: "b.go", Status: FileModified, Tools: []string{"gofmt", "goimports"}},
+		{Path: "c.go", Status: FileModified},
+		{Path: "d.go", Status: FileDeleted},
+	}
+
+	assert.Equal(t, []string{"a.go (gofmt)", "b.go (gofmt, goimports)", "c.go"}, commitFileList(files))
+	assert.Equal(t, []string{"d.go"}, deletedFileList(files))
 }
@@ -0,0 +1,253 @@
+package step
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/bitrise-io/go-utils/v2/command"
+	"gopkg.in/yaml.v3"
+)
+
+const defaultCommitSubject = "Bitrise CI Autofix"
+
+// Config is the repo level policy committed as .autofix.yml. It is read from
+// the PR's base branch, so a PR can't loosen the policy it is checked against.
+type Config struct {
+	Commit struct {
+		Subject  string        `yaml:"subject"`
+		Template string        `yaml:"template"`
+		Groups   []CommitGroup `yaml:"groups"`
+	} `yaml:"commit"`
+	Paths struct {
+		Include []string `yaml:"include"`
+		Exclude []string `yaml:"exclude"`
+	} `yaml:"paths"`
+	ProtectedPaths    []string `yaml:"protected_paths"`
+	MaxAutofixCommits *int     `yaml:"max_autofix_commits"`
+}
+
+// Policy is the effective configuration of a run. Every setting is resolved
+// in the same order: step input, then .autofix.yml, then the built-in default.
+type Policy struct {
+	CommitSubject  string
+	CommitTemplate string
+	// CommitGroups split the autofix into one commit per group, they only come from the config file.
+	CommitGroups   []CommitGroup
+	IncludePaths   []string
+	ExcludePaths   []string
+	ProtectedPaths []string
+	// MaxAutofixCommits is the number of consecutive autofix commits allowed
+	// on the branch, 0 means no limit.
+	MaxAutofixCommits int
+}
+
+func parseConfig(data []byte) (Config, error) {
+	var cfg Config
+	dec := yaml.NewDecoder(bytes.NewReader(data))
+	// Typos shouldn't silently disable a protection.
+	dec.KnownFields(true)
+	// An empty file is a valid config without any settings.
+	if err := dec.Decode(&cfg); err != nil && !errors.Is(err, io.EOF) {
+		return Config{}, err
+	}
+	if cfg.MaxAutofixCommits != nil && *cfg.MaxAutofixCommits < 0 {
+		return Config{}, fmt.Errorf("max_autofix_commits must not be negative")
+	}
+	if err := validateCommitGroups(cfg.Commit.Groups); err != nil {
+		return Config{}, err
+	}
+	return cfg, nil
+}
+
+func resolvePolicy(input Input, cfg Config) Policy {
+	p := Policy{
+		CommitSubject:  firstNonEmpty(input.CommitSubject, cfg.Commit.Subject, defaultCommitSubject),
+		CommitTemplate: firstNonEmpty(input.CommitTemplate, cfg.Commit.Template),
+		CommitGroups:   cfg.Commit.Groups,
+		IncludePaths:   firstNonEmptyList(input.IncludePaths, cfg.Paths.Include),
+		ExcludePaths:   firstNonEmptyList(input.ExcludePaths, cfg.Paths.Exclude),
+		ProtectedPaths: firstNonEmptyList(input.ProtectedPaths, cfg.ProtectedPaths),
+	}
+	// The input is a pointer, so an unset input falls back to the file even though 0 is a valid value.
+	switch {
+	case input.MaxAutofixCommits != nil:
+		p.MaxAutofixCommits = *input.MaxAutofixCommits
+	case cfg.MaxAutofixCommits != nil:
+		p.MaxAutofixCommits = *cfg.MaxAutofixCommits
+	}
+	return p
+}
+
+func firstNonEmpty(values ...string) string {
+	for _, v := range values {
+		if strings.TrimSpace(v) != "" {
+			return v
+		}
+	}
+	return ""
+}
+
+// firstNonEmptyList returns the first list with at least one non-blank item,
+// without the blank items. Multiline inputs leave those behind for empty lines.
+func firstNonEmptyList(lists ...[]string) []string {
+	for _, list := range lists {
+		var items []string
+		for _, item := range list {
+			if item = strings.TrimSpace(item); item != "" {
+				items = append(items, item)
+			}
+		}
+		if len(items) > 0 {
+			return items
+		}
+	}
+	return nil
+}
+
+// readTrustedFile reads a file from the trusted base of the build: the PR's
+// target branch, or the build's own commit when it isn't a PR. The working tree
+// and the PR head are never used, they are under the control of the PR author.
+// It returns nil data if the file doesn't exist, and where it looked for it.
+//
+// Check-only mode doesn't fetch, it promises to need neither credentials nor
+// the remote: it reads the base branch the clone already has, and without one
+// it reads nothing.
+func (s Step) readTrustedFile(path, username, token string, checkOnly bool) ([]byte, string, error) {
+	ref, source := "HEAD", "the build commit"
+	if s.isPRBuild() {
+		baseBranch := s.envRepo.Get("BITRISEIO_GIT_BRANCH_DEST")
+		if baseBranch == "" {
+			return nil, "", fmt.Errorf("cannot determine the PR's base branch: BITRISEIO_GIT_BRANCH_DEST is empty")
+		}
+		source = "the base branch " + baseBranch
+		if checkOnly {
+			localRef, ok := s.localBranchRef(baseBranch)
+			if !ok {
+				s.logger.Warnf("The base branch %s is not in the clone and check-only mode doesn't fetch, so %s is not read from it", baseBranch, path)
+				return nil, source, nil
+			}
+			ref = localRef
+		} else {
+			if err := s.gitFetchRef(username, token, "refs/heads/"+baseBranch); err != nil {
+				return nil, "", fmt.Errorf("fetch base branch %s: %w", baseBranch, err)
+			}
+			ref = "FETCH_HEAD"
+		}
+	}
+
+	object := fmt.Sprintf("%s:%s", ref, path)
+	if exitCode, err := s.commandFactory.Create("git", []string{"cat-file", "-e", object}, nil).RunAndReturnExitCode(); err != nil {
+		if exitCode > 0 {
+			return nil, source, nil
+		}
+		return nil, "", fmt.Errorf("look up %s: %w", object, err)
+	}
+
+	var outBuf bytes.Buffer
+	if err := s.commandFactory.Create("git", []string{"show", object}, &command.Opts{Stdout: &outBuf}).Run(); err != nil {
+		return nil, "", fmt.Errorf("read %s: %w", object, err)
+	}
+	// Non-nil even for an empty file, which is different from a missing one.
+	return append([]byte{}, outBuf.Bytes()...), source, nil
+}
+
+// localBranchRef returns the ref of the branch in the clone, preferring the
+// remote-tracking branch, which is what CI fetched from origin.
+func (s Step) localBranchRef(branch string) (string, bool) {
+	for _, ref := range []string{"refs/remotes/origin/" + branch, "refs/heads/" + branch} {
+		cmd := s.commandFactory.Create("git", []string{"rev-parse", "--verify", "--quiet", ref + "^{commit}"}, nil)
+		if exitCode, err := cmd.RunAndReturnExitCode(); err == nil && exitCode == 0 {
+			return ref, true
+		}
+	}
+	return "", false
+}
+
+// loadConfig reads the config file with readTrustedFile. A missing file is not
+// an error, it means every setting comes from inputs and defaults.
+func (s Step) loadConfig(path, username, token string, checkOnly bool) (Config, error) {
+	data, source, err := s.readTrustedFile(path, username, token, checkOnly)
+	if err != nil {
+		return Config{}, err
+	}
+	if data == nil {
+		s.logger.Infof("No %s found on %s, using step inputs and defaults", path, source)
+		return Config{}, nil
+	}
+	cfg, err := parseConfig(data)
+	if err != nil {
+		return Config{}, fmt.Errorf("parse %s: %w", path, err)
+	}
+	s.logger.Infof("Using %s from %s", path, source)
+	return cfg, nil
+}
+
+// loadPolicy resolves the effective policy from the inputs and the config file.
+// Everything that can be wrong with it is checked here, before the repo is touched.
+func (s Step) loadPolicy(input Input) (Policy, error) {
+	var cfg Config
+	if input.ConfigFile != "" {
+		var err error
+		if cfg, err = s.loadConfig(input.ConfigFile, input.GitUsername, input.GitToken, input.CheckOnly); err != nil {
+			return Policy{}, err
+		}
+	}
+
+	policy := resolvePolicy(input, cfg)
+	if policy.CommitTemplate != "" {
+		if _, err := parseCommitTemplate(policy.CommitTemplate); err != nil {
+			return Policy{}, fmt.Errorf("invalid commit template: %w", err)
+		}
+	}
+	if input.ConfigFile != "" {
+		// The config is trusted because it comes from the base branch; the autofix
+		// commit must not be a way to change it.
+		policy.ProtectedPaths = append(policy.ProtectedPaths, input.ConfigFile)
+	}
+
+	s.logger.Debugf("Effective policy: %+v", policy)
+	return policy, nil
+}
+
+// filterChanges splits the changes into the ones the policy allows to commit
+// and the ones excluded by the path filters. Renames match on either path.
+func filterChanges(changes []FileStatus, policy Policy) ([]FileStatus, []ExcludedFile) {
+	var kept []FileStatus
+	var excluded []ExcludedFile
+	for _, f := range changes {
+		paths := []string{f.Path}
+		if f.OldPath != "" {
+			paths = append(paths, f.OldPath)
+		}
+
+		reason := ""
+		if len(policy.IncludePaths) > 0 && !anyPathMatches(policy.IncludePaths, paths) {
+			reason = "not matched by the include paths"
+		}
+		for _, p := range paths {
+			if pattern, ok := matchAnyGlob(policy.ExcludePaths, p); ok {
+				reason = fmt.Sprintf("matched exclude path %q", pattern)
+				break
+			}
+		}
+
+		if reason == "" {
+			kept = append(kept, f)
+		} else {
+			excluded = append(excluded, ExcludedFile{FileStatus: f, Reason: reason})
+		}
+	}
+	return kept, excluded
+}
+
+func anyPathMatches(patterns, paths []string) bool {
+	for _, p := range paths {
+		if _, ok := matchAnyGlob(patterns, p); ok {
+			return true
+		}
+	}
+	return false
+}
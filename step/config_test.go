@@ -0,0 +1,211 @@
+package step
+
+import (
+	"testing"
+
+	"github.com/bitrise-io/go-utils/v2/log"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_parseConfig(t *testing.T) {
+	cfg, err := parseConfig([]byte(`
+commit:
+  subject: "style: autofix"
+paths:
+  include: ["src/"]
+  exclude: ["*.log"]
+protected_paths: ["*.lock"]
+max_autofix_commits: 2
+`))
+	require.NoError(t, err)
+	assert.Equal(t, "style: autofix", cfg.Commit.Subject)
+	assert.Equal(t, []string{"src/"}, cfg.Paths.Include)
+	assert.Equal(t, []string{"*.log"}, cfg.Paths.Exclude)
+	assert.Equal(t, []string{"*.lock"}, cfg.ProtectedPaths)
+	require.NotNil(t, cfg.MaxAutofixCommits)
+	assert.Equal(t, 2, *cfg.MaxAutofixCommits)
+}
+
+func Test_parseConfig_Errors(t *testing.T) {
+	tests := []struct {
+		name string
+		data string
+	}{
+		{name: "unknown key", data: "protected_path: ['*.lock']"},
+		{name: "negative loop limit", data: "max_autofix_commits: -1"},
+		{name: "invalid YAML", data: "paths: [}"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := parseConfig([]byte(tt.data))
+			assert.Error(t, err)
+		})
+	}
+}
+
+func Test_parseConfig_Empty(t *testing.T) {
+	cfg, err := parseConfig(nil)
+	require.NoError(t, err)
+	assert.Equal(t, Config{}, cfg)
+}
+
+func Test_resolvePolicy(t *testing.T) {
+	zero, three := 0, 3
+	cfg := Config{ProtectedPaths: []string{"*.lock"}, MaxAutofixCommits: &three}
+	cfg.Commit.Subject = "style: autofix"
+	cfg.Paths.Exclude = []string{"*.log"}
+
+	t.Run("file overrides defaults", func(t *testing.T) {
+		p := resolvePolicy(Input{}, cfg)
+		assert.Equal(t, "style: autofix", p.CommitSubject)
+		assert.Equal(t, []string{"*.log"}, p.ExcludePaths)
+		assert.Equal(t, []string{"*.lock"}, p.ProtectedPaths)
+		assert.Equal(t, 3, p.MaxAutofixCommits)
+	})
+
+	t.Run("inputs override the file", func(t *testing.T) {
+		p := resolvePolicy(Input{
+			CommitSubject:     "Autofix",
+			ExcludePaths:      []string{"", "*.tmp", ""},
+			MaxAutofixCommits: &zero,
+		}, cfg)
+		assert.Equal(t, "Autofix", p.CommitSubject)
+		assert.Equal(t, []string{"*.tmp"}, p.ExcludePaths)
+		assert.Equal(t, []string{"*.lock"}, p.ProtectedPaths)
+		assert.Equal(t, 0, p.MaxAutofixCommits)
+	})
+
+	t.Run("blank inputs don't override the file", func(t *testing.T) {
+		p := resolvePolicy(Input{CommitSubject: " ", ExcludePaths: []string{""}}, cfg)
+		assert.Equal(t, "style: autofix", p.CommitSubject)
+		assert.Equal(t, []string{"*.log"}, p.ExcludePaths)
+	})
+
+	t.Run("built-in defaults", func(t *testing.T) {
+		p := resolvePolicy(Input{}, Config{})
+		assert.Equal(t, defaultCommitSubject, p.CommitSubject)
+		assert.Empty(t, p.ExcludePaths)
+		assert.Equal(t, 0, p.MaxAutofixCommits)
+	})
+}
+
+func Test_filterChanges(t *testing.T) {
+	changes := []FileStatus{
+		{Path: "src/main.go", Status: FileModified},
+		{Path: "src/debug.log", Status: FileUntracked},
+		{Path: "README.md", Status: FileModified},
+		{Path: "src/new.go", OldPath: "old.go", Status: FileRenamed},
+	}
+	policy := Policy{IncludePaths: []string{"src/"}, ExcludePaths: []string{"*.log"}}
+
+	kept, excluded := filterChanges(changes, policy)
+
+	assert.Equal(t, []FileStatus{changes[0], changes[3]}, kept)
+	assert.Equal(t, []ExcludedFile{
+		{FileStatus: changes[1], Reason: `matched exclude path "*.log"`},
+		{FileStatus: changes[2], Reason: "not matched by the include paths"},
+	}, excluded)
+}
+
+func Test_loadConfig_NotPR(t *testing.T) {
+	factory := &fakeCommandFactory{responses: map[string]string{"show": "commit:\n  subject: x\n"}}
+	s := Step{commandFactory: factory, logger: log.NewLogger(), envRepo: fakeEnvRepo{}}
+
+	_, err := s.loadConfig(".autofix.yml", "", "", false)
+	require.NoError(t, err)
+
+	_, fetched := factory.findCall("fetch")
+	assert.False(t, fetched, "non-PR builds read the config from their own commit")
+	showCall, ok := factory.findCall("show")
+	require.True(t, ok)
+	assert.Equal(t, []string{"show", "HEAD:.autofix.yml"}, showCall.args)
+}
+
+func Test_loadConfig_PRReadsBaseBranch(t *testing.T) {
+	factory := &fakeCommandFactory{}
+	s := Step{commandFactory: factory, logger: log.NewLogger(), envRepo: fakeEnvRepo{
+		"BITRISE_PULL_REQUEST":      "42",
+		"BITRISEIO_GIT_BRANCH_DEST": "main",
+	}}
+
+	_, err := s.loadConfig(".autofix.yml", "user", "token", false)
+	require.NoError(t, err)
+
+	fetchCall, ok := factory.findCall("fetch")
+	require.True(t, ok)
+	assert.Equal(t, "refs/heads/main", fetchCall.args[len(fetchCall.args)-1])
+	assert.NotEmpty(t, credentialHelperArg(fetchCall.args))
+	showCall, ok := factory.findCall("show")
+	require.True(t, ok)
+	assert.Equal(t, []string{"show", "FETCH_HEAD:.autofix.yml"}, showCall.args)
+}
+
+func Test_loadConfig_CheckOnlyReadsTheLocalBaseBranch(t *testing.T) {
+	factory := &fakeCommandFactory{}
+	s := Step{commandFactory: factory, logger: log.NewLogger(), envRepo: fakeEnvRepo{
+		"BITRISE_PULL_REQUEST":      "42",
+		"BITRISEIO_GIT_BRANCH_DEST": "main",
+	}}
+
+	_, err := s.loadConfig(".autofix.yml", "", "", true)
+	require.NoError(t, err)
+
+	_, fetched := factory.findCall("fetch")
+	assert.False(t, fetched, "check-only mode doesn't fetch")
+	showCall, ok := factory.findCall("show")
+	require.True(t, ok)
+	assert.Equal(t, []string{"show", "refs/remotes/origin/main:.autofix.yml"}, showCall.args)
+}
+
+func Test_loadConfig_PRWithoutBaseBranch(t *testing.T) {
+	s := Step{commandFactory: &fakeCommandFactory{}, logger: log.NewLogger(), envRepo: fakeEnvRepo{"BITRISE_PULL_REQUEST": "42"}}
+
+	_, err := s.loadConfig(".autofix.yml", "", "", false)
+	assert.ErrorContains(t, err, "BITRISEIO_GIT_BRANCH_DEST")
+}
+
+func Test_parseConfig_commitGroups(t *testing.T) {
+	cfg, err := parseConfig([]byte(`
+commit:
+  groups:
+    - name: SwiftFormat
+      paths: ["**/*.swift"]
+      subject: "style: swiftformat"
+    - name: codegen
+      paths: ["Generated/**"]
+`))
+	require.NoError(t, err)
+	assert.Equal(t, []CommitGroup{
+		{Name: "SwiftFormat", Paths: []string{"**/*.swift"}, Subject: "style: swiftformat"},
+		{Name: "codegen", Paths: []string{"Generated/**"}},
+	}, cfg.Commit.Groups)
+
+	tests := []struct {
+		name    string
+		config  string
+		wantErr string
+	}{
+		{
+			name:    "missing name",
+			config:  "commit:\n  groups:\n    - paths: [\"*.swift\"]\n",
+			wantErr: "commit.groups[0]: name is required",
+		},
+		{
+			name:    "duplicate name",
+			config:  "commit:\n  groups:\n    - {name: a, paths: [x]}\n    - {name: a, paths: [y]}\n",
+			wantErr: "commit.groups: a is defined more than once",
+		},
+		{
+			name:    "nothing to match",
+			config:  "commit:\n  groups:\n    - name: a\n",
+			wantErr: "commit.groups: a: set paths, tools or both",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := parseConfig([]byte(tt.config))
+			assert.EqualError(t, err, tt.wantErr)
+		})
+	}
+}
@@ -0,0 +1,69 @@
+package step
+
+import (
+	"fmt"
+	"strings"
+)
+
+// DeletionPolicy controls whether the autofix commit may delete files.
+type DeletionPolicy string
+
+const (
+	DeletionsAllow      DeletionPolicy = "allow"
+	DeletionsDeny       DeletionPolicy = "deny"
+	DeletionsReportOnly DeletionPolicy = "report_only"
+)
+
+const deletionExcludedReason = "deletion not allowed (deletions: report_only)"
+
+// errDeletionBlocked is returned when deletions are denied and the changes delete files.
+var errDeletionBlocked = fmt.Errorf("the changes delete files, which is not allowed (deletions: %s)", DeletionsDeny)
+
+// applyDeletionPolicy splits off the deleted files that the policy doesn't
+// allow to commit. Deletions matching the allowlist are always allowed. A
+// rename deletes its source, so it is only allowed if the source may be deleted.
+func applyDeletionPolicy(changes []FileStatus, policy DeletionPolicy, allowlist []string) (kept []FileStatus, notAllowed []FileStatus) {
+	if policy == DeletionsAllow {
+		return changes, nil
+	}
+	for _, f := range changes {
+		deleted := f.Path
+		switch {
+		case f.Status == FileRenamed && f.OldPath != "":
+			deleted = f.OldPath
+		case f.Status != FileDeleted:
+			kept = append(kept, f)
+			continue
+		}
+		if _, ok := matchAnyGlob(allowlist, deleted); ok {
+			kept = append(kept, f)
+			continue
+		}
+		notAllowed = append(notAllowed, f)
+	}
+	return kept, notAllowed
+}
+
+// checkDeletions enforces the deletion policy: deny fails on any deletion that
+// is not allowlisted, report_only leaves them out of the commit.
+func (s Step) checkDeletions(changes []FileStatus, policy DeletionPolicy, allowlist []string) ([]FileStatus, []ExcludedFile, error) {
+	kept, notAllowed := applyDeletionPolicy(changes, policy, allowlist)
+	if len(notAllowed) == 0 {
+		return kept, nil, nil
+	}
+	paths := filePaths(notAllowed)
+	if policy == DeletionsDeny {
+		return nil, nil, fmt.Errorf("%w:\n  %s", errDeletionBlocked, strings.Join(paths, "\n  "))
+	}
+
+	s.logger.Println()
+	s.logger.Warnf("Not committing %d deleted file(s) (deletions: %s):", len(notAllowed), policy)
+	for _, p := range paths {
+		s.logger.Warnf("  %s", p)
+	}
+	excluded := make([]ExcludedFile, 0, len(notAllowed))
+	for _, f := range notAllowed {
+		excluded = append(excluded, ExcludedFile{FileStatus: f, Reason: deletionExcludedReason})
+	}
+	return kept, excluded, nil
+}
@@ -0,0 +1,68 @@
+package step
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_applyDeletionPolicy(t *testing.T) {
+	changes := []FileStatus{
+		{Path: "main.go", Status: FileModified},
+		{Path: "src/app.go", Status: FileDeleted},
+		{Path: "gen/old.pb.go", Status: FileDeleted},
+		{Path: "new.go", Status: FileUntracked},
+		{Path: "gen/moved.go", OldPath: "src/moved.go", Status: FileRenamed},
+	}
+
+	tests := []struct {
+		name           string
+		policy         DeletionPolicy
+		allowlist      []string
+		wantKept       []string
+		wantNotAllowed []string
+	}{
+		{
+			name:     "allow",
+			policy:   DeletionsAllow,
+			wantKept: []string{"main.go", "src/app.go", "gen/old.pb.go", "new.go", "src/moved.go -> gen/moved.go"},
+		},
+		{
+			name:           "deny",
+			policy:         DeletionsDeny,
+			wantKept:       []string{"main.go", "new.go"},
+			wantNotAllowed: []string{"src/app.go", "gen/old.pb.go", "src/moved.go -> gen/moved.go"},
+		},
+		{
+			name:           "report only with an allowlist",
+			policy:         DeletionsReportOnly,
+			allowlist:      []string{"gen/**"},
+			wantKept:       []string{"main.go", "gen/old.pb.go", "new.go"},
+			wantNotAllowed: []string{"src/app.go", "src/moved.go -> gen/moved.go"},
+		},
+		{
+			name:           "rename with an allowlisted source",
+			policy:         DeletionsDeny,
+			allowlist:      []string{"src/moved.go"},
+			wantKept:       []string{"main.go", "new.go", "src/moved.go -> gen/moved.go"},
+			wantNotAllowed: []string{"src/app.go", "gen/old.pb.go"},
+		},
+		{
+			name:      "everything allowlisted",
+			policy:    DeletionsDeny,
+			allowlist: []string{"**/*.go"},
+			wantKept:  []string{"main.go", "src/app.go", "gen/old.pb.go", "new.go", "src/moved.go -> gen/moved.go"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			kept, notAllowed := applyDeletionPolicy(changes, tt.policy, tt.allowlist)
+			assert.Equal(t, tt.wantKept, filePaths(kept))
+			if tt.wantNotAllowed == nil {
+				assert.Empty(t, notAllowed)
+			} else {
+				assert.Equal(t, tt.wantNotAllowed, filePaths(notAllowed))
+			}
+		})
+	}
+}
@@ -0,0 +1,324 @@
+package step
+
+import (
+	"bytes"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/bitrise-io/go-utils/v2/command"
+)
+
+// FileDiff is the parsed unified diff of a single file.
+type FileDiff struct {
+	Path string
+	// OldPath is set when the file was renamed.
+	OldPath string
+	New     bool
+	Deleted bool
+	Binary  bool
+	Added   int
+	Removed int
+	// Headers are git's extended header lines (file modes, renames),
+	// kept verbatim so Patch can reproduce them.
+	Headers []string
+	Hunks   []Hunk
+}
+
+// Hunk is a single "@@ ... @@" section of a unified diff.
+type Hunk struct {
+	OldStart int
+	OldLines int
+	NewStart int
+	NewLines int
+	// Header is the full "@@ -a,b +c,d @@ context" line.
+	Header string
+	// Lines keep their leading ' ', '+', '-' or '\' marker.
+	Lines []string
+}
+
+// Patch returns the diff of the file in unified format, suitable for git apply.
+func (d FileDiff) Patch() string {
+	var sb strings.Builder
+	oldPath := d.Path
+	if d.OldPath != "" {
+		oldPath = d.OldPath
+	}
+	fmt.Fprintf(&sb, "diff --git a/%s b/%s\n", oldPath, d.Path)
+	for _, h := range d.Headers {
+		sb.WriteString(h)
+		sb.WriteString("\n")
+	}
+	from, to := "a/"+oldPath, "b/"+d.Path
+	if d.New {
+		from = "/dev/null"
+	}
+	if d.Deleted {
+		to = "/dev/null"
+	}
+	if d.Binary {
+		fmt.Fprintf(&sb, "Binary files %s and %s differ\n", from, to)
+		return sb.String()
+	}
+	if len(d.Hunks) == 0 {
+		// Mode-only changes have no content section.
+		return sb.String()
+	}
+	fmt.Fprintf(&sb, "--- %s\n+++ %s\n", from, to)
+	for _, h := range d.Hunks {
+		sb.WriteString(h.Header)
+		sb.WriteString("\n")
+		for _, l := range h.Lines {
+			sb.WriteString(l)
+			sb.WriteString("\n")
+		}
+	}
+	return sb.String()
+}
+
+// getAutofixDiff returns the diff of the given changes against HEAD, so changes
+// left out of the commit don't show up in it. Besides the parsed diff it returns
+// the raw patch, which includes binary files and can be applied with git apply.
+// It doesn't touch the index, so it is safe to call before anything is staged or committed.
+func (s Step) getAutofixDiff(changes []FileStatus) ([]FileDiff, string, error) {
+	var tracked, untracked []string
+	for _, c := range changes {
+		if c.Status == FileUntracked {
+			untracked = append(untracked, c.Path)
+			continue
+		}
+		tracked = append(tracked, c.Path)
+		if c.OldPath != "" {
+			tracked = append(tracked, c.OldPath)
+		}
+	}
+
+	var outBuf bytes.Buffer
+	var patch string
+	var diffs []FileDiff
+	if len(tracked) > 0 {
+		args := []string{"-c", "core.quotePath=false", "--literal-pathspecs", "diff", "HEAD", "--binary", "--no-color", "--no-ext-diff", "--no-textconv", "--find-renames", "--"}
+		if err := s.commandFactory.Create("git", append(args, tracked...), &command.Opts{Stdout: &outBuf}).Run(); err != nil {
+			return nil, "", fmt.Errorf("run git diff: %w", err)
+		}
+		patch = outBuf.String()
+		diffs = parseUnifiedDiff(patch)
+	}
+
+	// git status lists untracked files one by one (--untracked-files=all), and
+	// they can only be diffed one by one.
+	for _, path := range untracked {
+		outBuf.Reset()
+		args := []string{"-c", "core.quotePath=false", "diff", "--no-index", "--binary", "--no-color", "--no-ext-diff", "--no-textconv", "--", "/dev/null", path}
+		// --no-index exits with 1 when the files differ, which they always do here.
+		exitCode, err := s.commandFactory.Create("git", args, &command.Opts{Stdout: &outBuf}).RunAndReturnExitCode()
+		if err != nil && exitCode != 1 {
+			return nil, "", fmt.Errorf("diff untracked file %s: %w", path, err)
+		}
+		patch += outBuf.String()
+		diffs = append(diffs, parseUnifiedDiff(outBuf.String())...)
+	}
+	return diffs, patch, nil
+}
+
+var hunkHeaderRegexp = regexp.MustCompile(`^@@ -(\d+)(?:,(\d+))? \+(\d+)(?:,(\d+))? @@`)
+
+// parseUnifiedDiff parses `git diff` output into per-file diffs.
+// It only understands what git itself emits: extended headers are used to
+// detect renames and binary files, everything else is skipped.
+func parseUnifiedDiff(diff string) []FileDiff {
+	var files []FileDiff
+	var cur *FileDiff
+	var hunk *Hunk
+
+	flushHunk := func() {
+		if cur != nil && hunk != nil {
+			cur.Hunks = append(cur.Hunks, *hunk)
+		}
+		hunk = nil
+	}
+	flushFile := func() {
+		flushHunk()
+		if cur != nil {
+			files = append(files, *cur)
+		}
+		cur = nil
+	}
+
+	for _, line := range strings.Split(diff, "\n") {
+		switch {
+		case strings.HasPrefix(line, "diff --git "):
+			flushFile()
+			cur = &FileDiff{Path: pathFromDiffGitLine(line)}
+		case cur == nil:
+			continue
+		case hunk == nil && strings.HasPrefix(line, "rename from "):
+			cur.OldPath = strings.TrimPrefix(line, "rename from ")
+			cur.Headers = append(cur.Headers, line)
+		case hunk == nil && strings.HasPrefix(line, "rename to "):
+			cur.Path = strings.TrimPrefix(line, "rename to ")
+			cur.Headers = append(cur.Headers, line)
+		case hunk == nil && strings.HasPrefix(line, "new file mode "):
+			cur.New = true
+			cur.Headers = append(cur.Headers, line)
+		case hunk == nil && strings.HasPrefix(line, "deleted file mode "):
+			cur.Deleted = true
+			cur.Headers = append(cur.Headers, line)
+		case hunk == nil && (strings.HasPrefix(line, "old mode ") || strings.HasPrefix(line, "new mode ") || strings.HasPrefix(line, "similarity index ")):
+			cur.Headers = append(cur.Headers, line)
+		case hunk == nil && (strings.HasPrefix(line, "Binary files ") || line == "GIT binary patch"):
+			// The base85 encoded data following a binary patch is skipped
+			// like any other unknown line.
+			cur.Binary = true
+		case hunk == nil && strings.HasPrefix(line, "--- "):
+			continue
+		case hunk == nil && strings.HasPrefix(line, "+++ "):
+			// git ends the header with a tab when the path contains a space.
+			if p := strings.TrimSuffix(strings.TrimPrefix(line, "+++ "), "\t"); p != "/dev/null" {
+				cur.Path = strings.TrimPrefix(p, "b/")
+			}
+		case strings.HasPrefix(line, "@@ "):
+			flushHunk()
+			hunk = parseHunkHeader(line)
+		case hunk != nil:
+			if line == "" {
+				// Only the trailing newline of the whole output produces an empty line;
+				// context lines always have at least the leading space.
+				continue
+			}
+			hunk.Lines = append(hunk.Lines, line)
+			switch line[0] {
+			case '+':
+				cur.Added++
+			case '-':
+				cur.Removed++
+			}
+		}
+	}
+	flushFile()
+	return files
+}
+
+func parseHunkHeader(line string) *Hunk {
+	h := &Hunk{Header: line, OldLines: 1, NewLines: 1}
+	m := hunkHeaderRegexp.FindStringSubmatch(line)
+	if m == nil {
+		return h
+	}
+	h.OldStart, _ = strconv.Atoi(m[1])
+	if m[2] != "" {
+		h.OldLines, _ = strconv.Atoi(m[2])
+	}
+	h.NewStart, _ = strconv.Atoi(m[3])
+	if m[4] != "" {
+		h.NewLines, _ = strconv.Atoi(m[4])
+	}
+	return h
+}
+
+// pathFromDiffGitLine extracts the new path from "diff --git a/X b/Y".
+// It is only a fallback for diffs without ---/+++ lines (binary files, mode changes).
+func pathFromDiffGitLine(line string) string {
+	rest := strings.TrimPrefix(line, "diff --git ")
+	if i := strings.LastIndex(rest, " b/"); i != -1 {
+		return rest[i+len(" b/"):]
+	}
+	return rest
+}
+
+// annotateDiffStats copies the line counts of the diff onto the matching file statuses.
+func annotateDiffStats(files []FileStatus, diffs []FileDiff) {
+	byPath := make(map[string]FileDiff, len(diffs))
+	for _, d := range diffs {
+		byPath[d.Path] = d
+	}
+	for i, f := range files {
+		d, ok := byPath[f.Path]
+		if !ok {
+			continue
+		}
+		files[i].Added = d.Added
+		files[i].Removed = d.Removed
+		files[i].Binary = d.Binary
+	}
+}
+
+// changeRegion is a run of consecutive removed and/or added lines within a hunk,
+// i.e. one contiguous edit without the surrounding context lines.
+type changeRegion struct {
+	// OldStart is the first removed line, or for pure insertions the line
+	// after which the new lines are inserted (0 for the start of the file).
+	OldStart int
+	NewStart int
+	Removed  []string
+	Added    []string
+	// OldNoEOL and NewNoEOL are set when the last removed/added line is the
+	// last line of the file and has no trailing newline.
+	OldNoEOL bool
+	NewNoEOL bool
+}
+
+// changeRegions splits a hunk into its contiguous edits, dropping context lines.
+func changeRegions(h Hunk) []changeRegion {
+	var regions []changeRegion
+	var cur *changeRegion
+	oldLine, newLine := h.OldStart, h.NewStart
+	if h.OldLines == 0 {
+		// For pure insertions git reports the line *before* the insertion point.
+		oldLine++
+	}
+	if h.NewLines == 0 {
+		newLine++
+	}
+	lastKind := byte(' ')
+
+	flush := func() {
+		if cur != nil {
+			regions = append(regions, *cur)
+		}
+		cur = nil
+	}
+	start := func() {
+		if cur == nil {
+			cur = &changeRegion{OldStart: oldLine, NewStart: newLine}
+		}
+	}
+
+	for _, l := range h.Lines {
+		if l == "" {
+			continue
+		}
+		switch l[0] {
+		case '-':
+			start()
+			cur.Removed = append(cur.Removed, l[1:])
+			oldLine++
+		case '+':
+			start()
+			cur.Added = append(cur.Added, l[1:])
+			newLine++
+		case '\\':
+			if cur != nil && lastKind == '-' {
+				cur.OldNoEOL = true
+			} else if cur != nil && lastKind == '+' {
+				cur.NewNoEOL = true
+			}
+			continue
+		default:
+			flush()
+			oldLine++
+			newLine++
+		}
+		lastKind = l[0]
+	}
+	flush()
+
+	for i := range regions {
+		if len(regions[i].Removed) == 0 {
+			// Nothing is replaced: report the line the insertion follows.
+			regions[i].OldStart--
+		}
+	}
+	return regions
+}
@@ -0,0 +1,202 @@
+package step
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const sampleDiff = `diff --git a/main.go b/main.go
+index 1111111..2222222 100644
+--- a/main.go
++++ b/main.go
+@@ -1,4 +1,4 @@ package main
+ import "fmt"
+-func main() { fmt.Println("hi") }
++func main() {
++	fmt.Println("hi")
+ }
+@@ -10 +10 @@ func other() {
+--- old comment
++// new comment
+diff --git a/logo.png b/logo.png
+index 3333333..4444444 100644
+Binary files a/logo.png and b/logo.png differ
+diff --git a/old name.txt b/new name.txt
+similarity 90%
+rename from old name.txt
+rename to new name.txt
+index 5555555..6666666 100644
+--- a/old name.txt
++++ b/new name.txt
+@@ -1 +1 @@
+-a
++b
+\ No newline at end of file
+diff --git a/gen/new.go b/gen/new.go
+new file mode 100644
+index 0000000..7777777
+--- /dev/null
++++ b/gen/new.go
+@@ -0,0 +1 @@
++package gen
+diff --git a/gone.go b/gone.go
+deleted file mode 100644
+index 8888888..0000000
+--- a/gone.go
++++ /dev/null
+@@ -1 +0,0 @@
+-package gone
+diff --git a/script.sh b/script.sh
+old mode 100644
+new mode 100755
+`
+
+func Test_parseUnifiedDiff(t *testing.T) {
+	diffs := parseUnifiedDiff(sampleDiff)
+	require.Len(t, diffs, 6)
+
+	goFile := diffs[0]
+	assert.Equal(t, "main.go", goFile.Path)
+	assert.Equal(t, 3, goFile.Added)
+	assert.Equal(t, 2, goFile.Removed)
+	require.Len(t, goFile.Hunks, 2)
+	assert.Equal(t, Hunk{
+		OldStart: 1, OldLines: 4, NewStart: 1, NewLines: 4,
+		Header: `@@ -1,4 +1,4 @@ package main`,
+		Lines: []string{
+			` import "fmt"`,
+			`-func main() { fmt.Println("hi") }`,
+			`+func main() {`,
+			`+	fmt.Println("hi")`,
+			` }`,
+		},
+	}, goFile.Hunks[0])
+	// Omitted line counts default to 1, and a removed line starting with "--"
+	// must not be mistaken for a file header.
+	assert.Equal(t, 10, goFile.Hunks[1].OldStart)
+	assert.Equal(t, 1, goFile.Hunks[1].OldLines)
+	assert.Equal(t, []string{"--- old comment", "+// new comment"}, goFile.Hunks[1].Lines)
+
+	assert.Equal(t, FileDiff{Path: "logo.png", Binary: true}, diffs[1])
+
+	renamed := diffs[2]
+	assert.Equal(t, "new name.txt", renamed.Path)
+	assert.Equal(t, "old name.txt", renamed.OldPath)
+	assert.Equal(t, []string{"-a", "+b", `\ No newline at end of file`}, renamed.Hunks[0].Lines)
+
+	assert.Equal(t, "gen/new.go", diffs[3].Path)
+	assert.True(t, diffs[3].New)
+	assert.Equal(t, "gone.go", diffs[4].Path)
+	assert.True(t, diffs[4].Deleted)
+	assert.Equal(t, FileDiff{Path: "script.sh", Headers: []string{"old mode 100644", "new mode 100755"}}, diffs[5])
+}
+
+func Test_parseUnifiedDiff_pathWithSpace(t *testing.T) {
+	diff := "diff --git a/a b.txt b/a b.txt\n" +
+		"index 1111111..2222222 100644\n" +
+		"--- a/a b.txt\t\n" +
+		"+++ b/a b.txt\t\n" +
+		"@@ -1 +1 @@\n" +
+		"-a\n" +
+		"+b\n"
+
+	diffs := parseUnifiedDiff(diff)
+	require.Len(t, diffs, 1)
+	assert.Equal(t, "a b.txt", diffs[0].Path)
+	assert.Equal(t, 1, diffs[0].Added)
+}
+
+func Test_parseUnifiedDiff_binaryPatch(t *testing.T) {
+	diff := `diff --git a/logo.png b/logo.png
+index 1b2c3d4..5e6f7a8 100644
+GIT binary patch
+literal 6
+NcmZQzWMXDv0RR910096
+
+literal 3
+KcmZQzWMT~f0RRA5
+
+diff --git a/a.txt b/a.txt
+index 1111111..2222222 100644
+--- a/a.txt
++++ b/a.txt
+@@ -1 +1 @@
+-a
++b
+`
+	diffs := parseUnifiedDiff(diff)
+	require.Len(t, diffs, 2)
+	assert.Equal(t, FileDiff{Path: "logo.png", Binary: true}, diffs[0])
+	assert.Equal(t, "a.txt", diffs[1].Path)
+	assert.Equal(t, 1, diffs[1].Added)
+	assert.Equal(t, 1, diffs[1].Removed)
+}
+
+func Test_FileDiff_Patch(t *testing.T) {
+	diffs := parseUnifiedDiff(sampleDiff)
+
+	// Re-parsing the rendered patch must give back the same diff.
+	for _, d := range diffs {
+		assert.Equal(t, []FileDiff{d}, parseUnifiedDiff(d.Patch()))
+	}
+}
+
+func Test_annotateDiffStats(t *testing.T) {
+	files := []FileStatus{{Path: "main.go"}, {Path: "logo.png"}, {Path: "untouched.go"}}
+	annotateDiffStats(files, parseUnifiedDiff(sampleDiff))
+
+	assert.Equal(t, FileStatus{Path: "main.go", Added: 3, Removed: 2}, files[0])
+	assert.Equal(t, FileStatus{Path: "logo.png", Binary: true}, files[1])
+	assert.Equal(t, FileStatus{Path: "untouched.go"}, files[2])
+}
+
+func Test_changeRegions(t *testing.T) {
+	tests := []struct {
+		name string
+		hunk Hunk
+		want []changeRegion
+	}{
+		{
+			name: "replacement surrounded by context",
+			hunk: Hunk{OldStart: 1, OldLines: 4, NewStart: 1, NewLines: 5, Lines: []string{
+				" a", "-b", "+B", "+B2", " c", " d",
+			}},
+			want: []changeRegion{{OldStart: 2, NewStart: 2, Removed: []string{"b"}, Added: []string{"B", "B2"}}},
+		},
+		{
+			name: "two separate edits in one hunk",
+			hunk: Hunk{OldStart: 10, OldLines: 5, NewStart: 10, NewLines: 4, Lines: []string{
+				"-x", " a", " b", "-y", "+Y", " c",
+			}},
+			want: []changeRegion{
+				{OldStart: 10, NewStart: 10, Removed: []string{"x"}},
+				{OldStart: 13, NewStart: 12, Removed: []string{"y"}, Added: []string{"Y"}},
+			},
+		},
+		{
+			name: "pure insertion without context",
+			hunk: Hunk{OldStart: 5, OldLines: 0, NewStart: 6, NewLines: 2, Lines: []string{"+n1", "+n2"}},
+			want: []changeRegion{{OldStart: 5, NewStart: 6, Added: []string{"n1", "n2"}}},
+		},
+		{
+			name: "new file",
+			hunk: Hunk{OldStart: 0, OldLines: 0, NewStart: 1, NewLines: 1, Lines: []string{"+package gen"}},
+			want: []changeRegion{{OldStart: 0, NewStart: 1, Added: []string{"package gen"}}},
+		},
+		{
+			name: "missing newline at end of file is fixed",
+			hunk: Hunk{OldStart: 1, OldLines: 1, NewStart: 1, NewLines: 1, Lines: []string{
+				"-a", `\ No newline at end of file`, "+a",
+			}},
+			want: []changeRegion{{OldStart: 1, NewStart: 1, Removed: []string{"a"}, Added: []string{"a"}, OldNoEOL: true}},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, changeRegions(tt.hunk))
+		})
+	}
+}
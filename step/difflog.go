@@ -0,0 +1,149 @@
+package step
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/bitrise-io/go-utils/v2/log/colorstring"
+)
+
+const (
+	// largeFileSize is the size above which a file's diff is summarized instead
+	// of printed, regardless of the line limits. Such diffs are usually generated
+	// or minified content that nobody reads line by line.
+	largeFileSize = 1024 * 1024
+	// maxStatBarWidth caps the +/- bar of the stat view, like git diff --stat does.
+	maxStatBarWidth = 40
+)
+
+// logDiff prints a `git diff --stat` style overview followed by the colorized,
+// truncated autofix diff.
+func (s Step) logDiff(diffs []FileDiff, maxLinesPerFile, maxLines int) {
+	if len(diffs) == 0 {
+		return
+	}
+
+	s.logger.Println()
+	for _, line := range renderDiffStat(diffs) {
+		s.logger.Printf("%s", line)
+	}
+
+	if maxLines == 0 {
+		return
+	}
+	s.logger.Println()
+	for _, line := range renderDiff(diffs, maxLinesPerFile, maxLines, s.fileSize) {
+		s.logger.Printf("%s", line)
+	}
+}
+
+func renderDiffStat(diffs []FileDiff) []string {
+	nameWidth, maxChanges := 0, 0
+	totalAdded, totalRemoved := 0, 0
+	for _, d := range diffs {
+		nameWidth = max(nameWidth, len(diffDisplayName(d)))
+		maxChanges = max(maxChanges, d.Added+d.Removed)
+		totalAdded += d.Added
+		totalRemoved += d.Removed
+	}
+	countWidth := len(fmt.Sprint(maxChanges))
+
+	var lines []string
+	for _, d := range diffs {
+		name := diffDisplayName(d)
+		if d.Binary {
+			lines = append(lines, fmt.Sprintf(" %-*s | Bin", nameWidth, name))
+			continue
+		}
+		added, removed := d.Added, d.Removed
+		// Scale the bar down proportionally when the largest change doesn't fit.
+		if maxChanges > maxStatBarWidth {
+			added = scaleStat(added, maxChanges)
+			removed = scaleStat(removed, maxChanges)
+		}
+		bar := colorstring.Green(strings.Repeat("+", added)) + colorstring.Red(strings.Repeat("-", removed))
+		lines = append(lines, fmt.Sprintf(" %-*s | %*d %s", nameWidth, name, countWidth, d.Added+d.Removed, bar))
+	}
+	lines = append(lines, fmt.Sprintf(" %d file(s) changed, %d insertion(s)(+), %d deletion(s)(-)", len(diffs), totalAdded, totalRemoved))
+	return lines
+}
+
+func scaleStat(n, maxChanges int) int {
+	scaled := n * maxStatBarWidth / maxChanges
+	if n > 0 && scaled == 0 {
+		// Every changed file should show at least one marker.
+		return 1
+	}
+	return scaled
+}
+
+// renderDiff colorizes the diff, printing at most maxLinesPerFile lines of each
+// file (0 means no per-file limit) and maxLines lines in total.
+// Binary files and files larger than largeFileSize are summarized in one line.
+func renderDiff(diffs []FileDiff, maxLinesPerFile, maxLines int, sizeOf func(string) int64) []string {
+	var lines []string
+	total := 0
+	for i, d := range diffs {
+		if total >= maxLines {
+			lines = append(lines, colorstring.Yellow(fmt.Sprintf("... diff of %d more file(s) not shown (limit: %d lines in total)", len(diffs)-i, maxLines)))
+			break
+		}
+
+		lines = append(lines, colorstring.Yellow(fmt.Sprintf("--- %s", diffDisplayName(d))))
+		if d.Binary {
+			lines = append(lines, "    (binary file changed)")
+			continue
+		}
+		if size := sizeOf(d.Path); size > largeFileSize {
+			lines = append(lines, fmt.Sprintf("    (large file, %d bytes: +%d -%d lines, diff not shown)", size, d.Added, d.Removed))
+			continue
+		}
+
+		fileLines := 0
+		omitted := 0
+		for _, h := range d.Hunks {
+			for _, l := range append([]string{h.Header}, h.Lines...) {
+				if (maxLinesPerFile > 0 && fileLines >= maxLinesPerFile) || total >= maxLines {
+					omitted++
+					continue
+				}
+				lines = append(lines, colorizeDiffLine(l))
+				fileLines++
+				total++
+			}
+		}
+		if omitted > 0 {
+			lines = append(lines, colorstring.Yellow(fmt.Sprintf("... %d more line(s) of %s not shown", omitted, d.Path)))
+		}
+	}
+	return lines
+}
+
+func colorizeDiffLine(line string) string {
+	switch {
+	case strings.HasPrefix(line, "@@"):
+		return colorstring.Cyan(line)
+	case strings.HasPrefix(line, "+"):
+		return colorstring.Green(line)
+	case strings.HasPrefix(line, "-"):
+		return colorstring.Red(line)
+	default:
+		return line
+	}
+}
+
+func diffDisplayName(d FileDiff) string {
+	if d.OldPath != "" {
+		return d.OldPath + " -> " + d.Path
+	}
+	return d.Path
+}
+
+func (s Step) fileSize(path string) int64 {
+	info, err := os.Stat(s.repoFile(path))
+	if err != nil {
+		return 0
+	}
+	return info.Size()
+}
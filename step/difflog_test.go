@@ -0,0 +1,82 @@
+package step
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func noSize(string) int64 { return 0 }
+
+func Test_renderDiffStat(t *testing.T) {
+	lines := renderDiffStat([]FileDiff{
+		{Path: "main.go", Added: 3, Removed: 2},
+		{Path: "logo.png", Binary: true},
+	})
+
+	require.Len(t, lines, 3)
+	assert.True(t, strings.HasPrefix(lines[0], " main.go  | 5 "))
+	assert.Contains(t, lines[0], "+++")
+	assert.Contains(t, lines[0], "--")
+	assert.Equal(t, " logo.png | Bin", lines[1])
+	assert.Equal(t, " 2 file(s) changed, 3 insertion(s)(+), 2 deletion(s)(-)", lines[2])
+}
+
+func Test_renderDiffStat_ScalesBar(t *testing.T) {
+	lines := renderDiffStat([]FileDiff{
+		{Path: "huge.go", Added: 400},
+		{Path: "tiny.go", Added: 1},
+	})
+
+	assert.Contains(t, lines[0], strings.Repeat("+", maxStatBarWidth))
+	assert.NotContains(t, lines[0], strings.Repeat("+", maxStatBarWidth+1))
+	assert.Contains(t, lines[1], "+")
+}
+
+func Test_renderDiff(t *testing.T) {
+	lines := renderDiff(parseUnifiedDiff(sampleDiff)[:2], 0, 100, noSize)
+	out := strings.Join(lines, "\n")
+
+	assert.Contains(t, out, "--- main.go")
+	assert.Contains(t, out, `+func main() {`)
+	assert.Contains(t, out, `-func main() { fmt.Println("hi") }`)
+	assert.Contains(t, out, "--- logo.png")
+	assert.Contains(t, out, "(binary file changed)")
+}
+
+func Test_renderDiff_Limits(t *testing.T) {
+	var hunkLines []string
+	for i := 0; i < 20; i++ {
+		hunkLines = append(hunkLines, fmt.Sprintf("+line %d", i))
+	}
+	diffs := []FileDiff{
+		{Path: "a.txt", Added: 20, Hunks: []Hunk{{Header: "@@ -0,0 +1,20 @@", Lines: hunkLines}}},
+		{Path: "b.txt", Added: 20, Hunks: []Hunk{{Header: "@@ -0,0 +1,20 @@", Lines: hunkLines}}},
+		{Path: "c.txt", Added: 20, Hunks: []Hunk{{Header: "@@ -0,0 +1,20 @@", Lines: hunkLines}}},
+	}
+
+	t.Run("per file", func(t *testing.T) {
+		out := strings.Join(renderDiff(diffs, 5, 100, noSize), "\n")
+		assert.Contains(t, out, "+line 3")
+		assert.NotContains(t, out, "+line 4")
+		assert.Contains(t, out, "... 16 more line(s) of a.txt not shown")
+	})
+
+	t.Run("total", func(t *testing.T) {
+		out := strings.Join(renderDiff(diffs, 0, 30, noSize), "\n")
+		assert.Contains(t, out, "--- b.txt")
+		assert.Contains(t, out, "... 12 more line(s) of b.txt not shown")
+		assert.Contains(t, out, "... diff of 1 more file(s) not shown")
+		assert.NotContains(t, out, "--- c.txt")
+	})
+
+	t.Run("large file", func(t *testing.T) {
+		large := func(string) int64 { return largeFileSize + 1 }
+		out := strings.Join(renderDiff(diffs[:1], 0, 100, large), "\n")
+		assert.Contains(t, out, "diff not shown")
+		assert.NotContains(t, out, "+line 0")
+	})
+}
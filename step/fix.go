@@ -0,0 +1,205 @@
+package step
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/bitrise-io/go-utils/v2/command"
+)
+
+// fixCommand is one line of the fix_commands or validate_commands input:
+// a shell command and the name it is logged and attributed with.
+type fixCommand struct {
+	Name    string
+	Command string
+}
+
+var fixCommandNameRegexp = regexp.MustCompile(`^([A-Za-z0-9_.-]+):\s+(.+)$`)
+
+// parseFixCommands parses "name: command" lines. Lines without a name are
+// named after the program they run, blank lines and # comments are skipped.
+func parseFixCommands(lines []string) []fixCommand {
+	var cmds []fixCommand
+	for _, line := range lines {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		if m := fixCommandNameRegexp.FindStringSubmatch(line); m != nil {
+			cmds = append(cmds, fixCommand{Name: m[1], Command: m[2]})
+			continue
+		}
+		cmds = append(cmds, fixCommand{Name: strings.Fields(line)[0], Command: line})
+	}
+	return cmds
+}
+
+// errFixNotStable is returned when the fix commands still change files in the last allowed pass.
+var errFixNotStable = errors.New("fix commands did not reach a fixed point")
+
+// treeSnapshot maps every path that differs from HEAD to a hash of its content.
+type treeSnapshot map[string]string
+
+const deletedFileHash = "deleted"
+
+// runFixCommands runs the commands in order and attributes every file a command
+// changed to it. With untilStable, the whole list is re-run until a pass changes
+// nothing, for at most maxPasses passes.
+func (s Step) runFixCommands(cmds []fixCommand, untilStable bool, maxPasses int) (map[string][]string, error) {
+	if !untilStable {
+		maxPasses = 1
+	}
+	attribution := map[string][]string{}
+
+	before, err := s.snapshotTree()
+	if err != nil {
+		return nil, err
+	}
+	for pass := 1; pass <= maxPasses; pass++ {
+		changedInPass := false
+		for _, c := range cmds {
+			s.logger.Println()
+			if untilStable {
+				s.logger.Infof("Running fix command %s (pass %d/%d): %s", c.Name, pass, maxPasses, c.Command)
+			} else {
+				s.logger.Infof("Running fix command %s: %s", c.Name, c.Command)
+			}
+			if err := s.runShellCommand(c); err != nil {
+				return attribution, fmt.Errorf("fix command %s failed: %w", c.Name, err)
+			}
+
+			after, err := s.snapshotTree()
+			if err != nil {
+				return attribution, err
+			}
+			changed := changedPaths(before, after)
+			s.logger.Printf("%s changed %d file(s)", c.Name, len(changed))
+			for _, path := range changed {
+				attribution[path] = appendUnique(attribution[path], c.Name)
+			}
+			changedInPass = changedInPass || len(changed) > 0
+			before = after
+		}
+
+		if !untilStable || !changedInPass {
+			return attribution, nil
+		}
+		if pass == maxPasses {
+			return attribution, fmt.Errorf("%w: files still changed in pass %d/%d, the tools probably undo each other's changes", errFixNotStable, pass, maxPasses)
+		}
+	}
+	return attribution, nil
+}
+
+// runShellCommand runs the command in the repo root with its output streamed to the build log.
+func (s Step) runShellCommand(c fixCommand) error {
+	return s.commandFactory.Create("sh", []string{"-c", c.Command}, &command.Opts{Stdout: os.Stdout, Stderr: os.Stderr}).Run()
+}
+
+// runValidateCommands runs the commands on the autofixed tree and stops at the first failure.
+func (s Step) runValidateCommands(cmds []fixCommand) error {
+	for _, c := range cmds {
+		s.logger.Println()
+		s.logger.Infof("Running validate command %s: %s", c.Name, c.Command)
+		if err := s.runShellCommand(c); err != nil {
+			return fmt.Errorf("validate command %s failed: %w", c.Name, err)
+		}
+	}
+	return nil
+}
+
+// snapshotTree hashes the content of every file that differs from HEAD,
+// including untracked ones, so changes between two snapshots can be attributed.
+func (s Step) snapshotTree() (treeSnapshot, error) {
+	changes, err := s.getChangedFiles(true)
+	if err != nil {
+		return nil, fmt.Errorf("snapshot working tree: %w", err)
+	}
+	snapshot := treeSnapshot{}
+	for _, f := range changes {
+		if f.OldPath != "" {
+			snapshot[f.OldPath] = deletedFileHash
+		}
+		hash, err := hashFile(s.repoFile(f.Path))
+		if err != nil {
+			return nil, fmt.Errorf("snapshot working tree: %w", err)
+		}
+		snapshot[f.Path] = hash
+	}
+	return snapshot, nil
+}
+
+func hashFile(path string) (string, error) {
+	info, err := os.Lstat(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return deletedFileHash, nil
+	}
+	if err != nil {
+		return "", err
+	}
+
+	var data []byte
+	switch {
+	case info.Mode()&os.ModeSymlink != 0:
+		target, err := os.Readlink(path)
+		if err != nil {
+			return "", err
+		}
+		data = []byte("symlink:" + target)
+	case info.IsDir():
+		// Submodules show up as directories; their own commit is what changes.
+		return "directory", nil
+	default:
+		if data, err = os.ReadFile(path); err != nil {
+			return "", err
+		}
+	}
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:]), nil
+}
+
+// changedPaths returns the sorted paths whose state differs between the snapshots.
+// A path missing from a snapshot is identical to HEAD.
+func changedPaths(before, after treeSnapshot) []string {
+	var paths []string
+	for path, hash := range after {
+		if before[path] != hash {
+			paths = append(paths, path)
+		}
+	}
+	for path := range before {
+		if _, ok := after[path]; !ok {
+			paths = append(paths, path)
+		}
+	}
+	sort.Strings(paths)
+	return paths
+}
+
+func appendUnique(list []string, item string) []string {
+	for _, existing := range list {
+		if existing == item {
+			return list
+		}
+	}
+	return append(list, item)
+}
+
+// annotateTools copies the fix command attribution onto the matching file statuses.
+func annotateTools(files []FileStatus, attribution map[string][]string) {
+	for i, f := range files {
+		tools := append([]string(nil), attribution[f.Path]...)
+		if f.OldPath != "" {
+			for _, t := range attribution[f.OldPath] {
+				tools = appendUnique(tools, t)
+			}
+		}
+		files[i].Tools = tools
+	}
+}
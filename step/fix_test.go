@@ -0,0 +1,70 @@
+package step
+
+import (
+	"testing"
+
+	"github.com/bitrise-io/go-utils/v2/log"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_parseFixCommands(t *testing.T) {
+	lines := []string{
+		"swiftformat .",
+		"",
+		"# formatting comes first",
+		"lint: ktlint -F 'src/**/*.kt'",
+		"  gofmt -w .  ",
+		"echo a: b",
+	}
+
+	cmds := parseFixCommands(lines)
+
+	assert.Equal(t, []fixCommand{
+		{Name: "swiftformat", Command: "swiftformat ."},
+		{Name: "lint", Command: "ktlint -F 'src/**/*.kt'"},
+		{Name: "gofmt", Command: "gofmt -w ."},
+		{Name: "echo", Command: "echo a: b"},
+	}, cmds)
+}
+
+func Test_changedPaths(t *testing.T) {
+	before := treeSnapshot{"a.go": "1", "b.go": "2", "c.go": "3"}
+	after := treeSnapshot{"a.go": "1", "b.go": "changed", "d.go": "4"}
+
+	// c.go is missing from after: the command restored it to its HEAD state.
+	assert.Equal(t, []string{"b.go", "c.go", "d.go"}, changedPaths(before, after))
+	assert.Empty(t, changedPaths(after, after))
+}
+
+func Test_annotateTools(t *testing.T) {
+	files := []FileStatus{
+		{Path: "a.go", Status: FileModified},
+		{Path: "new.go", OldPath: "old.go", Status: FileRenamed},
+		{Path: "untouched.go", Status: FileModified},
+	}
+	attribution := map[string][]string{
+		"a.go":   {"gofmt", "goimports"},
+		"old.go": {"mover"},
+		"new.go": {"gofmt", "mover"},
+	}
+
+	annotateTools(files, attribution)
+
+	assert.Equal(t, []string{"gofmt", "goimports"}, files[0].Tools)
+	assert.Equal(t, []string{"gofmt", "mover"}, files[1].Tools)
+	assert.Nil(t, files[2].Tools)
+}
+
+func Test_runValidateCommands(t *testing.T) {
+	factory := &fakeCommandFactory{}
+	s := Step{commandFactory: factory, logger: log.NewLogger(), envRepo: fakeEnvRepo{}}
+
+	err := s.runValidateCommands(parseFixCommands([]string{"build: swift build", "swiftlint --strict"}))
+
+	require.NoError(t, err)
+	require.Len(t, factory.calls, 2)
+	assert.Equal(t, "sh", factory.calls[0].name)
+	assert.Equal(t, []string{"-c", "swift build"}, factory.calls[0].args)
+	assert.Equal(t, []string{"-c", "swiftlint --strict"}, factory.calls[1].args)
+}
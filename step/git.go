@@ -2,8 +2,10 @@ package step
 
 import (
 	"bytes"
+	"errors"
 	"fmt"
 	"os"
+	"strconv"
 	"strings"
 
 	"github.com/bitrise-steplib/bitrise-step-autofix-ci/gitcredential"
@@ -16,49 +18,96 @@ const (
 	botEmail = "autofix@bitrise.io"
 )
 
-func (s Step) getChangedFiles(includeUntracked bool) ([]string, error) {
+// errCherryPickConflict is returned by gitFetchAndCheckout when the autofix
+// changes can't be replayed on top of the PR branch.
+var errCherryPickConflict = errors.New("cherry-pick failed (changes conflict with base branch changes)")
+
+func (s Step) getChangedFiles(includeUntracked bool) ([]FileStatus, error) {
 	// git status --porcelain covers both modified tracked files and new untracked files.
 	// git diff HEAD --name-only would miss untracked files, which are common output from
-	// code generators and formatters that create new files.
+	// code generators and formatters that create new files. With --untracked-files=all,
+	// untracked directories are listed file by file, so path filters can match them.
 	//
 	// We capture stdout into a buffer instead of using RunAndReturnTrimmedCombinedOutput
-	// because TrimSpace strips the leading space from the first line, which corrupts the
-	// fixed-column porcelain format (e.g. " M file" → "M file", then line[3:] = "ile").
+	// because TrimSpace strips the leading space from the first entry, which corrupts the
+	// fixed-column porcelain format (e.g. " M file" → "M file", then entry[3:] = "ile").
+	// -z lists the paths as they are: without it, git quotes paths with spaces or
+	// non-ASCII characters, and the quoted path matches no file.
 	var outBuf bytes.Buffer
-	cmd := s.commandFactory.Create("git", []string{"status", "--porcelain"}, &command.Opts{Stdout: &outBuf})
+	cmd := s.commandFactory.Create("git", s.withPathspec([]string{"status", "--porcelain", "-z", "--untracked-files=all"}), &command.Opts{Stdout: &outBuf})
 	if err := cmd.Run(); err != nil {
 		return nil, fmt.Errorf("run git status: %w", err)
 	}
-	return parseGitStatus(outBuf.String(), includeUntracked), nil
+	return parseGitStatusEntries(outBuf.String(), includeUntracked), nil
 }
 
-// parseGitStatus extracts filenames from `git status --porcelain` output.
-// Each line is "XY filename" where X is the index (staged) status and Y is the
-// worktree status. The filename always starts at position 3.
+// parseGitStatusEntries parses `git status --porcelain -z` output into file statuses.
+// Each entry is "XY filename" followed by a NUL, where X is the index (staged)
+// status and Y is the worktree status. The filename always starts at position 3.
+// A rename or copy is followed by one more entry: the path it came from.
 //
 // Callers must pass the raw output without TrimSpace: status characters can be
 // spaces (e.g. " M file" = unstaged modification), so stripping leading
 // whitespace from the whole string corrupts the fixed-column format.
-func parseGitStatus(output string, includeUntracked bool) []string {
-	if strings.TrimSpace(output) == "" {
+func parseGitStatusEntries(output string, includeUntracked bool) []FileStatus {
+	if strings.TrimSpace(strings.ReplaceAll(output, "\x00", "")) == "" {
 		return nil
 	}
-	var files []string
-	for _, line := range strings.Split(output, "\n") {
-		// Minimum valid line: "XY f" = 4 chars (2 status + space + 1 char filename)
-		if len(line) < 4 {
+	var files []FileStatus
+	entries := strings.Split(output, "\x00")
+	for i := 0; i < len(entries); i++ {
+		entry := entries[i]
+		// Minimum valid entry: "XY f" = 4 chars (2 status + space + 1 char filename)
+		if len(entry) < 4 {
 			continue
 		}
+		f := FileStatus{Path: entry[3:], Status: fileChangeKind(entry[0], entry[1])}
+		if strings.ContainsAny(entry[:2], "RC") && i+1 < len(entries) {
+			i++
+			f.OldPath = entries[i]
+		}
 		// Untracked files are marked with "??" in porcelain format.
-		if !includeUntracked && strings.HasPrefix(line, "??") {
+		if !includeUntracked && f.Status == FileUntracked {
 			continue
 		}
-		files = append(files, line[3:])
+		files = append(files, f)
 	}
 	return files
 }
 
-func (s Step) gitFetchAndCheckout(branch, username, token string) error {
+// fileChangeKind maps the porcelain XY status pair to a single kind.
+// The index status wins over the worktree status, except that a deletion on
+// either side means the file is gone from the commit.
+func fileChangeKind(x, y byte) FileChangeKind {
+	if x == '?' && y == '?' {
+		return FileUntracked
+	}
+	if x == 'D' || y == 'D' {
+		return FileDeleted
+	}
+	code := x
+	if code == ' ' {
+		code = y
+	}
+	switch code {
+	case 'A':
+		return FileAdded
+	case 'R':
+		return FileRenamed
+	case 'C':
+		return FileCopied
+	case 'T':
+		return FileTypeChanged
+	case 'U':
+		return FileUnmerged
+	default:
+		return FileModified
+	}
+}
+
+// gitFetchAndCheckout leaves the changes staged on top of the branch. Paths in
+// unstaged are kept out of the commit, see gitAddAll.
+func (s Step) gitFetchAndCheckout(branch, username, token string, unstaged ...string) error {
 	// PR builds check out refs/pull/N/merge — a temporary merge commit GitHub
 	// creates for CI. Its parent chain includes base-branch commits, so pushing
 	// HEAD directly to the PR branch would be a non-fast-forward, and the
@@ -72,9 +121,8 @@ func (s Step) gitFetchAndCheckout(branch, username, token string) error {
 	//    formatter's delta on top of the PR branch via a 3-way merge, leaving
 	//    the working tree staged and ready for the real autofix commit.
 
-	s.logger.Debugf("$ git add --all")
-	if out, err := s.commandFactory.Create("git", []string{"add", "--all"}, nil).RunAndReturnTrimmedCombinedOutput(); err != nil {
-		return fmt.Errorf("%w\n%s", err, out)
+	if err := s.gitAddAll(unstaged...); err != nil {
+		return err
 	}
 
 	s.logger.Debugf("$ git commit (temporary, on merge ref)")
@@ -92,6 +140,52 @@ func (s Step) gitFetchAndCheckout(branch, username, token string) error {
 	}
 	s.logger.Debugf("Temporary commit on merge ref: %s", tempCommit)
 
+	if err := s.gitFetchRef(username, token, branch); err != nil {
+		return err
+	}
+
+	s.logger.Debugf("$ git checkout -B %s origin/%s", branch, branch)
+	if out, err := s.commandFactory.Create("git", []string{"checkout", "-B", branch, "origin/" + branch}, nil).RunAndReturnTrimmedCombinedOutput(); err != nil {
+		return fmt.Errorf("%w\n%s", err, out)
+	}
+
+	s.logger.Debugf("$ git cherry-pick --no-commit %s", tempCommit)
+	if out, err := s.commandFactory.Create("git", []string{"cherry-pick", "--no-commit", tempCommit}, nil).RunAndReturnTrimmedCombinedOutput(); err != nil {
+		// Cherry-pick leaves the repo in an in-progress state on failure; abort to clean up.
+		s.commandFactory.Create("git", []string{"cherry-pick", "--abort"}, nil).RunAndReturnTrimmedCombinedOutput() //nolint:errcheck
+		return fmt.Errorf("%w: %w\n%s", errCherryPickConflict, err, out)
+	}
+
+	return nil
+}
+
+// gitAddAll stages every change in the pathspec, except the paths in unstaged.
+// Those are submodules checked out at another commit, which reverting can't
+// undo. Anything staged outside the pathspec is unstaged too, so only the
+// pathspec ends up in the commit.
+func (s Step) gitAddAll(unstaged ...string) error {
+	s.logger.Debugf("$ git add --all")
+	if out, err := s.commandFactory.Create("git", s.withPathspec([]string{"add", "--all"}), nil).RunAndReturnTrimmedCombinedOutput(); err != nil {
+		return fmt.Errorf("%w\n%s", err, out)
+	}
+	outside, err := s.gitStagedOutsidePathspec()
+	if err != nil {
+		return err
+	}
+	unstaged = append(unstaged, outside...)
+	if len(unstaged) == 0 {
+		return nil
+	}
+	args := append([]string{"reset", "-q", "HEAD", "--"}, unstaged...)
+	if out, err := s.commandFactory.Create("git", args, nil).RunAndReturnTrimmedCombinedOutput(); err != nil {
+		return fmt.Errorf("unstage %s: %w\n%s", strings.Join(unstaged, ", "), err, out)
+	}
+	return nil
+}
+
+// gitFetchRef fetches the tip of a ref from origin into FETCH_HEAD (and the
+// remote-tracking branch, for branch names), authenticating with the token when set.
+func (s Step) gitFetchRef(username, token, ref string) error {
 	var fetchArgs []string
 	var fetchOpts *command.Opts
 	if token != "" {
@@ -100,40 +194,36 @@ func (s Step) gitFetchAndCheckout(branch, username, token string) error {
 			return err
 		}
 		defer os.Remove(helper.Path)
-		fetchArgs = []string{"-c", fmt.Sprintf("credential.helper=%s", helper.Path), "fetch", "--depth", "1", "origin", branch}
+		fetchArgs = []string{"-c", fmt.Sprintf("credential.helper=%s", helper.Path), "fetch", "--depth", "1", "origin", ref}
 		fetchOpts = &command.Opts{Env: helper.Env}
 	} else {
-		fetchArgs = []string{"fetch", "--depth", "1", "origin", branch}
+		fetchArgs = []string{"fetch", "--depth", "1", "origin", ref}
 	}
 
-	s.logger.Debugf("$ git fetch --depth 1 origin %s", branch)
+	s.logger.Debugf("$ git fetch --depth 1 origin %s", ref)
 	if out, err := s.commandFactory.Create("git", fetchArgs, fetchOpts).RunAndReturnTrimmedCombinedOutput(); err != nil {
 		return fmt.Errorf("%w\n%s", err, out)
 	}
-
-	s.logger.Debugf("$ git checkout -B %s origin/%s", branch, branch)
-	if out, err := s.commandFactory.Create("git", []string{"checkout", "-B", branch, "origin/" + branch}, nil).RunAndReturnTrimmedCombinedOutput(); err != nil {
-		return fmt.Errorf("%w\n%s", err, out)
-	}
-
-	s.logger.Debugf("$ git cherry-pick --no-commit %s", tempCommit)
-	if out, err := s.commandFactory.Create("git", []string{"cherry-pick", "--no-commit", tempCommit}, nil).RunAndReturnTrimmedCombinedOutput(); err != nil {
-		// Cherry-pick leaves the repo in an in-progress state on failure; abort to clean up.
-		s.commandFactory.Create("git", []string{"cherry-pick", "--abort"}, nil).RunAndReturnTrimmedCombinedOutput() //nolint:errcheck
-		return fmt.Errorf("cherry-pick failed (changes conflict with base branch changes): %w\n%s", err, out)
-	}
-
 	return nil
 }
 
-func (s Step) gitCommit(message string) error {
+// gitCommit commits the staged changes, or only the given paths of them.
+func (s Step) gitCommit(message string, paths ...string) error {
 	s.logger.Debugf("$ git commit -m %q", message)
-	cmd := s.commandFactory.Create("git", []string{
+	args := []string{
 		"-c", fmt.Sprintf("user.name=%s", botName),
 		"-c", fmt.Sprintf("user.email=%s", botEmail),
 		"commit",
 		"-m", message,
-	}, nil)
+	}
+	var opts *command.Opts
+	if len(paths) > 0 {
+		// The paths are file names, not patterns, and there can be more than fit on a command line.
+		args = append([]string{"--literal-pathspecs"}, args...)
+		args = append(args, "--pathspec-from-file=-", "--pathspec-file-nul")
+		opts = &command.Opts{Stdin: strings.NewReader(strings.Join(paths, "\x00"))}
+	}
+	cmd := s.commandFactory.Create("git", args, opts)
 	out, err := cmd.RunAndReturnTrimmedCombinedOutput()
 	if err != nil {
 		return fmt.Errorf("%w\n%s", err, out)
@@ -142,8 +232,50 @@ func (s Step) gitCommit(message string) error {
 	return nil
 }
 
-func (s Step) gitPush(username, token, branch string) error {
-	s.logger.Debugf("$ git push origin HEAD:%s", branch)
+// gitAutofixRound returns the Autofix-Round trailer of the HEAD commit, 0 if
+// HEAD is not an autofix commit.
+func (s Step) gitAutofixRound() (int, error) {
+	format := fmt.Sprintf("--format=%%(trailers:key=%s,valueonly,separator=%%x2C)", autofixRoundTrailer)
+	out, err := s.commandFactory.Create("git", []string{"log", "-1", format, "HEAD"}, nil).RunAndReturnTrimmedOutput()
+	if err != nil {
+		return 0, fmt.Errorf("%w\n%s", err, out)
+	}
+	if out == "" {
+		return 0, nil
+	}
+	// A rewritten commit could carry the trailer twice, the last one wins.
+	values := strings.Split(out, ",")
+	round, err := strconv.Atoi(strings.TrimSpace(values[len(values)-1]))
+	if err != nil {
+		return 0, fmt.Errorf("invalid %s trailer %q", autofixRoundTrailer, out)
+	}
+	return round, nil
+}
+
+// gitDiscardCommits drops the last n commits together with their changes.
+func (s Step) gitDiscardCommits(n int) error {
+	out, err := s.commandFactory.Create("git", []string{"reset", "--hard", fmt.Sprintf("HEAD~%d", n)}, nil).RunAndReturnTrimmedCombinedOutput()
+	if err != nil {
+		return fmt.Errorf("%w\n%s", err, out)
+	}
+	return nil
+}
+
+func (s Step) gitHeadSHA() (string, error) {
+	out, err := s.commandFactory.Create("git", []string{"rev-parse", "HEAD"}, nil).RunAndReturnTrimmedCombinedOutput()
+	if err != nil {
+		return "", fmt.Errorf("%w\n%s", err, out)
+	}
+	return out, nil
+}
+
+func (s Step) gitPush(username, token, branch string, pushOptions ...string) error {
+	refspec := fmt.Sprintf("HEAD:%s", branch)
+	var optionArgs []string
+	for _, o := range pushOptions {
+		optionArgs = append(optionArgs, "--push-option="+o)
+	}
+	s.logger.Debugf("$ git push %s origin %s", strings.Join(optionArgs, " "), refspec)
 
 	var pushArgs []string
 	var pushOpts *command.Opts
@@ -153,11 +285,13 @@ func (s Step) gitPush(username, token, branch string) error {
 			return err
 		}
 		defer os.Remove(helper.Path)
-		pushArgs = []string{"-c", fmt.Sprintf("credential.helper=%s", helper.Path), "push", "origin", fmt.Sprintf("HEAD:%s", branch)}
+		pushArgs = []string{"-c", fmt.Sprintf("credential.helper=%s", helper.Path), "push"}
 		pushOpts = &command.Opts{Env: helper.Env}
 	} else {
-		pushArgs = []string{"push", "origin", fmt.Sprintf("HEAD:%s", branch)}
+		pushArgs = []string{"push"}
 	}
+	pushArgs = append(pushArgs, optionArgs...)
+	pushArgs = append(pushArgs, "origin", refspec)
 
 	cmd := s.commandFactory.Create("git", pushArgs, pushOpts)
 	out, err := cmd.RunAndReturnTrimmedCombinedOutput()
@@ -203,3 +337,58 @@ func (s Step) getRemoteURL() (string, error) {
 func isSSHRemote(url string) bool {
 	return strings.HasPrefix(url, "git@") || strings.HasPrefix(url, "ssh://")
 }
+
+// gitLabSkipCIPushOption tells GitLab not to create a pipeline for the pushed commit.
+// Other forges reject unknown push options, so it is only sent to GitLab.
+const gitLabSkipCIPushOption = "ci.skip"
+
+// isGitLabRemote guesses the forge from the origin URL. Self-hosted GitLab
+// instances usually have "gitlab" in their host name as well.
+func (s Step) isGitLabRemote() bool {
+	remoteURL, err := s.getRemoteURL()
+	if err != nil {
+		s.logger.Debugf("Failed to detect remote URL: %s", err)
+		return false
+	}
+	return strings.Contains(strings.ToLower(remoteURL), "gitlab")
+}
+
+// revertChanges restores the given files to their HEAD state in the working
+// tree and the index, so they are left out of the autofix commit.
+func (s Step) revertChanges(files []FileStatus) error {
+	var all, inHead, created []string
+	for _, f := range files {
+		all = append(all, f.Path)
+		switch f.Status {
+		case FileUntracked, FileAdded, FileCopied:
+			created = append(created, f.Path)
+		case FileRenamed:
+			all = append(all, f.OldPath)
+			inHead = append(inHead, f.OldPath)
+			created = append(created, f.Path)
+		default:
+			inHead = append(inHead, f.Path)
+		}
+	}
+	if len(all) == 0 {
+		return nil
+	}
+
+	// Unstage first: staged new files become untracked and are cleaned up below.
+	steps := [][]string{append([]string{"reset", "-q", "HEAD", "--"}, all...)}
+	if len(inHead) > 0 {
+		steps = append(steps, append([]string{"checkout", "HEAD", "--"}, inHead...))
+	}
+	if len(created) > 0 {
+		steps = append(steps, append([]string{"clean", "-f", "-q", "--"}, created...))
+	}
+	for _, args := range steps {
+		s.logger.Debugf("$ git %s", strings.Join(args, " "))
+		// The paths are file names: "[id].tsx" must not also match "i.tsx" and "d.tsx".
+		cmd := s.commandFactory.Create("git", append([]string{"--literal-pathspecs"}, args...), nil)
+		if out, err := cmd.RunAndReturnTrimmedCombinedOutput(); err != nil {
+			return fmt.Errorf("git %s: %w\n%s", args[0], err, out)
+		}
+	}
+	return nil
+}
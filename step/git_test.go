@@ -1,8 +1,14 @@
 package step
 
 import (
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
 	"testing"
 
+	"github.com/bitrise-io/go-utils/v2/command"
+	"github.com/bitrise-io/go-utils/v2/env"
 	"github.com/bitrise-io/go-utils/v2/log"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
@@ -108,6 +114,45 @@ func Test_gitPush_NoCredentialHelper_SSH(t *testing.T) {
 	}
 }
 
+func Test_gitPush_PushOptions(t *testing.T) {
+	factory := &fakeCommandFactory{}
+	s := Step{
+		commandFactory: factory,
+		logger:         log.NewLogger(),
+		envRepo:        fakeEnvRepo{},
+	}
+
+	err := s.gitPush("", "mytoken", "main", gitLabSkipCIPushOption)
+	require.NoError(t, err)
+
+	pushCall, ok := factory.findCall("push")
+	require.True(t, ok, "no git push command was recorded")
+
+	// Push options must come after the subcommand and before the remote.
+	args := strings.Join(pushCall.args, " ")
+	assert.True(t, strings.HasSuffix(args, "push --push-option=ci.skip origin HEAD:main"), "unexpected push args: %v", pushCall.args)
+}
+
+func Test_isGitLabRemote(t *testing.T) {
+	tests := []struct {
+		remoteURL string
+		want      bool
+	}{
+		{remoteURL: "https://gitlab.com/org/repo.git", want: true},
+		{remoteURL: "git@gitlab.example.com:org/repo.git", want: true},
+		{remoteURL: "https://github.com/org/repo.git", want: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.remoteURL, func(t *testing.T) {
+			s := Step{
+				commandFactory: &fakeCommandFactory{responses: map[string]string{"get-url": tt.remoteURL}},
+				logger:         log.NewLogger(),
+			}
+			assert.Equal(t, tt.want, s.isGitLabRemote())
+		})
+	}
+}
+
 func Test_isGitHubAppPermissionDenied(t *testing.T) {
 	tests := []struct {
 		name   string
@@ -139,12 +184,12 @@ func Test_isGitHubAppPermissionDenied(t *testing.T) {
 	}
 }
 
-func Test_parseGitStatus(t *testing.T) {
+func Test_parseGitStatusEntries(t *testing.T) {
 	tests := []struct {
 		name             string
 		output           string
 		includeUntracked bool
-		want             []string
+		want             []FileStatus
 	}{
 		{
 			name:             "empty output means no changes",
@@ -154,63 +199,163 @@ func Test_parseGitStatus(t *testing.T) {
 		},
 		{
 			name:             "whitespace-only output means no changes",
-			output:           "   \n  ",
+			output:           "   \x00",
 			includeUntracked: true,
 			want:             nil,
 		},
 		{
 			name:             "modified tracked file",
-			output:           " M main.go",
+			output:           " M main.go\x00",
 			includeUntracked: true,
-			want:             []string{"main.go"},
+			want:             []FileStatus{{Path: "main.go", Status: FileModified}},
 		},
 		{
 			name:             "staged modification",
-			output:           "M  main.go",
+			output:           "M  main.go\x00",
 			includeUntracked: true,
-			want:             []string{"main.go"},
+			want:             []FileStatus{{Path: "main.go", Status: FileModified}},
 		},
 		{
 			name:             "untracked new file included",
-			output:           "?? newfile.go",
+			output:           "?? newfile.go\x00",
 			includeUntracked: true,
-			want:             []string{"newfile.go"},
+			want:             []FileStatus{{Path: "newfile.go", Status: FileUntracked}},
 		},
 		{
 			name:             "untracked new file excluded",
-			output:           "?? newfile.go",
+			output:           "?? newfile.go\x00",
 			includeUntracked: false,
 			want:             nil,
 		},
 		{
 			name:             "mix of tracked changes and untracked files, all included",
-			output:           " M existing.go\n?? generated.go\nA  staged-new.go",
+			output:           " M existing.go\x00?? generated.go\x00A  staged-new.go\x00",
 			includeUntracked: true,
-			want:             []string{"existing.go", "generated.go", "staged-new.go"},
+			want: []FileStatus{
+				{Path: "existing.go", Status: FileModified},
+				{Path: "generated.go", Status: FileUntracked},
+				{Path: "staged-new.go", Status: FileAdded},
+			},
 		},
 		{
 			name:             "mix of tracked changes and untracked files, untracked excluded",
-			output:           " M existing.go\n?? generated.go\nA  staged-new.go",
+			output:           " M existing.go\x00?? generated.go\x00A  staged-new.go\x00",
 			includeUntracked: false,
-			want:             []string{"existing.go", "staged-new.go"},
+			want: []FileStatus{
+				{Path: "existing.go", Status: FileModified},
+				{Path: "staged-new.go", Status: FileAdded},
+			},
 		},
 		{
 			name:             "deleted file",
-			output:           " D removed.go",
+			output:           " D removed.go\x00",
 			includeUntracked: true,
-			want:             []string{"removed.go"},
+			want:             []FileStatus{{Path: "removed.go", Status: FileDeleted}},
 		},
 		{
 			name:             "file with spaces in name",
-			output:           " M my file.go",
+			output:           " M my file.go\x00",
+			includeUntracked: true,
+			want:             []FileStatus{{Path: "my file.go", Status: FileModified}},
+		},
+		{
+			name:             "non-ASCII file name is not quoted",
+			output:           "?? Résumé.md\x00",
 			includeUntracked: true,
-			want:             []string{"my file.go"},
+			want:             []FileStatus{{Path: "Résumé.md", Status: FileUntracked}},
+		},
+		{
+			name:             "rename with spaces in both names",
+			output:           "R  new name.go\x00old name.go\x00 M other.go\x00",
+			includeUntracked: true,
+			want: []FileStatus{
+				{Path: "new name.go", OldPath: "old name.go", Status: FileRenamed},
+				{Path: "other.go", Status: FileModified},
+			},
+		},
+		{
+			name:             "rename source that looks like a status entry",
+			output:           "R  b.go\x00?? a.go\x00",
+			includeUntracked: false,
+			want:             []FileStatus{{Path: "b.go", OldPath: "?? a.go", Status: FileRenamed}},
 		},
 	}
 
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			assert.Equal(t, tt.want, parseGitStatus(tt.output, tt.includeUntracked))
+			assert.Equal(t, tt.want, parseGitStatusEntries(tt.output, tt.includeUntracked))
 		})
 	}
 }
+
+func Test_parseGitStatusEntries_statusKinds(t *testing.T) {
+	output := " M modified.go\x00A  added.go\x00 D deleted.go\x00MD staged-then-deleted.go\x00R  new.go\x00old.go\x00?? untracked.go\x00"
+
+	want := []FileStatus{
+		{Path: "modified.go", Status: FileModified},
+		{Path: "added.go", Status: FileAdded},
+		{Path: "deleted.go", Status: FileDeleted},
+		{Path: "staged-then-deleted.go", Status: FileDeleted},
+		{Path: "new.go", OldPath: "old.go", Status: FileRenamed},
+		{Path: "untracked.go", Status: FileUntracked},
+	}
+	assert.Equal(t, want, parseGitStatusEntries(output, true))
+	assert.Equal(t, "old.go -> new.go", want[4].String())
+}
+
+func Test_revertChanges_literalPathspecs(t *testing.T) {
+	factory := &fakeCommandFactory{}
+	s := Step{commandFactory: factory, logger: log.NewLogger(), envRepo: fakeEnvRepo{}}
+
+	err := s.revertChanges([]FileStatus{
+		{Path: "pages/[id].tsx", Status: FileUntracked},
+		{Path: "src/*.go", Status: FileModified},
+	})
+	require.NoError(t, err)
+
+	require.Len(t, factory.calls, 3)
+	for _, call := range factory.calls {
+		assert.Equal(t, "--literal-pathspecs", call.args[0], call.args)
+	}
+	assert.Equal(t, []string{"--literal-pathspecs", "clean", "-f", "-q", "--", "pages/[id].tsx"}, factory.calls[2].args)
+}
+
+func Test_gitCommit_paths(t *testing.T) {
+	factory := &fakeCommandFactory{}
+	s := Step{commandFactory: factory, logger: log.NewLogger(), envRepo: fakeEnvRepo{}}
+
+	require.NoError(t, s.gitCommit("style: swiftformat", "App/View.swift", "App/*.swift"))
+
+	call, ok := factory.findCall("commit")
+	require.True(t, ok)
+	assert.Equal(t, "--literal-pathspecs", call.args[0])
+	assert.Contains(t, call.args, "--pathspec-from-file=-")
+	assert.Contains(t, call.args, "--pathspec-file-nul")
+	require.NotNil(t, call.opts)
+	stdin, err := io.ReadAll(call.opts.Stdin)
+	require.NoError(t, err)
+	assert.Equal(t, "App/View.swift\x00App/*.swift", string(stdin))
+}
+
+// gitTestRepo creates a repository with the given files committed, and
+// returns a Step that works in it.
+func gitTestRepo(t *testing.T, files map[string]string) (Step, string) {
+	t.Helper()
+	dir := t.TempDir()
+	s := Step{commandFactory: command.NewFactory(env.NewRepository()), logger: log.NewLogger(), envRepo: fakeEnvRepo{}}
+	git := func(args ...string) string {
+		out, err := s.commandFactory.Create("git", args, &command.Opts{Dir: dir}).RunAndReturnTrimmedCombinedOutput()
+		require.NoError(t, err, out)
+		return out
+	}
+	git("init", "-q")
+	for name, content := range files {
+		require.NoError(t, os.WriteFile(filepath.Join(dir, name), []byte(content), 0644))
+	}
+	git("add", ".")
+	git("-c", "user.name=Test", "-c", "user.email=test@test.com", "commit", "-q", "-m", "init")
+
+	s.repoDir = dir
+	s.commandFactory = dirCommandFactory{Factory: s.commandFactory, dir: dir}
+	return s, git("rev-parse", "HEAD")
+}
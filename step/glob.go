@@ -0,0 +1,64 @@
+package step
+
+import (
+	"path"
+	"strings"
+)
+
+// matchGlob reports whether the slash separated path matches the pattern.
+// Patterns follow .gitignore conventions:
+//   - a pattern without a slash matches the file name at any depth ("*.pbxproj")
+//   - a pattern ending with a slash matches everything below a directory ("Pods/")
+//   - "**" matches zero or more directories ("src/**/generated/*.swift")
+//   - everything else is matched segment by segment with path.Match
+func matchGlob(pattern, name string) bool {
+	pattern = strings.TrimPrefix(pattern, "/")
+	if pattern == "" {
+		return false
+	}
+	if strings.HasSuffix(pattern, "/") {
+		pattern += "**"
+	}
+	if !strings.Contains(pattern, "/") {
+		pattern = "**/" + pattern
+	}
+	return matchSegments(strings.Split(pattern, "/"), strings.Split(name, "/"))
+}
+
+func matchSegments(pattern, name []string) bool {
+	for len(pattern) > 0 {
+		if pattern[0] == "**" {
+			// Collapse repeated "**" and try every possible number of skipped directories.
+			for len(pattern) > 0 && pattern[0] == "**" {
+				pattern = pattern[1:]
+			}
+			if len(pattern) == 0 {
+				return true
+			}
+			for i := range name {
+				if matchSegments(pattern, name[i:]) {
+					return true
+				}
+			}
+			return false
+		}
+		if len(name) == 0 {
+			return false
+		}
+		if ok, err := path.Match(pattern[0], name[0]); err != nil || !ok {
+			return false
+		}
+		pattern, name = pattern[1:], name[1:]
+	}
+	return len(name) == 0
+}
+
+// matchAnyGlob returns the first pattern that matches the path, if any.
+func matchAnyGlob(patterns []string, name string) (string, bool) {
+	for _, p := range patterns {
+		if matchGlob(p, name) {
+			return p, true
+		}
+	}
+	return "", false
+}
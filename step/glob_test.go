@@ -0,0 +1,37 @@
+package step
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_matchGlob(t *testing.T) {
+	tests := []struct {
+		pattern string
+		name    string
+		want    bool
+	}{
+		{pattern: "*.log", name: "debug.log", want: true},
+		{pattern: "*.log", name: "build/logs/debug.log", want: true},
+		{pattern: "*.log", name: "debug.log.txt", want: false},
+		{pattern: "/README.md", name: "README.md", want: true},
+		{pattern: "docs/*.md", name: "docs/intro.md", want: true},
+		{pattern: "docs/*.md", name: "docs/guides/intro.md", want: false},
+		{pattern: "docs/*.md", name: "sub/docs/intro.md", want: false},
+		{pattern: "Pods/", name: "Pods/Alamofire/Source.swift", want: true},
+		{pattern: "Pods/", name: "MyPods/file.swift", want: false},
+		{pattern: "src/**/*.swift", name: "src/App.swift", want: true},
+		{pattern: "src/**/*.swift", name: "src/a/b/App.swift", want: true},
+		{pattern: "src/**/*.swift", name: "lib/src/App.swift", want: false},
+		{pattern: "**/generated/**", name: "app/generated/api/Client.kt", want: true},
+		{pattern: "**/generated/**", name: "app/generators/x.kt", want: false},
+		{pattern: "[", name: "[", want: false},
+		{pattern: "", name: "main.go", want: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.pattern+" "+tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, matchGlob(tt.pattern, tt.name))
+		})
+	}
+}
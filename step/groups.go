@@ -0,0 +1,133 @@
+package step
+
+import (
+	"fmt"
+	"strings"
+)
+
+// CommitGroup puts the changed files it matches into a commit of their own,
+// e.g. to keep generated code apart from formatting fixes. A file matches when
+// its path matches one of Paths, or when one of Tools changed it.
+type CommitGroup struct {
+	Name  string   `yaml:"name"`
+	Paths []string `yaml:"paths"`
+	Tools []string `yaml:"tools"`
+	// Subject defaults to the commit subject followed by the group name.
+	Subject string `yaml:"subject"`
+}
+
+// AutofixCommit is one of the commits of a grouped autofix.
+type AutofixCommit struct {
+	// Group is empty for the commit of the files no group matched.
+	Group     string   `json:"group,omitempty"`
+	Subject   string   `json:"subject"`
+	CommitSHA string   `json:"commit_sha,omitempty"`
+	Files     []string `json:"files"`
+}
+
+func validateCommitGroups(groups []CommitGroup) error {
+	seen := map[string]bool{}
+	for i, g := range groups {
+		if strings.TrimSpace(g.Name) == "" {
+			return fmt.Errorf("commit.groups[%d]: name is required", i)
+		}
+		if seen[g.Name] {
+			return fmt.Errorf("commit.groups: %s is defined more than once", g.Name)
+		}
+		seen[g.Name] = true
+		if len(g.Paths) == 0 && len(g.Tools) == 0 {
+			return fmt.Errorf("commit.groups: %s: set paths, tools or both", g.Name)
+		}
+	}
+	return nil
+}
+
+func (g CommitGroup) matches(f FileStatus) bool {
+	if _, ok := matchAnyGlob(g.Paths, f.Path); ok {
+		return true
+	}
+	if f.OldPath != "" {
+		if _, ok := matchAnyGlob(g.Paths, f.OldPath); ok {
+			return true
+		}
+	}
+	for _, tool := range f.Tools {
+		for _, t := range g.Tools {
+			if tool == t {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func (g CommitGroup) subject(defaultSubject string) string {
+	if strings.TrimSpace(g.Subject) != "" {
+		return g.Subject
+	}
+	return fmt.Sprintf("%s (%s)", defaultSubject, g.Name)
+}
+
+// fileGroup is the files of one commit, group is nil for the files no group matched.
+type fileGroup struct {
+	group *CommitGroup
+	files []FileStatus
+}
+
+// groupFiles assigns every file to the first group that matches it, the rest
+// go into a last, ungrouped commit. Groups without files are left out. Without
+// groups everything goes into a single commit.
+func groupFiles(files []FileStatus, groups []CommitGroup) []fileGroup {
+	byGroup := make([][]FileStatus, len(groups))
+	var rest []FileStatus
+	for _, f := range files {
+		matched := false
+		for i, g := range groups {
+			if g.matches(f) {
+				byGroup[i] = append(byGroup[i], f)
+				matched = true
+				break
+			}
+		}
+		if !matched {
+			rest = append(rest, f)
+		}
+	}
+
+	var result []fileGroup
+	for i := range groups {
+		if len(byGroup[i]) > 0 {
+			result = append(result, fileGroup{group: &groups[i], files: byGroup[i]})
+		}
+	}
+	if len(rest) > 0 || len(result) == 0 {
+		result = append(result, fileGroup{files: rest})
+	}
+	return result
+}
+
+func (g fileGroup) name() string {
+	if g.group == nil {
+		return ""
+	}
+	return g.group.Name
+}
+
+func (g fileGroup) subject(defaultSubject string) string {
+	if g.group == nil {
+		return defaultSubject
+	}
+	return g.group.subject(defaultSubject)
+}
+
+// paths lists the paths to commit, both sides of renames.
+func (g fileGroup) paths() []string {
+	var paths []string
+	for _, f := range g.files {
+		paths = append(paths, f.Path)
+		if f.OldPath != "" {
+			paths = append(paths, f.OldPath)
+		}
+	}
+	return paths
+}
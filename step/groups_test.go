@@ -0,0 +1,60 @@
+package step
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_groupFiles(t *testing.T) {
+	groups := []CommitGroup{
+		{Name: "SwiftFormat", Paths: []string{"**/*.swift"}, Subject: "style: swiftformat"},
+		{Name: "codegen", Paths: []string{"Generated/**"}, Tools: []string{"sourcery"}},
+	}
+	files := []FileStatus{
+		{Path: "App/View.swift", Status: FileModified},
+		{Path: "Generated/API.swift", Status: FileModified},
+		{Path: "Sources/Mocks.generated.txt", Status: FileAdded, Tools: []string{"sourcery"}},
+		{Path: "Generated/New.json", OldPath: "Old.json", Status: FileRenamed},
+		{Path: "README.md", Status: FileModified},
+	}
+
+	got := groupFiles(files, groups)
+	require.Len(t, got, 3)
+
+	assert.Equal(t, "SwiftFormat", got[0].name())
+	assert.Equal(t, "style: swiftformat", got[0].subject("Autofix"))
+	// The first matching group wins.
+	assert.Equal(t, []FileStatus{files[0], files[1]}, got[0].files)
+
+	assert.Equal(t, "codegen", got[1].name())
+	assert.Equal(t, "Autofix (codegen)", got[1].subject("Autofix"))
+	assert.Equal(t, []FileStatus{files[2], files[3]}, got[1].files)
+	assert.Equal(t, []string{"Sources/Mocks.generated.txt", "Generated/New.json", "Old.json"}, got[1].paths())
+
+	assert.Equal(t, "", got[2].name())
+	assert.Equal(t, "Autofix", got[2].subject("Autofix"))
+	assert.Equal(t, []FileStatus{files[4]}, got[2].files)
+}
+
+func Test_groupFiles_withoutUngroupedFiles(t *testing.T) {
+	groups := []CommitGroup{
+		{Name: "codegen", Paths: []string{"Generated/**"}},
+		{Name: "docs", Paths: []string{"*.md"}},
+	}
+	files := []FileStatus{{Path: "Generated/API.swift", Status: FileModified}}
+
+	got := groupFiles(files, groups)
+	require.Len(t, got, 1)
+	assert.Equal(t, "codegen", got[0].name())
+}
+
+func Test_groupFiles_withoutGroups(t *testing.T) {
+	files := []FileStatus{{Path: "a.swift", Status: FileModified}, {Path: "b.swift", Status: FileModified}}
+
+	got := groupFiles(files, nil)
+	require.Len(t, got, 1)
+	assert.Nil(t, got[0].group)
+	assert.Equal(t, files, got[0].files)
+}
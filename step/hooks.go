@@ -0,0 +1,204 @@
+package step
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/bitrise-io/go-utils/v2/command"
+)
+
+const fileListFileName = "autofix-files.txt"
+
+// Hook names, also passed to the hooks as AUTOFIX_HOOK.
+const (
+	hookPreCommit = "pre_commit"
+	hookPrePush   = "pre_push"
+	hookPostPush  = "post_push"
+)
+
+// runHook runs a user hook with the pending autofix described in env vars.
+// An empty command is a no-op.
+func (s Step) runHook(name, script string, result Result) error {
+	if strings.TrimSpace(script) == "" {
+		return nil
+	}
+
+	fileListPath, err := s.writeFileList(result.Files)
+	if err != nil {
+		return err
+	}
+	env := []string{
+		"AUTOFIX_HOOK=" + name,
+		"AUTOFIX_FILE_LIST_PATH=" + fileListPath,
+		"AUTOFIX_PATCH_PATH=" + result.PatchPath,
+		"AUTOFIX_BRANCH=" + result.Branch,
+		"AUTOFIX_HEAD_SHA=" + result.HeadSHA,
+		"AUTOFIX_COMMIT_SHA=" + result.CommitSHA,
+	}
+
+	s.logger.Println()
+	s.logger.Infof("Running %s hook", name)
+	cmd := s.commandFactory.Create("sh", []string{"-c", script}, &command.Opts{Stdout: os.Stdout, Stderr: os.Stderr, Env: env})
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("%s hook failed: %w", name, err)
+	}
+	return nil
+}
+
+// writeFileList writes the changed paths one per line, renames as their new path.
+func (s Step) writeFileList(files []FileStatus) (string, error) {
+	dir, err := s.outputDir()
+	if err != nil {
+		return "", err
+	}
+	var sb strings.Builder
+	for _, f := range files {
+		sb.WriteString(f.Path)
+		sb.WriteString("\n")
+	}
+	path := filepath.Join(dir, fileListFileName)
+	if err := os.WriteFile(path, []byte(sb.String()), 0644); err != nil {
+		return "", fmt.Errorf("write file list: %w", err)
+	}
+	return path, nil
+}
+
+// gitWriteTree writes the index as a tree and returns its hash, so what a hook
+// stages afterwards can be told apart from the approved changes.
+func (s Step) gitWriteTree() (string, error) {
+	out, err := s.commandFactory.Create("git", []string{"write-tree"}, nil).RunAndReturnTrimmedCombinedOutput()
+	if err != nil {
+		return "", fmt.Errorf("%w\n%s", err, out)
+	}
+	return out, nil
+}
+
+// hookChanges returns what is staged on top of tree, the index before the hook ran.
+func (s Step) hookChanges(tree string) ([]FileStatus, error) {
+	args := []string{"-c", "core.quotePath=false", "diff", "--cached", "--no-color", "--no-ext-diff", "--no-textconv", "--no-renames", tree}
+	var outBuf bytes.Buffer
+	if err := s.commandFactory.Create("git", args, &command.Opts{Stdout: &outBuf}).Run(); err != nil {
+		return nil, fmt.Errorf("diff the changes of the hook: %w", err)
+	}
+	var files []FileStatus
+	for _, d := range parseUnifiedDiff(outBuf.String()) {
+		f := FileStatus{Path: d.Path, Status: FileModified, Added: d.Added, Removed: d.Removed, Binary: d.Binary, Tools: []string{hookPreCommit}}
+		switch {
+		case d.New:
+			f.Status = FileAdded
+		case d.Deleted:
+			f.Status = FileDeleted
+		}
+		files = append(files, f)
+	}
+	return files, nil
+}
+
+// checkHookChanges puts what the pre-commit hook changed through the same
+// checks as the detected changes, so the hook can't get anything into the
+// commit that they would have stopped. The changes are added to result.Files.
+func (s Step) checkHookChanges(tree string, input Input, policy Policy, result *Result) error {
+	changes, err := s.hookChanges(tree)
+	if err != nil {
+		return err
+	}
+	if len(changes) == 0 {
+		return nil
+	}
+	paths := filePaths(changes)
+	s.logger.Println()
+	s.logger.Infof("The %s hook changed %d file(s):", hookPreCommit, len(paths))
+	for _, p := range paths {
+		s.logger.Printf("  %s", p)
+	}
+
+	if err := checkForCIConfigChanges(paths); err != nil {
+		result.Outcome = OutcomeSecurityBlocked
+		return fmt.Errorf("security check failed: %w", err)
+	}
+	if err := checkForProtectedPaths(paths, policy.ProtectedPaths); err != nil {
+		result.Outcome = OutcomeSecurityBlocked
+		return fmt.Errorf("security check failed: %w", err)
+	}
+	// It's too late to leave anything out of the commit, so what would have been excluded fails the run.
+	if _, excluded := filterChanges(changes, policy); len(excluded) > 0 {
+		result.Outcome = OutcomeHookFailed
+		return fmt.Errorf("the %s hook changed %s (%s)", hookPreCommit, excluded[0].Path, excluded[0].Reason)
+	}
+	if _, notAllowed := applyDeletionPolicy(changes, input.Deletions, firstNonEmptyList(input.DeletionAllowlist)); len(notAllowed) > 0 {
+		result.Outcome = OutcomeDeletionBlocked
+		return fmt.Errorf("the %s hook deleted %s, which is not allowed (deletions: %s)", hookPreCommit, notAllowed[0].Path, input.Deletions)
+	}
+
+	result.Files = mergeHookChanges(result.Files, changes)
+	sizes, err := s.fileSizes(result.Files)
+	if err != nil {
+		return fmt.Errorf("check change limits: %w", err)
+	}
+	result.LimitViolations = checkLimits(result.Files, sizes, changeLimits{
+		MaxFiles:        input.MaxFiles,
+		MaxChangedLines: input.MaxChangedLines,
+		MaxFileSizeKB:   input.MaxFileSizeKB,
+		AllowBinary:     input.AllowBinaryFiles,
+	})
+	if len(result.LimitViolations) > 0 {
+		for _, v := range result.LimitViolations {
+			s.logger.Errorf("  %s", v)
+		}
+		result.Outcome = OutcomeLimitExceeded
+		return fmt.Errorf("with the changes of the %s hook the changes exceed %d limit(s), nothing was committed", hookPreCommit, len(result.LimitViolations))
+	}
+
+	lfsFiles, err := s.lfsTrackedPaths(changes)
+	if err != nil {
+		return err
+	}
+	if len(lfsFiles) > 0 && len(result.LFSFiles) == 0 {
+		if err := s.setupGitLFS(); err != nil {
+			return fmt.Errorf("changed files are tracked by Git LFS (%s): %w", strings.Join(lfsFiles, ", "), err)
+		}
+	}
+	for _, p := range lfsFiles {
+		result.LFSFiles = appendUnique(result.LFSFiles, p)
+	}
+
+	if input.ValidateSyntax {
+		result.InvalidFiles = s.checkSyntax(changes, result.LFSFiles)
+		if len(result.InvalidFiles) > 0 {
+			for _, f := range result.InvalidFiles {
+				s.logger.Errorf("  %s", f)
+			}
+			result.Outcome = OutcomeInvalidFiles
+			return fmt.Errorf("the %s hook left %d file(s) that no longer parse or changed encoding, nothing was committed", hookPreCommit, len(result.InvalidFiles))
+		}
+	}
+	return nil
+}
+
+// mergeHookChanges adds the changes of the hook to the detected ones. A file
+// both changed gets the line counts of both diffs.
+func mergeHookChanges(files, changes []FileStatus) []FileStatus {
+	merged := append([]FileStatus{}, files...)
+	index := map[string]int{}
+	for i, f := range merged {
+		index[f.Path] = i
+	}
+	for _, c := range changes {
+		i, ok := index[c.Path]
+		if !ok {
+			merged = append(merged, c)
+			continue
+		}
+		merged[i].Added += c.Added
+		merged[i].Removed += c.Removed
+		merged[i].Binary = merged[i].Binary || c.Binary
+		merged[i].Tools = appendUnique(merged[i].Tools, hookPreCommit)
+		if c.Status == FileDeleted {
+			merged[i].Status = FileDeleted
+		}
+	}
+	return merged
+}
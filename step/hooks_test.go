@@ -0,0 +1,73 @@
+package step
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/bitrise-io/go-utils/v2/log"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_runHook(t *testing.T) {
+	factory := &fakeCommandFactory{}
+	deployDir := t.TempDir()
+	s := Step{commandFactory: factory, logger: log.NewLogger(), envRepo: fakeEnvRepo{"BITRISE_DEPLOY_DIR": deployDir}}
+	result := Result{
+		Branch:    "feature",
+		HeadSHA:   "abc",
+		CommitSHA: "def",
+		PatchPath: "/tmp/autofix.patch",
+		Files: []FileStatus{
+			{Path: "a.go", Status: FileModified},
+			{Path: "new.go", OldPath: "old.go", Status: FileRenamed},
+		},
+	}
+
+	err := s.runHook(hookPrePush, "./notify.sh", result)
+
+	require.NoError(t, err)
+	require.Len(t, factory.calls, 1)
+	call := factory.calls[0]
+	assert.Equal(t, []string{"-c", "./notify.sh"}, call.args)
+	assert.Contains(t, call.opts.Env, "AUTOFIX_HOOK=pre_push")
+	assert.Contains(t, call.opts.Env, "AUTOFIX_BRANCH=feature")
+	assert.Contains(t, call.opts.Env, "AUTOFIX_HEAD_SHA=abc")
+	assert.Contains(t, call.opts.Env, "AUTOFIX_COMMIT_SHA=def")
+	assert.Contains(t, call.opts.Env, "AUTOFIX_PATCH_PATH=/tmp/autofix.patch")
+
+	fileListPath := filepath.Join(deployDir, fileListFileName)
+	assert.Contains(t, call.opts.Env, "AUTOFIX_FILE_LIST_PATH="+fileListPath)
+	fileList, err := os.ReadFile(fileListPath)
+	require.NoError(t, err)
+	assert.Equal(t, "a.go\nnew.go\n", string(fileList))
+}
+
+func Test_runHook_Empty(t *testing.T) {
+	factory := &fakeCommandFactory{}
+	s := Step{commandFactory: factory, logger: log.NewLogger(), envRepo: fakeEnvRepo{}}
+
+	require.NoError(t, s.runHook(hookPostPush, "  ", Result{}))
+	assert.Empty(t, factory.calls)
+}
+
+func Test_mergeHookChanges(t *testing.T) {
+	files := []FileStatus{
+		{Path: "a.go", Status: FileModified, Added: 2, Removed: 1, Tools: []string{"gofmt"}},
+		{Path: "b.go", Status: FileModified, Added: 1},
+	}
+	changes := []FileStatus{
+		{Path: "a.go", Status: FileModified, Added: 1, Tools: []string{hookPreCommit}},
+		{Path: "b.go", Status: FileDeleted, Removed: 3, Tools: []string{hookPreCommit}},
+		{Path: "CHANGELOG.md", Status: FileAdded, Added: 5, Tools: []string{hookPreCommit}},
+	}
+
+	assert.Equal(t, []FileStatus{
+		{Path: "a.go", Status: FileModified, Added: 3, Removed: 1, Tools: []string{"gofmt", hookPreCommit}},
+		{Path: "b.go", Status: FileDeleted, Added: 1, Removed: 3, Tools: []string{hookPreCommit}},
+		{Path: "CHANGELOG.md", Status: FileAdded, Added: 5, Tools: []string{hookPreCommit}},
+	}, mergeHookChanges(files, changes))
+	assert.Equal(t, "gofmt", files[0].Tools[0], "the detected changes must not be modified")
+	assert.Len(t, files[0].Tools, 1)
+}
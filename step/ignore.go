@@ -0,0 +1,86 @@
+package step
+
+import (
+	"fmt"
+	"regexp"
+)
+
+// IgnoreHunkMode decides what happens to files that have both ignored and other changes.
+type IgnoreHunkMode string
+
+const (
+	// IgnoreHunksFile reverts only files whose every changed line is ignored.
+	IgnoreHunksFile IgnoreHunkMode = "file"
+	// IgnoreHunksHunk also reverts the ignored hunks of files with other changes.
+	IgnoreHunksHunk IgnoreHunkMode = "hunk"
+)
+
+const ignoredHunkReason = "matching ignore_hunk_patterns"
+
+func compileHunkPatterns(patterns []string) ([]*regexp.Regexp, error) {
+	var res []*regexp.Regexp
+	for _, p := range patterns {
+		re, err := regexp.Compile(p)
+		if err != nil {
+			return nil, fmt.Errorf("invalid ignore_hunk_patterns entry %q: %w", p, err)
+		}
+		res = append(res, re)
+	}
+	return res, nil
+}
+
+// hunkIgnored reports whether every added and removed line of the hunk matches one of the patterns.
+func hunkIgnored(h Hunk, patterns []*regexp.Regexp) bool {
+	changed := false
+	for _, l := range h.Lines {
+		if l == "" || (l[0] != '+' && l[0] != '-') {
+			continue
+		}
+		changed = true
+		if !matchAnyRegexp(patterns, l[1:]) {
+			return false
+		}
+	}
+	return changed
+}
+
+func matchAnyRegexp(patterns []*regexp.Regexp, s string) bool {
+	for _, re := range patterns {
+		if re.MatchString(s) {
+			return true
+		}
+	}
+	return false
+}
+
+// splitIgnoredHunks returns the hunks to keep and the ignored ones to revert.
+// In file mode nothing is reverted unless every hunk is ignored.
+func splitIgnoredHunks(hunks []Hunk, patterns []*regexp.Regexp, mode IgnoreHunkMode) (keep, revert []Hunk) {
+	for _, h := range hunks {
+		if hunkIgnored(h, patterns) {
+			revert = append(revert, h)
+		} else {
+			keep = append(keep, h)
+		}
+	}
+	if mode == IgnoreHunksFile && len(keep) > 0 {
+		return hunks, nil
+	}
+	return keep, revert
+}
+
+// dropIgnoredHunks reverts the changes of modified files that only match the
+// ignore patterns, in the working tree. The returned func restores the
+// original content of the touched files.
+func (s Step) dropIgnoredHunks(changes []FileStatus, patterns []*regexp.Regexp, mode IgnoreHunkMode) ([]FileStatus, []ExcludedFile, func() error, error) {
+	var candidates []string
+	for _, f := range changes {
+		// New files are generated as a whole, there is no earlier content to keep.
+		if f.Status == FileModified && !f.Submodule {
+			candidates = append(candidates, f.Path)
+		}
+	}
+	return s.revertHunks(changes, candidates, func(d FileDiff) (keep, revert []Hunk) {
+		return splitIgnoredHunks(d.Hunks, patterns, mode)
+	}, ignoredHunkReason)
+}
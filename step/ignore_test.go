@@ -0,0 +1,43 @@
+package step
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_splitIgnoredHunks(t *testing.T) {
+	patterns, err := compileHunkPatterns([]string{`^// Generated at `, `^// protoc v\d+`})
+	require.NoError(t, err)
+
+	stamp := Hunk{OldStart: 1, Lines: []string{"-// Generated at 2026-01-01", "+// Generated at 2026-02-01"}}
+	version := Hunk{OldStart: 2, Lines: []string{"-// protoc v3", "+// protoc v4", `\ No newline at end of file`}}
+	code := Hunk{OldStart: 10, Lines: []string{"-x := 1", "+x := 2"}}
+	mixed := Hunk{OldStart: 20, Lines: []string{"-// Generated at 2026-01-01", "+x := 3"}}
+
+	tests := []struct {
+		name       string
+		hunks      []Hunk
+		mode       IgnoreHunkMode
+		wantKeep   []Hunk
+		wantRevert []Hunk
+	}{
+		{name: "only ignored changes", hunks: []Hunk{stamp, version}, mode: IgnoreHunksFile, wantRevert: []Hunk{stamp, version}},
+		{name: "file mode keeps mixed files", hunks: []Hunk{stamp, code}, mode: IgnoreHunksFile, wantKeep: []Hunk{stamp, code}},
+		{name: "hunk mode reverts the ignored hunks", hunks: []Hunk{stamp, code}, mode: IgnoreHunksHunk, wantKeep: []Hunk{code}, wantRevert: []Hunk{stamp}},
+		{name: "a hunk with other changes is kept", hunks: []Hunk{mixed}, mode: IgnoreHunksHunk, wantKeep: []Hunk{mixed}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			keep, revert := splitIgnoredHunks(tt.hunks, patterns, tt.mode)
+			assert.Equal(t, tt.wantKeep, keep)
+			assert.Equal(t, tt.wantRevert, revert)
+		})
+	}
+}
+
+func Test_compileHunkPatterns_invalid(t *testing.T) {
+	_, err := compileHunkPatterns([]string{"version: (\\d+"})
+	assert.ErrorContains(t, err, "ignore_hunk_patterns")
+}
@@ -0,0 +1,133 @@
+package step
+
+import (
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+const (
+	junitFileName = "autofix-junit.xml"
+	// junitTestName is how the test run shows up in the Bitrise Test Reports UI.
+	junitTestName = "Autofix CI"
+)
+
+type junitTestSuites struct {
+	XMLName  xml.Name         `xml:"testsuites"`
+	Name     string           `xml:"name,attr"`
+	Tests    int              `xml:"tests,attr"`
+	Failures int              `xml:"failures,attr"`
+	Suites   []junitTestSuite `xml:"testsuite"`
+}
+
+type junitTestSuite struct {
+	Name      string          `xml:"name,attr"`
+	Tests     int             `xml:"tests,attr"`
+	Failures  int             `xml:"failures,attr"`
+	TestCases []junitTestCase `xml:"testcase"`
+}
+
+type junitTestCase struct {
+	Name      string        `xml:"name,attr"`
+	ClassName string        `xml:"classname,attr"`
+	Failure   *junitFailure `xml:"failure,omitempty"`
+}
+
+type junitFailure struct {
+	Message string `xml:"message,attr"`
+	Type    string `xml:"type,attr"`
+	Body    string `xml:",chardata"`
+}
+
+// buildJUnitReport creates one failing test case per changed file, with the
+// file's diff as the failure body. The message explains what happened to the fix.
+func buildJUnitReport(result Result) ([]byte, error) {
+	suite := junitTestSuite{Name: "autofix"}
+	for _, d := range result.Diff {
+		message := fmt.Sprintf("%s needs autofix (+%d -%d)", d.Path, d.Added, d.Removed)
+		if d.Binary {
+			message = fmt.Sprintf("%s needs autofix (binary file)", d.Path)
+		}
+		suite.TestCases = append(suite.TestCases, junitTestCase{
+			Name:      d.Path,
+			ClassName: "autofix",
+			Failure: &junitFailure{
+				Message: fmt.Sprintf("%s: %s", message, junitOutcomeNote(result.Outcome)),
+				Type:    string(result.Outcome),
+				Body:    d.Patch(),
+			},
+		})
+	}
+	suite.Tests = len(suite.TestCases)
+	suite.Failures = len(suite.TestCases)
+
+	report := junitTestSuites{
+		Name:     junitTestName,
+		Tests:    suite.Tests,
+		Failures: suite.Failures,
+		Suites:   []junitTestSuite{suite},
+	}
+	data, err := xml.MarshalIndent(report, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	return append([]byte(xml.Header), data...), nil
+}
+
+func junitOutcomeNote(outcome Outcome) string {
+	switch outcome {
+	case OutcomePushed:
+		return "the fix was pushed to the PR branch, a new build will run on it"
+	case OutcomeDryRun:
+		return "dry run, the fix was not pushed"
+	case OutcomeSecurityBlocked:
+		return "the fix was not committed because the changes touch CI config"
+	case OutcomeCheckFailed:
+		return "check only mode, run the formatters locally and commit the result"
+	case OutcomeDeletionBlocked:
+		return "the fix was not committed because it deletes files"
+	case OutcomeSubmoduleBlocked:
+		return "the fix was not committed because it changes a submodule"
+	case OutcomeInvalidFiles:
+		return "the fix was not committed because changed files no longer parse or changed encoding"
+	case OutcomeLimitExceeded:
+		return "the fix was not committed because it exceeds the size limits"
+	case OutcomeValidationFailed:
+		return "the fix was not pushed because it failed validation, fix it locally"
+	default:
+		return "the fix could not be pushed, apply it locally"
+	}
+}
+
+// writeJUnitReport writes the report into the step's test result dir, where the
+// Deploy to Bitrise.io step collects it for the Test Reports UI.
+func (s Step) writeJUnitReport(result Result) (string, error) {
+	dir := s.envRepo.Get("BITRISE_TEST_RESULT_DIR")
+	if dir == "" {
+		return "", fmt.Errorf("BITRISE_TEST_RESULT_DIR is not set")
+	}
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return "", fmt.Errorf("create test result dir: %w", err)
+	}
+
+	data, err := buildJUnitReport(result)
+	if err != nil {
+		return "", fmt.Errorf("marshal JUnit report: %w", err)
+	}
+	path := filepath.Join(dir, junitFileName)
+	if err := os.WriteFile(path, data, 0644); err != nil {
+		return "", fmt.Errorf("write JUnit report: %w", err)
+	}
+
+	// Test results are only picked up from dirs that have a test_info.json.
+	info, err := json.Marshal(map[string]string{"test-name": junitTestName})
+	if err != nil {
+		return "", fmt.Errorf("marshal test info: %w", err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "test_info.json"), info, 0644); err != nil {
+		return "", fmt.Errorf("write test info: %w", err)
+	}
+	return path, nil
+}
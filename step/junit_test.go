@@ -0,0 +1,54 @@
+package step
+
+import (
+	"encoding/xml"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_buildJUnitReport(t *testing.T) {
+	diffs := parseUnifiedDiff(sampleDiff)[:2]
+
+	data, err := buildJUnitReport(Result{Outcome: OutcomeSecurityBlocked, Diff: diffs})
+	require.NoError(t, err)
+
+	var report junitTestSuites
+	require.NoError(t, xml.Unmarshal(data, &report))
+	assert.Equal(t, 2, report.Tests)
+	assert.Equal(t, 2, report.Failures)
+	require.Len(t, report.Suites, 1)
+	require.Len(t, report.Suites[0].TestCases, 2)
+
+	goCase := report.Suites[0].TestCases[0]
+	assert.Equal(t, "main.go", goCase.Name)
+	require.NotNil(t, goCase.Failure)
+	assert.Equal(t, "security_blocked", goCase.Failure.Type)
+	assert.Contains(t, goCase.Failure.Message, "main.go needs autofix (+3 -2)")
+	assert.Equal(t, diffs[0].Patch(), goCase.Failure.Body)
+
+	assert.Contains(t, report.Suites[0].TestCases[1].Failure.Message, "binary file")
+}
+
+func Test_writeJUnitReport(t *testing.T) {
+	dir := t.TempDir()
+	s := Step{envRepo: fakeEnvRepo{"BITRISE_TEST_RESULT_DIR": dir}}
+
+	path, err := s.writeJUnitReport(Result{Outcome: OutcomeDryRun, Diff: parseUnifiedDiff(sampleDiff)})
+	require.NoError(t, err)
+	assert.FileExists(t, path)
+
+	info, err := os.ReadFile(filepath.Join(dir, "test_info.json"))
+	require.NoError(t, err)
+	assert.JSONEq(t, `{"test-name": "Autofix CI"}`, string(info))
+}
+
+func Test_writeJUnitReport_NoResultDir(t *testing.T) {
+	s := Step{envRepo: fakeEnvRepo{}}
+
+	_, err := s.writeJUnitReport(Result{Outcome: OutcomeDryRun})
+	assert.Error(t, err)
+}
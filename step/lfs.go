@@ -0,0 +1,105 @@
+package step
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/bitrise-steplib/bitrise-step-autofix-ci/gitcredential"
+
+	"github.com/bitrise-io/go-utils/v2/command"
+)
+
+const lfsPointerPrefix = "version https://git-lfs.github.com/spec/v1\n"
+
+// errGitLFSMissing is returned when changed files are tracked by Git LFS, but git-lfs is not installed.
+var errGitLFSMissing = errors.New("git-lfs is not installed, install it before this step so the files are committed as LFS pointers")
+
+// lfsTrackedPaths returns the changed paths that .gitattributes marks as filter=lfs.
+func (s Step) lfsTrackedPaths(files []FileStatus) ([]string, error) {
+	var paths []string
+	for _, f := range files {
+		if f.Status != FileDeleted && !f.Submodule {
+			paths = append(paths, f.Path)
+		}
+	}
+	if len(paths) == 0 {
+		return nil, nil
+	}
+	var outBuf bytes.Buffer
+	args := append([]string{"check-attr", "-z", "filter", "--"}, paths...)
+	if err := s.commandFactory.Create("git", args, &command.Opts{Stdout: &outBuf}).Run(); err != nil {
+		return nil, fmt.Errorf("check the filter attribute: %w", err)
+	}
+	return parseCheckAttr(outBuf.String(), "lfs"), nil
+}
+
+// parseCheckAttr returns the paths with the given attribute value from
+// `git check-attr -z` output, which is a sequence of path, attribute, value triplets.
+func parseCheckAttr(output, value string) []string {
+	fields := strings.Split(output, "\x00")
+	var paths []string
+	for i := 0; i+2 < len(fields); i += 3 {
+		if fields[i+2] == value {
+			paths = append(paths, fields[i])
+		}
+	}
+	return paths
+}
+
+// setupGitLFS makes sure git-lfs is available and its filters are configured,
+// so git add stores LFS pointers instead of the file contents. The repository's
+// hooks are left alone.
+func (s Step) setupGitLFS() error {
+	if out, err := s.commandFactory.Create("git", []string{"lfs", "version"}, nil).RunAndReturnTrimmedCombinedOutput(); err != nil {
+		return fmt.Errorf("%w: %w\n%s", errGitLFSMissing, err, out)
+	}
+	s.logger.Debugf("$ git lfs install --local --skip-repo")
+	if out, err := s.commandFactory.Create("git", []string{"lfs", "install", "--local", "--skip-repo"}, nil).RunAndReturnTrimmedCombinedOutput(); err != nil {
+		return fmt.Errorf("configure the git-lfs filters: %w\n%s", err, out)
+	}
+	return nil
+}
+
+// checkLFSPointers fails if an LFS-tracked path is staged with its contents instead of an LFS pointer.
+func (s Step) checkLFSPointers(paths []string) error {
+	var raw []string
+	for _, path := range paths {
+		var outBuf bytes.Buffer
+		if err := s.commandFactory.Create("git", []string{"cat-file", "blob", ":" + path}, &command.Opts{Stdout: &outBuf}).Run(); err != nil {
+			// Not staged, e.g. reverted by a hook.
+			continue
+		}
+		if !strings.HasPrefix(outBuf.String(), lfsPointerPrefix) {
+			raw = append(raw, path)
+		}
+	}
+	if len(raw) > 0 {
+		return fmt.Errorf("files tracked by Git LFS were staged without the LFS filter, refusing to commit their contents:\n  %s", strings.Join(raw, "\n  "))
+	}
+	return nil
+}
+
+// gitLFSPush uploads the LFS objects of the branch, with the same credentials as git push.
+func (s Step) gitLFSPush(username, token, branch string) error {
+	var args []string
+	var opts *command.Opts
+	if token != "" {
+		helper, err := gitcredential.WriteHelper(username, token)
+		if err != nil {
+			return err
+		}
+		defer os.Remove(helper.Path)
+		args = []string{"-c", fmt.Sprintf("credential.helper=%s", helper.Path)}
+		opts = &command.Opts{Env: helper.Env}
+	}
+	args = append(args, "lfs", "push", "origin", branch)
+
+	s.logger.Debugf("$ git lfs push origin %s", branch)
+	if out, err := s.commandFactory.Create("git", args, opts).RunAndReturnTrimmedCombinedOutput(); err != nil {
+		return fmt.Errorf("%w\n%s", err, out)
+	}
+	return nil
+}
@@ -0,0 +1,14 @@
+package step
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_parseCheckAttr(t *testing.T) {
+	output := "model.bin\x00filter\x00lfs\x00main.go\x00filter\x00unspecified\x00assets/logo.png\x00filter\x00lfs\x00"
+
+	assert.Equal(t, []string{"model.bin", "assets/logo.png"}, parseCheckAttr(output, "lfs"))
+	assert.Empty(t, parseCheckAttr("", "lfs"))
+}
@@ -0,0 +1,104 @@
+package step
+
+import (
+	"fmt"
+	"os"
+)
+
+// LimitAction is what happens when the changes cross a size limit.
+type LimitAction string
+
+const (
+	// LimitActionFail fails the step without committing anything.
+	LimitActionFail LimitAction = "fail"
+	// LimitActionPatchOnly delivers the changes only as the patch artifact, and doesn't fail the step.
+	LimitActionPatchOnly LimitAction = "patch_only"
+)
+
+// Limit names, as they appear in the JSON report.
+const (
+	limitMaxFiles        = "max_files"
+	limitMaxChangedLines = "max_changed_lines"
+	limitMaxFileSize     = "max_file_size_kb"
+	limitBinaryFiles     = "allow_binary_files"
+)
+
+// changeLimits are the guardrails against pushing a runaway change. Zero means no limit.
+type changeLimits struct {
+	MaxFiles        int
+	MaxChangedLines int
+	MaxFileSizeKB   int
+	AllowBinary     bool
+}
+
+// LimitViolation is a change that crossed one of the limits. Path is empty
+// for the limits on the change as a whole.
+type LimitViolation struct {
+	Limit string `json:"limit"`
+	Path  string `json:"path,omitempty"`
+	Value int64  `json:"value"`
+	Max   int64  `json:"max"`
+}
+
+func (v LimitViolation) String() string {
+	switch v.Limit {
+	case limitMaxFiles:
+		return fmt.Sprintf("%d files changed, the limit is %d (max_files)", v.Value, v.Max)
+	case limitMaxChangedLines:
+		return fmt.Sprintf("%d lines added or removed, the limit is %d (max_changed_lines)", v.Value, v.Max)
+	case limitMaxFileSize:
+		return fmt.Sprintf("%s is %d KB, the limit is %d KB (max_file_size_kb)", v.Path, v.Value, v.Max)
+	case limitBinaryFiles:
+		return fmt.Sprintf("%s is a binary file (allow_binary_files)", v.Path)
+	default:
+		return v.Limit
+	}
+}
+
+// checkLimits returns every limit the changes cross. sizes are the sizes of
+// the changed files in bytes, deleted files have none.
+func checkLimits(files []FileStatus, sizes map[string]int64, limits changeLimits) []LimitViolation {
+	var violations []LimitViolation
+	if limits.MaxFiles > 0 && len(files) > limits.MaxFiles {
+		violations = append(violations, LimitViolation{Limit: limitMaxFiles, Value: int64(len(files)), Max: int64(limits.MaxFiles)})
+	}
+
+	changedLines := 0
+	for _, f := range files {
+		changedLines += f.Added + f.Removed
+	}
+	if limits.MaxChangedLines > 0 && changedLines > limits.MaxChangedLines {
+		violations = append(violations, LimitViolation{Limit: limitMaxChangedLines, Value: int64(changedLines), Max: int64(limits.MaxChangedLines)})
+	}
+
+	for _, f := range files {
+		if !limits.AllowBinary && f.Binary && f.Status != FileDeleted {
+			violations = append(violations, LimitViolation{Limit: limitBinaryFiles, Path: f.Path})
+		}
+		if size, ok := sizes[f.Path]; ok && limits.MaxFileSizeKB > 0 {
+			// Rounded up, so a file just over the limit doesn't show up as exactly at it.
+			if kb := (size + 1023) / 1024; kb > int64(limits.MaxFileSizeKB) {
+				violations = append(violations, LimitViolation{Limit: limitMaxFileSize, Path: f.Path, Value: kb, Max: int64(limits.MaxFileSizeKB)})
+			}
+		}
+	}
+	return violations
+}
+
+// fileSizes returns the size of every changed file that exists in the working tree.
+func (s Step) fileSizes(files []FileStatus) (map[string]int64, error) {
+	sizes := map[string]int64{}
+	for _, f := range files {
+		info, err := os.Lstat(s.repoFile(f.Path))
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		if info.Mode().IsRegular() {
+			sizes[f.Path] = info.Size()
+		}
+	}
+	return sizes, nil
+}
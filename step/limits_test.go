@@ -0,0 +1,62 @@
+package step
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_checkLimits(t *testing.T) {
+	files := []FileStatus{
+		{Path: "a.go", Status: FileModified, Added: 30, Removed: 10},
+		{Path: "b.go", Status: FileModified, Added: 5, Removed: 5},
+		{Path: "logo.png", Status: FileModified, Binary: true},
+		{Path: "old.png", Status: FileDeleted, Binary: true},
+	}
+	sizes := map[string]int64{"a.go": 2048, "b.go": 2049, "logo.png": 100}
+
+	tests := []struct {
+		name   string
+		limits changeLimits
+		want   []LimitViolation
+	}{
+		{
+			name:   "no limits",
+			limits: changeLimits{AllowBinary: true},
+		},
+		{
+			name:   "within the limits",
+			limits: changeLimits{MaxFiles: 4, MaxChangedLines: 50, MaxFileSizeKB: 3, AllowBinary: true},
+		},
+		{
+			name:   "too many files",
+			limits: changeLimits{MaxFiles: 3, AllowBinary: true},
+			want:   []LimitViolation{{Limit: limitMaxFiles, Value: 4, Max: 3}},
+		},
+		{
+			name:   "too many lines",
+			limits: changeLimits{MaxChangedLines: 49, AllowBinary: true},
+			want:   []LimitViolation{{Limit: limitMaxChangedLines, Value: 50, Max: 49}},
+		},
+		{
+			name:   "file too large",
+			limits: changeLimits{MaxFileSizeKB: 2, AllowBinary: true},
+			want:   []LimitViolation{{Limit: limitMaxFileSize, Path: "b.go", Value: 3, Max: 2}},
+		},
+		{
+			name:   "binary files not allowed, deleting them is fine",
+			limits: changeLimits{},
+			want:   []LimitViolation{{Limit: limitBinaryFiles, Path: "logo.png"}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, checkLimits(files, sizes, tt.limits))
+		})
+	}
+}
+
+func Test_LimitViolation_String(t *testing.T) {
+	assert.Equal(t, "1200 files changed, the limit is 100 (max_files)", LimitViolation{Limit: limitMaxFiles, Value: 1200, Max: 100}.String())
+	assert.Equal(t, "big.json is 2048 KB, the limit is 512 KB (max_file_size_kb)", LimitViolation{Limit: limitMaxFileSize, Path: "big.json", Value: 2048, Max: 512}.String())
+}
@@ -0,0 +1,117 @@
+package step
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// Reasons for excluding a file whose changes are only noise.
+const (
+	noiseFileMode      = "file mode change only"
+	noiseLineEndings   = "line ending change only"
+	noiseWhitespace    = "whitespace change only"
+	noiseByteOrderMark = "byte order mark change only"
+	noiseBOMWhitespace = "byte order mark and whitespace change only"
+)
+
+var utf8BOM = []byte{0xEF, 0xBB, 0xBF}
+
+// excludeNoise leaves out modified files that differ from HEAD only in
+// trailing whitespace, blank lines, line endings (including the final newline),
+// a byte order mark or the file mode.
+func (s Step) excludeNoise(changes []FileStatus) ([]FileStatus, []ExcludedFile, error) {
+	var kept []FileStatus
+	var excluded []ExcludedFile
+	for _, f := range changes {
+		if f.Status != FileModified || f.Submodule {
+			kept = append(kept, f)
+			continue
+		}
+		reason, err := s.classifyNoise(f.Path)
+		if err != nil {
+			return nil, nil, fmt.Errorf("classify %s: %w", f.Path, err)
+		}
+		if reason == "" {
+			kept = append(kept, f)
+			continue
+		}
+		excluded = append(excluded, ExcludedFile{FileStatus: f, Reason: reason})
+	}
+	return kept, excluded, nil
+}
+
+// classifyNoise returns why the file's changes are noise, "" if they aren't.
+// Checks go from the narrowest to the broadest, so the reason is the most specific one.
+func (s Step) classifyNoise(path string) (string, error) {
+	checks := []struct {
+		reason string
+		flags  []string
+	}{
+		{reason: noiseFileMode},
+		{reason: noiseLineEndings, flags: []string{"--ignore-cr-at-eol"}},
+		// Only trailing whitespace: indentation and spaces within a line matter in
+		// Python, YAML or Makefiles.
+		{reason: noiseWhitespace, flags: []string{"--ignore-space-at-eol", "--ignore-blank-lines"}},
+	}
+	for _, c := range checks {
+		args := append([]string{"-c", "core.fileMode=false", "--literal-pathspecs", "diff", "HEAD", "--quiet", "--no-ext-diff", "--no-textconv"}, c.flags...)
+		same, err := s.gitDiffQuiet(append(args, "--", path))
+		if err != nil {
+			return "", err
+		}
+		if same {
+			return c.reason, nil
+		}
+	}
+	return s.classifyBOMNoise(path)
+}
+
+// classifyBOMNoise compares the file with HEAD without byte order marks, which
+// git's diff options can't ignore.
+func (s Step) classifyBOMNoise(path string) (string, error) {
+	current, err := os.ReadFile(s.repoFile(path))
+	if err != nil {
+		return "", err
+	}
+	head, err := s.gitHeadBlob(path)
+	if err != nil {
+		return "", err
+	}
+	if bytes.HasPrefix(current, utf8BOM) == bytes.HasPrefix(head, utf8BOM) {
+		return "", nil
+	}
+	current = bytes.TrimPrefix(current, utf8BOM)
+	head = bytes.TrimPrefix(head, utf8BOM)
+	if bytes.Equal(current, head) {
+		return noiseByteOrderMark, nil
+	}
+
+	dir, err := os.MkdirTemp("", "autofix-noise")
+	if err != nil {
+		return "", err
+	}
+	defer os.RemoveAll(dir)
+	headFile, currentFile := filepath.Join(dir, "head"), filepath.Join(dir, "current")
+	if err := os.WriteFile(headFile, head, 0644); err != nil {
+		return "", err
+	}
+	if err := os.WriteFile(currentFile, current, 0644); err != nil {
+		return "", err
+	}
+	same, err := s.gitDiffQuiet([]string{"diff", "--no-index", "--quiet", "--no-ext-diff", "--no-textconv", "--ignore-space-at-eol", "--ignore-blank-lines", "--", headFile, currentFile})
+	if err != nil || !same {
+		return "", err
+	}
+	return noiseBOMWhitespace, nil
+}
+
+// gitDiffQuiet runs a `git diff --quiet` and reports whether there was no difference.
+func (s Step) gitDiffQuiet(args []string) (bool, error) {
+	exitCode, err := s.commandFactory.Create("git", args, nil).RunAndReturnExitCode()
+	if err != nil && exitCode != 1 {
+		return false, fmt.Errorf("git diff: %w", err)
+	}
+	return exitCode == 0, nil
+}
@@ -0,0 +1,29 @@
+package step
+
+import (
+	"testing"
+
+	"github.com/bitrise-io/go-utils/v2/log"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_excludeNoise_onlyModifiedFiles(t *testing.T) {
+	// The fake git reports no difference for every diff, so each modified file looks like a mode change.
+	factory := &fakeCommandFactory{}
+	s := Step{commandFactory: factory, logger: log.NewLogger(), envRepo: fakeEnvRepo{}}
+	changes := []FileStatus{
+		{Path: "[id].sh", Status: FileModified},
+		{Path: "new.go", Status: FileUntracked},
+		{Path: "old.go", Status: FileDeleted},
+		{Path: "b.go", OldPath: "a.go", Status: FileRenamed},
+	}
+
+	kept, excluded, err := s.excludeNoise(changes)
+
+	require.NoError(t, err)
+	assert.Equal(t, []string{"new.go", "old.go", "a.go -> b.go"}, filePaths(kept))
+	assert.Equal(t, []ExcludedFile{{FileStatus: changes[0], Reason: noiseFileMode}}, excluded)
+	require.Len(t, factory.calls, 1, "only the modified file should be diffed")
+	assert.Equal(t, []string{"-c", "core.fileMode=false", "--literal-pathspecs", "diff", "HEAD", "--quiet", "--no-ext-diff", "--no-textconv", "--", "[id].sh"}, factory.calls[0].args)
+}
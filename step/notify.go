@@ -0,0 +1,89 @@
+package step
+
+import (
+	"strings"
+
+	"github.com/bitrise-steplib/bitrise-step-autofix-ci/webhook"
+)
+
+// notify posts the outcome of the run to the configured webhook.
+// Delivery failures are logged but never fail the step: the notification is a
+// side channel and must not change the build result.
+func (s Step) notify(input Input, result Result) {
+	payload := s.buildWebhookPayload(result)
+
+	s.logger.Println()
+	s.logger.Infof("Sending %s notification to webhook", payload.Event)
+	client := webhook.NewClient(string(input.WebhookURL), string(input.WebhookSecret))
+	if err := client.Send(payload); err != nil {
+		s.logger.Warnf("Failed to send webhook notification: %s", err)
+	}
+}
+
+func (s Step) buildWebhookPayload(result Result) webhook.Payload {
+	event, reason := webhookEvent(result)
+	var repositories []webhook.Repository
+	for _, r := range result.Repositories {
+		repositories = append(repositories, webhook.Repository{
+			Path:      r.Path,
+			Outcome:   string(r.Outcome),
+			Branch:    r.Branch,
+			HeadSHA:   r.HeadSHA,
+			CommitSHA: r.CommitSHA,
+			Files:     filePaths(r.Files),
+		})
+	}
+	return webhook.Payload{
+		Event:        event,
+		Outcome:      string(result.Outcome),
+		Reason:       reason,
+		Branch:       result.Branch,
+		HeadSHA:      result.HeadSHA,
+		CommitSHA:    result.CommitSHA,
+		Files:        filePaths(result.Files),
+		Repositories: repositories,
+		Build: webhook.Build{
+			AppSlug:     s.envRepo.Get("BITRISE_APP_SLUG"),
+			BuildSlug:   s.envRepo.Get("BITRISE_BUILD_SLUG"),
+			BuildNumber: s.envRepo.Get("BITRISE_BUILD_NUMBER"),
+			BuildURL:    s.envRepo.Get("BITRISE_BUILD_URL"),
+			PullRequest: s.envRepo.Get("BITRISE_PULL_REQUEST"),
+			Workflow:    s.envRepo.Get("BITRISE_TRIGGERED_WORKFLOW_ID"),
+		},
+	}
+}
+
+// webhookEvent maps the outcome to the coarser event set of the webhook.
+// Skips carry the outcome as their reason, failures carry the error message.
+func webhookEvent(result Result) (webhook.Event, string) {
+	if result.Outcome.Skipped() {
+		return webhook.EventSkipped, string(result.Outcome)
+	}
+
+	var event webhook.Event
+	switch result.Outcome {
+	case OutcomePushed:
+		event = webhook.EventPushed
+	case OutcomeDryRun:
+		event = webhook.EventDryRun
+	case OutcomeSecurityBlocked:
+		event = webhook.EventSecurityBlocked
+	case OutcomeConflict:
+		event = webhook.EventConflict
+	case OutcomePushFailed:
+		event = webhook.EventPushFailed
+	case OutcomeCheckFailed:
+		event = webhook.EventCheckFailed
+	default:
+		event = webhook.EventError
+	}
+	if result.Error == "" && len(result.LimitViolations) > 0 {
+		// A patch-only run stops at the limits without an error.
+		violations := make([]string, 0, len(result.LimitViolations))
+		for _, v := range result.LimitViolations {
+			violations = append(violations, v.String())
+		}
+		return event, strings.Join(violations, "; ")
+	}
+	return event, result.Error
+}
@@ -0,0 +1,97 @@
+package step
+
+import (
+	"testing"
+
+	"github.com/bitrise-steplib/bitrise-step-autofix-ci/webhook"
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_buildWebhookPayload(t *testing.T) {
+	s := Step{envRepo: fakeEnvRepo{
+		"BITRISE_BUILD_URL":    "https://app.bitrise.io/build/abc",
+		"BITRISE_PULL_REQUEST": "42",
+	}}
+
+	tests := []struct {
+		name       string
+		result     Result
+		wantEvent  webhook.Event
+		wantReason string
+	}{
+		{
+			name:       "skip carries the outcome as reason",
+			result:     Result{Outcome: OutcomeFork},
+			wantEvent:  webhook.EventSkipped,
+			wantReason: "fork",
+		},
+		{
+			name:       "classified failure uses the error as reason",
+			result:     Result{Outcome: OutcomeSecurityBlocked, AutofixNeeded: true, Error: "security check failed"},
+			wantEvent:  webhook.EventSecurityBlocked,
+			wantReason: "security check failed",
+		},
+		{
+			name:       "unclassified failure is reported as a generic error",
+			result:     Result{Outcome: OutcomeError, Error: "detect changes: boom"},
+			wantEvent:  webhook.EventError,
+			wantReason: "detect changes: boom",
+		},
+		{
+			name:      "check-only failure has its own event",
+			result:    Result{Outcome: OutcomeCheckFailed, AutofixNeeded: true},
+			wantEvent: webhook.EventCheckFailed,
+		},
+		{
+			name:       "failure without its own event keeps the outcome",
+			result:     Result{Outcome: OutcomeHookFailed, AutofixNeeded: true, Error: "pre_commit_command failed"},
+			wantEvent:  webhook.EventError,
+			wantReason: "pre_commit_command failed",
+		},
+		{
+			name: "patch-only run gives the exceeded limits as reason",
+			result: Result{Outcome: OutcomeLimitExceeded, AutofixNeeded: true, PatchOnly: true, LimitViolations: []LimitViolation{
+				{Limit: limitMaxFiles, Value: 12, Max: 10},
+				{Limit: limitMaxChangedLines, Value: 900, Max: 500},
+			}},
+			wantEvent:  webhook.EventError,
+			wantReason: "12 files changed, the limit is 10 (max_files); 900 lines added or removed, the limit is 500 (max_changed_lines)",
+		},
+		{
+			name:      "push",
+			result:    Result{Outcome: OutcomePushed, Files: []FileStatus{{Path: "main.go", Status: FileModified}}},
+			wantEvent: webhook.EventPushed,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := s.buildWebhookPayload(tt.result)
+			assert.Equal(t, tt.wantEvent, p.Event)
+			assert.Equal(t, string(tt.result.Outcome), p.Outcome)
+			assert.Equal(t, tt.wantReason, p.Reason)
+			assert.NotNil(t, p.Files)
+			assert.Len(t, p.Files, len(tt.result.Files))
+			assert.Equal(t, "https://app.bitrise.io/build/abc", p.Build.BuildURL)
+			assert.Equal(t, "42", p.Build.PullRequest)
+		})
+	}
+}
+
+func Test_buildWebhookPayload_repositories(t *testing.T) {
+	s := Step{envRepo: fakeEnvRepo{}}
+	result := combineResults([]RepositoryResult{
+		{Path: ".", Result: Result{Outcome: OutcomeNoChanges, Branch: "feature", HeadSHA: "aaa"}},
+		{Path: "deps/api", Result: Result{
+			Outcome: OutcomePushed, AutofixNeeded: true, AutofixPushed: true, Branch: "develop", HeadSHA: "bbb", CommitSHA: "ccc",
+			Files: []FileStatus{{Path: "main.go", Status: FileModified}},
+		}},
+	})
+
+	p := s.buildWebhookPayload(result)
+
+	assert.Equal(t, []webhook.Repository{
+		{Path: ".", Outcome: "no_changes", Branch: "feature", HeadSHA: "aaa", Files: []string{}},
+		{Path: "deps/api", Outcome: "pushed", Branch: "develop", HeadSHA: "bbb", CommitSHA: "ccc", Files: []string{"main.go"}},
+	}, p.Repositories)
+}
@@ -0,0 +1,136 @@
+package step
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/bitrise-io/go-utils/v2/command"
+	"gopkg.in/yaml.v3"
+)
+
+const preCommitConfigFile = ".pre-commit-config.yaml"
+
+// PreCommitMode selects whether and on which files the pre-commit framework runs.
+type PreCommitMode string
+
+const (
+	PreCommitOff      PreCommitMode = "off"
+	PreCommitAllFiles PreCommitMode = "all_files"
+	PreCommitPRFiles  PreCommitMode = "pr_files"
+)
+
+// preCommitConfig is the part of .pre-commit-config.yaml the step needs.
+type preCommitConfig struct {
+	Repos []struct {
+		Hooks []struct {
+			ID string `yaml:"id"`
+		} `yaml:"hooks"`
+	} `yaml:"repos"`
+}
+
+// parsePreCommitHookIDs returns the hook IDs in the order pre-commit runs them.
+// The same ID can be configured more than once, pre-commit run <id> runs all of them.
+func parsePreCommitHookIDs(data []byte) ([]string, error) {
+	var cfg preCommitConfig
+	if err := yaml.Unmarshal(data, &cfg); err != nil {
+		return nil, err
+	}
+	var ids []string
+	for _, repo := range cfg.Repos {
+		for _, hook := range repo.Hooks {
+			if hook.ID != "" {
+				ids = appendUnique(ids, hook.ID)
+			}
+		}
+	}
+	return ids, nil
+}
+
+// runPreCommit runs every hook of the base branch's pre-commit config one by
+// one, so the files each hook changed can be attributed to its ID. Besides the
+// attribution it returns a one line summary per hook for the commit message.
+func (s Step) runPreCommit(mode PreCommitMode, username, token string, checkOnly bool) (map[string][]string, []string, error) {
+	data, source, err := s.readTrustedFile(preCommitConfigFile, username, token, checkOnly)
+	if err != nil {
+		return nil, nil, err
+	}
+	if data == nil {
+		return nil, nil, fmt.Errorf("no %s found on %s", preCommitConfigFile, source)
+	}
+	ids, err := parsePreCommitHookIDs(data)
+	if err != nil {
+		return nil, nil, fmt.Errorf("parse %s: %w", preCommitConfigFile, err)
+	}
+	s.logger.Infof("Using %s from %s with %d hook(s)", preCommitConfigFile, source, len(ids))
+
+	// The config from the working tree may have been changed by the PR,
+	// so pre-commit gets a copy of the trusted one.
+	configFile, err := os.CreateTemp("", "pre-commit-config-*.yaml")
+	if err != nil {
+		return nil, nil, fmt.Errorf("write pre-commit config: %w", err)
+	}
+	defer os.Remove(configFile.Name())
+	if _, err := configFile.Write(data); err != nil {
+		configFile.Close()
+		return nil, nil, fmt.Errorf("write pre-commit config: %w", err)
+	}
+	if err := configFile.Close(); err != nil {
+		return nil, nil, fmt.Errorf("write pre-commit config: %w", err)
+	}
+
+	// pr_files passes the files explicitly rather than using --from-ref/--to-ref:
+	// pre-commit stashes unstaged changes unless --all-files or --files is used,
+	// and the changes of earlier steps are unstaged at this point.
+	scopeArgs := []string{"--all-files"}
+	if mode == PreCommitPRFiles {
+		files, err := s.gitPRChangedFiles()
+		if err != nil {
+			return nil, nil, err
+		}
+		if len(files) == 0 {
+			s.logger.Infof("The PR doesn't change any files, skipping pre-commit")
+			return nil, nil, nil
+		}
+		scopeArgs = append([]string{"--files"}, files...)
+	}
+
+	attribution := map[string][]string{}
+	var summary []string
+	before, err := s.snapshotTree()
+	if err != nil {
+		return nil, nil, err
+	}
+	for _, id := range ids {
+		s.logger.Println()
+		s.logger.Infof("Running pre-commit hook %s", id)
+		args := append([]string{"run", id, "--config", configFile.Name(), "--color", "never"}, scopeArgs...)
+		cmd := s.commandFactory.Create("pre-commit", args, &command.Opts{Stdout: os.Stdout, Stderr: os.Stderr})
+		// pre-commit exits with 1 both when a hook fails and when it modifies
+		// files, anything else is an error of pre-commit itself.
+		exitCode, err := cmd.RunAndReturnExitCode()
+		if err != nil && exitCode != 1 {
+			return attribution, summary, fmt.Errorf("pre-commit hook %s: %w", id, err)
+		}
+
+		after, err := s.snapshotTree()
+		if err != nil {
+			return attribution, summary, err
+		}
+		changed := changedPaths(before, after)
+		for _, path := range changed {
+			attribution[path] = appendUnique(attribution[path], id)
+		}
+		before = after
+
+		switch {
+		case len(changed) > 0:
+			summary = append(summary, fmt.Sprintf("%s: modified %d file(s)", id, len(changed)))
+		case exitCode != 0:
+			s.logger.Warnf("pre-commit hook %s failed without changing files, it has to be fixed manually", id)
+			summary = append(summary, fmt.Sprintf("%s: failed", id))
+		default:
+			summary = append(summary, fmt.Sprintf("%s: passed", id))
+		}
+	}
+	return attribution, summary, nil
+}
@@ -0,0 +1,36 @@
+package step
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_parsePreCommitHookIDs(t *testing.T) {
+	config := `
+default_stages: [pre-commit]
+repos:
+  - repo: https://github.com/pre-commit/pre-commit-hooks
+    rev: v4.5.0
+    hooks:
+      - id: trailing-whitespace
+      - id: end-of-file-fixer
+  - repo: local
+    hooks:
+      - id: swiftformat
+        name: swiftformat
+        entry: swiftformat
+        language: system
+      - id: trailing-whitespace
+        args: [--markdown-linebreak-ext=md]
+`
+
+	ids, err := parsePreCommitHookIDs([]byte(config))
+
+	require.NoError(t, err)
+	assert.Equal(t, []string{"trailing-whitespace", "end-of-file-fixer", "swiftformat"}, ids)
+
+	_, err = parsePreCommitHookIDs([]byte("repos: {"))
+	assert.Error(t, err)
+}
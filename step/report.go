@@ -0,0 +1,259 @@
+package step
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+const (
+	reportFileName = "autofix-report.json"
+	patchFileName  = "autofix.patch"
+)
+
+// Outcome is the machine-readable reason a run ended the way it did.
+type Outcome string
+
+const (
+	OutcomeNotPR           Outcome = "not_pr"
+	OutcomeFork            Outcome = "fork"
+	OutcomeNoChanges       Outcome = "no_changes"
+	OutcomeSecurityBlocked Outcome = "security_blocked"
+	OutcomeConflict        Outcome = "conflict"
+	OutcomePushed          Outcome = "pushed"
+	OutcomeDryRun          Outcome = "dry_run"
+	OutcomePushFailed      Outcome = "push_failed"
+	// OutcomeCheckFailed is the result of check-only mode finding changes.
+	OutcomeCheckFailed Outcome = "check_failed"
+	// OutcomeLoopLimit means the branch already ends with the maximum number of
+	// consecutive autofix commits, so the step refused to add another one.
+	OutcomeLoopLimit Outcome = "loop_limit"
+	// OutcomeFixFailed means a fix command failed or the commands never stopped changing files.
+	OutcomeFixFailed Outcome = "fix_failed"
+	// OutcomeValidationFailed means the autofixed tree failed the validate commands,
+	// so the commit was discarded instead of pushed.
+	OutcomeValidationFailed Outcome = "validation_failed"
+	// OutcomeHookFailed means the pre_commit or pre_push hook failed, so nothing was pushed.
+	OutcomeHookFailed Outcome = "hook_failed"
+	// OutcomeDeletionBlocked means the changes delete files while deletions are denied.
+	OutcomeDeletionBlocked Outcome = "deletion_blocked"
+	// OutcomeSubmoduleBlocked means a submodule changed while submodules are refused.
+	OutcomeSubmoduleBlocked Outcome = "submodule_blocked"
+	// OutcomeInvalidFiles means changed files no longer parse or changed encoding, so nothing was committed.
+	OutcomeInvalidFiles Outcome = "invalid_files"
+	// OutcomeLimitExceeded means the changes crossed a size limit, so nothing was committed.
+	OutcomeLimitExceeded Outcome = "limit_exceeded"
+	// OutcomeSnapshot means the step ran in snapshot mode and only recorded the working tree state.
+	OutcomeSnapshot Outcome = "snapshot"
+	// OutcomeError covers every failure that has no dedicated outcome,
+	// e.g. invalid inputs or a failing git command.
+	OutcomeError Outcome = "error"
+)
+
+// Skipped reports whether the step decided not to act, as opposed to acting
+// (successfully or not) on detected changes.
+func (o Outcome) Skipped() bool {
+	return o == OutcomeNotPR || o == OutcomeFork || o == OutcomeNoChanges
+}
+
+// OnPushMode controls how the build ends after the autofix commit was pushed.
+type OnPushMode string
+
+const (
+	// OnPushFail fails the build, so the unfixed commit doesn't pass CI gates
+	// and the build triggered by the push gives the final result.
+	OnPushFail OnPushMode = "fail"
+	// OnPushSucceed lets the build pass, for workflows whose trigger ignores bot commits.
+	OnPushSucceed OnPushMode = "succeed"
+	// OnPushSucceedWithSkipCI lets the build pass and asks CI not to build the autofix commit.
+	OnPushSucceedWithSkipCI OnPushMode = "succeed_with_skip_ci"
+)
+
+// FailsBuild reports whether the step should exit with failure after pushing.
+func (m OnPushMode) FailsBuild() bool {
+	return m != OnPushSucceed && m != OnPushSucceedWithSkipCI
+}
+
+// FailsBuild reports whether the step should exit with failure even though it
+// returned no error. With several repositories it does if any of them does.
+func (r Result) FailsBuild() bool {
+	if len(r.Repositories) > 0 {
+		for _, repo := range r.Repositories {
+			if repo.FailsBuild() {
+				return true
+			}
+		}
+		return false
+	}
+
+	if r.AutofixPushed && !r.OnPush.FailsBuild() {
+		// on_push opted out of failing: the workflow either ignores the
+		// autofix commit or asked CI to skip it. Both modes exit with 0, any
+		// other code would fail the build; the outputs tell them apart.
+		return false
+	}
+	if r.PatchOnly {
+		// on_limit_exceeded asked to deliver oversized changes as a patch instead of failing.
+		return false
+	}
+	// A new build will be triggered by the push; fail this one intentionally
+	// so CI gates don't pass on the unfixed commit.
+	// In check-only mode this is the failed check itself.
+	return r.AutofixNeeded && !r.DryRun
+}
+
+// ExcludedFile is a change that was detected but left out of the autofix commit.
+type ExcludedFile struct {
+	FileStatus
+	Reason string `json:"reason"`
+	// Hunks is the number of hunks left out when the file was scoped hunk by hunk.
+	// These are already reverted in the working tree.
+	Hunks int `json:"hunks,omitempty"`
+}
+
+// FileChangeKind is the kind of change git status reported for a file.
+type FileChangeKind string
+
+const (
+	FileModified    FileChangeKind = "modified"
+	FileAdded       FileChangeKind = "added"
+	FileDeleted     FileChangeKind = "deleted"
+	FileRenamed     FileChangeKind = "renamed"
+	FileCopied      FileChangeKind = "copied"
+	FileTypeChanged FileChangeKind = "type_changed"
+	FileUnmerged    FileChangeKind = "unmerged"
+	FileUntracked   FileChangeKind = "untracked"
+)
+
+type FileStatus struct {
+	Path   string         `json:"path"`
+	Status FileChangeKind `json:"status"`
+	// OldPath is set for renames and copies.
+	OldPath string `json:"old_path,omitempty"`
+	// Added and Removed are line counts from the autofix diff.
+	Added   int  `json:"added"`
+	Removed int  `json:"removed"`
+	Binary  bool `json:"binary,omitempty"`
+	// Tools are the fix commands that changed the file, empty if it was changed before the step ran.
+	Tools []string `json:"tools,omitempty"`
+	// Submodule is set when the path is a submodule, changed inside or checked out at another commit.
+	Submodule bool `json:"submodule,omitempty"`
+}
+
+// String formats the file the way git status does, which is also how it
+// appears in logs and in the commit message.
+func (f FileStatus) String() string {
+	if f.OldPath != "" {
+		return f.OldPath + " -> " + f.Path
+	}
+	return f.Path
+}
+
+func filePaths(files []FileStatus) []string {
+	paths := make([]string, 0, len(files))
+	for _, f := range files {
+		paths = append(paths, f.String())
+	}
+	return paths
+}
+
+type PhaseTiming struct {
+	Phase      string `json:"phase"`
+	DurationMS int64  `json:"duration_ms"`
+}
+
+func (r *Result) recordPhase(phase string, start time.Time) {
+	r.Timings = append(r.Timings, PhaseTiming{Phase: phase, DurationMS: time.Since(start).Milliseconds()})
+}
+
+// writeReports writes every enabled report about the result. Failures are only
+// logged: reports describe the run, they must not change its outcome.
+func (s Step) writeReports(input Input, result *Result) {
+	if reportPath, err := s.writeReport(*result); err != nil {
+		s.logger.Warnf("Failed to write JSON report: %s", err)
+	} else {
+		result.ReportPath = reportPath
+	}
+
+	if input.StepSummary {
+		if summaryPath, err := s.writeSummary(*result); err != nil {
+			s.logger.Warnf("Failed to write summary: %s", err)
+		} else {
+			result.SummaryPath = summaryPath
+		}
+	}
+
+	// Skipped runs have nothing to report as a test failure.
+	if input.JUnitReport && len(result.Diff) > 0 {
+		if junitPath, err := s.writeJUnitReport(*result); err != nil {
+			s.logger.Warnf("Failed to write JUnit report: %s", err)
+		} else {
+			s.logger.Debugf("JUnit report written to %s", junitPath)
+		}
+	}
+
+	// An empty SARIF file is still useful when there are no changes: uploading
+	// it clears the annotations of earlier runs.
+	if input.SARIFReport && (result.Outcome == OutcomeNoChanges || len(result.Diff) > 0) {
+		if sarifPath, err := s.writeSARIF(*result); err != nil {
+			s.logger.Warnf("Failed to write SARIF report: %s", err)
+		} else {
+			result.SARIFPath = sarifPath
+		}
+	}
+}
+
+// outputDir returns the directory for report files: the deploy dir, so they
+// are also available as build artifacts. Outside of Bitrise it falls back to the temp dir.
+func (s Step) outputDir() (string, error) {
+	dir := s.envRepo.Get("BITRISE_DEPLOY_DIR")
+	if dir == "" {
+		dir = os.TempDir()
+	}
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return "", fmt.Errorf("create output dir: %w", err)
+	}
+	return dir, nil
+}
+
+// writeReport writes the result as JSON into the output dir.
+func (s Step) writeReport(result Result) (string, error) {
+	dir, err := s.outputDir()
+	if err != nil {
+		return "", err
+	}
+
+	if result.Files == nil {
+		// Keep the schema stable: consumers shouldn't have to handle null arrays.
+		result.Files = []FileStatus{}
+	}
+	if result.Timings == nil {
+		result.Timings = []PhaseTiming{}
+	}
+	data, err := json.MarshalIndent(result, "", "  ")
+	if err != nil {
+		return "", fmt.Errorf("marshal report: %w", err)
+	}
+
+	path := filepath.Join(dir, reportFileName)
+	if err := os.WriteFile(path, data, 0644); err != nil {
+		return "", fmt.Errorf("write report: %w", err)
+	}
+	return path, nil
+}
+
+// writePatch writes the raw autofix diff into the output dir, so it can be
+// downloaded from the build artifacts and applied locally with git apply.
+func (s Step) writePatch(patch string) (string, error) {
+	dir, err := s.outputDir()
+	if err != nil {
+		return "", err
+	}
+	path := filepath.Join(dir, patchFileName)
+	if err := os.WriteFile(path, []byte(patch), 0644); err != nil {
+		return "", fmt.Errorf("write patch: %w", err)
+	}
+	return path, nil
+}
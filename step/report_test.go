@@ -0,0 +1,107 @@
+package step
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_OnPushMode_FailsBuild(t *testing.T) {
+	assert.True(t, OnPushFail.FailsBuild())
+	assert.True(t, OnPushMode("").FailsBuild(), "nothing was pushed, or the mode is unknown")
+	assert.False(t, OnPushSucceed.FailsBuild())
+	assert.False(t, OnPushSucceedWithSkipCI.FailsBuild())
+}
+
+func Test_Result_FailsBuild(t *testing.T) {
+	tests := []struct {
+		name   string
+		result Result
+		want   bool
+	}{
+		{name: "no changes", result: Result{Outcome: OutcomeNoChanges}, want: false},
+		{name: "pushed", result: Result{Outcome: OutcomePushed, AutofixNeeded: true, AutofixPushed: true, OnPush: OnPushFail}, want: true},
+		{name: "pushed with on_push: succeed", result: Result{Outcome: OutcomePushed, AutofixNeeded: true, AutofixPushed: true, OnPush: OnPushSucceed}, want: false},
+		{name: "dry run", result: Result{Outcome: OutcomeDryRun, AutofixNeeded: true, DryRun: true}, want: false},
+		{name: "patch only", result: Result{Outcome: OutcomeLimitExceeded, AutofixNeeded: true, PatchOnly: true}, want: false},
+		{name: "check only", result: Result{Outcome: OutcomeCheckFailed, AutofixNeeded: true}, want: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, tt.result.FailsBuild())
+		})
+	}
+}
+
+func Test_writeReport(t *testing.T) {
+	dir := t.TempDir()
+	s := Step{envRepo: fakeEnvRepo{"BITRISE_DEPLOY_DIR": dir}}
+
+	path, err := s.writeReport(Result{
+		Outcome:       OutcomePushed,
+		AutofixNeeded: true,
+		AutofixPushed: true,
+		FileCount:     1,
+		Branch:        "feature",
+		CommitSHA:     "abc123",
+		Files:         []FileStatus{{Path: "main.go", Status: FileModified, Added: 2, Removed: 1}},
+		Timings:       []PhaseTiming{{Phase: "detect", DurationMS: 12}},
+	})
+	require.NoError(t, err)
+	assert.Equal(t, filepath.Join(dir, reportFileName), path)
+
+	data, err := os.ReadFile(path)
+	require.NoError(t, err)
+
+	var report map[string]any
+	require.NoError(t, json.Unmarshal(data, &report))
+	assert.Equal(t, "pushed", report["outcome"])
+	assert.Equal(t, "abc123", report["commit_sha"])
+	assert.Equal(t, "feature", report["branch"])
+	assert.Equal(t, []any{map[string]any{"path": "main.go", "status": "modified", "added": float64(2), "removed": float64(1)}}, report["files"])
+	assert.Equal(t, []any{map[string]any{"phase": "detect", "duration_ms": float64(12)}}, report["timings"])
+}
+
+func Test_writeReport_repositories(t *testing.T) {
+	s := Step{envRepo: fakeEnvRepo{"BITRISE_DEPLOY_DIR": t.TempDir()}}
+	result := combineResults([]RepositoryResult{
+		{Path: ".", Result: Result{Outcome: OutcomeNoChanges, Branch: "feature", HeadSHA: "aaa"}},
+		{Path: "deps/api", Result: Result{Outcome: OutcomePushed, Branch: "develop", HeadSHA: "bbb", CommitSHA: "ccc"}},
+	})
+
+	path, err := s.writeReport(result)
+	require.NoError(t, err)
+	data, err := os.ReadFile(path)
+	require.NoError(t, err)
+
+	var report struct {
+		Repositories []map[string]any `json:"repositories"`
+	}
+	require.NoError(t, json.Unmarshal(data, &report))
+	require.Len(t, report.Repositories, 2)
+	for i, want := range []map[string]string{
+		{"path": ".", "branch": "feature", "head_sha": "aaa"},
+		{"path": "deps/api", "branch": "develop", "head_sha": "bbb", "commit_sha": "ccc"},
+	} {
+		for key, value := range want {
+			assert.Equal(t, value, report.Repositories[i][key], "repositories[%d].%s", i, key)
+		}
+	}
+}
+
+func Test_writeReport_SkipHasEmptyArrays(t *testing.T) {
+	s := Step{envRepo: fakeEnvRepo{"BITRISE_DEPLOY_DIR": t.TempDir()}}
+
+	path, err := s.writeReport(Result{Outcome: OutcomeNotPR})
+	require.NoError(t, err)
+
+	data, err := os.ReadFile(path)
+	require.NoError(t, err)
+	assert.Contains(t, string(data), `"files": []`)
+	assert.Contains(t, string(data), `"timings": []`)
+	assert.NotContains(t, string(data), `"error"`)
+}
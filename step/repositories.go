@@ -0,0 +1,286 @@
+package step
+
+import (
+	"errors"
+	"fmt"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/bitrise-io/go-utils/v2/env"
+)
+
+// RepositorySpec is one line of the repositories input: a repository to
+// autofix, with its own remote, branch and credentials.
+type RepositorySpec struct {
+	Path   string
+	Remote string
+	Branch string
+	// Username and TokenEnv override git_username and git_token. The token is
+	// referenced by the name of an env var, so no secret ends up in the input.
+	Username string
+	TokenEnv string
+}
+
+// RepositoryResult is the result of one repository of a multi-repository run.
+type RepositoryResult struct {
+	Path string `json:"path"`
+	Result
+}
+
+// parseRepositories parses the repositories input, one repository per line:
+// the path, followed by optional remote=, branch=, username= and token_env= fields.
+func parseRepositories(lines []string) ([]RepositorySpec, error) {
+	var specs []RepositorySpec
+	seen := map[string]bool{}
+	for _, line := range lines {
+		fields := strings.Fields(line)
+		if len(fields) == 0 || strings.HasPrefix(fields[0], "#") {
+			continue
+		}
+		spec := RepositorySpec{Path: fields[0]}
+		for _, field := range fields[1:] {
+			key, value, ok := strings.Cut(field, "=")
+			if !ok || value == "" {
+				return nil, fmt.Errorf("repositories: %s: %q is not a key=value field", spec.Path, field)
+			}
+			switch key {
+			case "remote":
+				spec.Remote = value
+			case "branch":
+				spec.Branch = value
+			case "username":
+				spec.Username = value
+			case "token_env":
+				spec.TokenEnv = value
+			default:
+				return nil, fmt.Errorf("repositories: %s: unknown field %q (expected remote, branch, username or token_env)", spec.Path, key)
+			}
+		}
+		clean := filepath.Clean(spec.Path)
+		if seen[clean] {
+			return nil, fmt.Errorf("repositories: %s is listed more than once", spec.Path)
+		}
+		seen[clean] = true
+		specs = append(specs, spec)
+	}
+	return specs, nil
+}
+
+// envOverlay is an env.Repository that overrides some values of another one.
+type envOverlay struct {
+	env.Repository
+	values map[string]string
+}
+
+func (e envOverlay) Get(key string) string {
+	if value, ok := e.values[key]; ok {
+		return value
+	}
+	return e.Repository.Get(key)
+}
+
+func (e envOverlay) List() []string {
+	var list []string
+	for _, kv := range e.Repository.List() {
+		key, _, _ := strings.Cut(kv, "=")
+		if _, ok := e.values[key]; !ok {
+			list = append(list, kv)
+		}
+	}
+	keys := make([]string, 0, len(e.values))
+	for key := range e.values {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		list = append(list, key+"="+e.values[key])
+	}
+	return list
+}
+
+// forRepository returns the step and inputs for one repository of a
+// multi-repository run. The first repository is the one the build is for: it
+// keeps git_remote_url, the PR's base branch and the scope. The others are
+// dependencies outside of the PR: they are fixed in full, keep their own origin
+// unless remote= is set, and read the config file from the branch they are pushed to.
+func (s Step) forRepository(input Input, spec RepositorySpec, primary bool, outputDir string) (Step, Input, error) {
+	values := map[string]string{
+		// Keep the artifacts of the repositories apart, the combined ones go to the output dir itself.
+		"BITRISE_DEPLOY_DIR": filepath.Join(outputDir, "repositories", repositorySlug(spec.Path)),
+	}
+	branch := spec.Branch
+	if branch == "" {
+		branch = s.envRepo.Get("BITRISE_GIT_BRANCH")
+	}
+	values["BITRISE_GIT_BRANCH"] = branch
+
+	input.RepoPath = spec.Path
+	if !primary {
+		values["BITRISEIO_GIT_BRANCH_DEST"] = branch
+		input.GitRemoteURL = ""
+		input.Scope = ScopeAll
+		if input.PreCommit == PreCommitPRFiles {
+			input.PreCommit = PreCommitAllFiles
+		}
+	}
+	if spec.Remote != "" {
+		input.GitRemoteURL = spec.Remote
+	}
+	if spec.Username != "" {
+		input.GitUsername = spec.Username
+	}
+	if spec.TokenEnv != "" {
+		input.GitToken = s.envRepo.Get(spec.TokenEnv)
+		if input.GitToken == "" && !input.CheckOnly {
+			return s, input, fmt.Errorf("token_env: $%s is empty", spec.TokenEnv)
+		}
+	}
+
+	overlay := envOverlay{Repository: s.envRepo, values: values}
+	s.envRepo = overlay
+	// Hooks and fix commands see the repository's values too, e.g. its own deploy dir.
+	s.commandFactory = dirCommandFactory{Factory: s.commandFactory, env: overlay.List()}
+	return s, input, nil
+}
+
+// repositorySlug turns a repository path into a directory name.
+func repositorySlug(path string) string {
+	slug := strings.Trim(filepath.ToSlash(filepath.Clean(path)), "/.")
+	if slug == "" {
+		return "root"
+	}
+	return strings.NewReplacer("/", "_", "..", "_").Replace(slug)
+}
+
+// runRepositories runs the step in each repository in turn. A failure in one
+// repository doesn't stop the others, their results are combined.
+func (s Step) runRepositories(input Input, specs []RepositorySpec) (Result, error) {
+	outputDir, err := s.outputDir()
+	if err == nil {
+		// The commands of each repository run in it, a relative deploy dir would move with them.
+		outputDir, err = filepath.Abs(outputDir)
+	}
+	if err != nil {
+		return Result{Outcome: OutcomeError}, err
+	}
+
+	var results []RepositoryResult
+	var errs []error
+	for i, spec := range specs {
+		s.logger.Println()
+		s.logger.Infof("Repository %d/%d: %s", i+1, len(specs), spec.Path)
+
+		result, err := s.runRepository(input, spec, i == 0, outputDir)
+		if err != nil {
+			s.logger.Errorf("%s: %s", spec.Path, err)
+			errs = append(errs, fmt.Errorf("%s: %w", spec.Path, err))
+		}
+		results = append(results, RepositoryResult{Path: spec.Path, Result: result})
+	}
+	return combineResults(results), errors.Join(errs...)
+}
+
+func (s Step) runRepository(input Input, spec RepositorySpec, primary bool, outputDir string) (Result, error) {
+	s, input, err := s.forRepository(input, spec, primary, outputDir)
+	if err != nil {
+		return Result{Outcome: OutcomeError, Error: err.Error()}, err
+	}
+	if s, err = s.inRepo(input.RepoPath); err != nil {
+		return Result{Outcome: OutcomeError, Error: err.Error()}, err
+	}
+
+	result, err := s.run(input)
+	if err != nil {
+		if result.Outcome == "" {
+			result.Outcome = OutcomeError
+		}
+		result.Error = err.Error()
+	}
+	if reportPath, reportErr := s.writeReport(result); reportErr != nil {
+		s.logger.Warnf("Failed to write JSON report: %s", reportErr)
+	} else {
+		result.ReportPath = reportPath
+	}
+	return result, err
+}
+
+// combineResults merges the results of the repositories into one: the flags are
+// set if any repository set them, the files are listed with the repository path
+// as a prefix, and the outcome is the one of the first repository that failed,
+// or else of the first one that had something to do. The flags are only for the
+// outputs, Result.FailsBuild decides the exit code of each repository on its own.
+func combineResults(results []RepositoryResult) Result {
+	var combined Result
+	var outcome, failed Outcome
+	for _, r := range results {
+		prefix := func(path string) string {
+			if path == "" {
+				return ""
+			}
+			return filepath.ToSlash(filepath.Join(r.Path, path))
+		}
+
+		switch r.Outcome {
+		case OutcomeNoChanges, OutcomeNotPR, OutcomeFork:
+		default:
+			if outcome == "" {
+				outcome = r.Outcome
+			}
+		}
+		if r.Error != "" && failed == "" {
+			failed = r.Outcome
+		}
+
+		combined.AutofixNeeded = combined.AutofixNeeded || r.AutofixNeeded
+		combined.AutofixPushed = combined.AutofixPushed || r.AutofixPushed
+		combined.FileCount += r.FileCount
+		combined.DryRun = combined.DryRun || r.DryRun
+		combined.SkipCI = combined.SkipCI || r.SkipCI
+		combined.PatchOnly = combined.PatchOnly || r.PatchOnly
+		if combined.OnPush == "" {
+			combined.OnPush = r.OnPush
+		}
+		if combined.CommitSHA == "" {
+			combined.CommitSHA = r.CommitSHA
+		}
+		if combined.PatchPath == "" {
+			combined.PatchPath = r.PatchPath
+		}
+
+		for _, f := range r.Files {
+			f.Path, f.OldPath = prefix(f.Path), prefix(f.OldPath)
+			combined.Files = append(combined.Files, f)
+		}
+		for _, f := range r.Excluded {
+			f.Path, f.OldPath = prefix(f.Path), prefix(f.OldPath)
+			combined.Excluded = append(combined.Excluded, f)
+		}
+		for _, f := range r.InvalidFiles {
+			f.Path = prefix(f.Path)
+			combined.InvalidFiles = append(combined.InvalidFiles, f)
+		}
+		for _, f := range r.LimitViolations {
+			f.Path = prefix(f.Path)
+			combined.LimitViolations = append(combined.LimitViolations, f)
+		}
+		for _, path := range r.LFSFiles {
+			combined.LFSFiles = append(combined.LFSFiles, prefix(path))
+		}
+		for _, d := range r.Diff {
+			d.Path, d.OldPath = prefix(d.Path), prefix(d.OldPath)
+			combined.Diff = append(combined.Diff, d)
+		}
+	}
+
+	switch {
+	case failed != "":
+		combined.Outcome = failed
+	case outcome != "":
+		combined.Outcome = outcome
+	case len(results) > 0:
+		combined.Outcome = results[0].Outcome
+	}
+	combined.Repositories = results
+	return combined
+}
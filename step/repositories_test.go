@@ -0,0 +1,156 @@
+package step
+
+import (
+	"testing"
+
+	"github.com/bitrise-io/go-utils/v2/command"
+	"github.com/bitrise-io/go-utils/v2/log"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_parseRepositories(t *testing.T) {
+	tests := []struct {
+		name    string
+		lines   []string
+		want    []RepositorySpec
+		wantErr string
+	}{
+		{
+			name:  "path only",
+			lines: []string{".", "deps/design-system"},
+			want:  []RepositorySpec{{Path: "."}, {Path: "deps/design-system"}},
+		},
+		{
+			name: "all fields, comments and blank lines",
+			lines: []string{
+				"# the app",
+				".",
+				"",
+				"deps/api remote=https://github.com/acme/api.git branch=develop username=bot token_env=API_TOKEN",
+			},
+			want: []RepositorySpec{
+				{Path: "."},
+				{Path: "deps/api", Remote: "https://github.com/acme/api.git", Branch: "develop", Username: "bot", TokenEnv: "API_TOKEN"},
+			},
+		},
+		{
+			name:    "unknown field",
+			lines:   []string{"deps/api token=secret"},
+			wantErr: `repositories: deps/api: unknown field "token"`,
+		},
+		{
+			name:    "not a key=value field",
+			lines:   []string{"deps/api develop"},
+			wantErr: `repositories: deps/api: "develop" is not a key=value field`,
+		},
+		{
+			name:    "duplicate",
+			lines:   []string{"deps/api", "./deps/api/"},
+			wantErr: "repositories: ./deps/api/ is listed more than once",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parseRepositories(tt.lines)
+			if tt.wantErr != "" {
+				require.Error(t, err)
+				assert.Contains(t, err.Error(), tt.wantErr)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
+
+func Test_forRepository(t *testing.T) {
+	factory := &fakeCommandFactory{}
+	s := Step{commandFactory: factory, logger: log.NewLogger(), envRepo: fakeEnvRepo{
+		"BITRISE_GIT_BRANCH":        "feature",
+		"BITRISEIO_GIT_BRANCH_DEST": "main",
+		"API_TOKEN":                 "api-secret",
+	}}
+	input := Input{GitRemoteURL: "https://github.com/acme/app.git", GitToken: "app-secret", Scope: ScopePRHunks, PreCommit: PreCommitPRFiles}
+
+	primary, primaryInput, err := s.forRepository(input, RepositorySpec{Path: "."}, true, "/deploy")
+	require.NoError(t, err)
+	assert.Equal(t, "https://github.com/acme/app.git", primaryInput.GitRemoteURL)
+	assert.Equal(t, ScopePRHunks, primaryInput.Scope)
+	assert.Equal(t, "feature", primary.envRepo.Get("BITRISE_GIT_BRANCH"))
+	assert.Equal(t, "main", primary.envRepo.Get("BITRISEIO_GIT_BRANCH_DEST"))
+	assert.Equal(t, "/deploy/repositories/root", primary.envRepo.Get("BITRISE_DEPLOY_DIR"))
+	primary.commandFactory.Create("sh", []string{"-c", "true"}, &command.Opts{Env: []string{"AUTOFIX_HOOK=pre_commit"}})
+	require.Len(t, factory.calls, 1)
+	assert.Equal(t, []string{"BITRISE_DEPLOY_DIR=/deploy/repositories/root", "BITRISE_GIT_BRANCH=feature", "AUTOFIX_HOOK=pre_commit"}, factory.calls[0].opts.Env)
+
+	dep, depInput, err := s.forRepository(input, RepositorySpec{Path: "deps/api", Branch: "develop", TokenEnv: "API_TOKEN"}, false, "/deploy")
+	require.NoError(t, err)
+	assert.Equal(t, "deps/api", depInput.RepoPath)
+	assert.Empty(t, depInput.GitRemoteURL)
+	assert.Equal(t, "api-secret", depInput.GitToken)
+	assert.Equal(t, ScopeAll, depInput.Scope)
+	assert.Equal(t, PreCommitAllFiles, depInput.PreCommit)
+	assert.Equal(t, "develop", dep.envRepo.Get("BITRISE_GIT_BRANCH"))
+	assert.Equal(t, "develop", dep.envRepo.Get("BITRISEIO_GIT_BRANCH_DEST"))
+	assert.Equal(t, "/deploy/repositories/deps_api", dep.envRepo.Get("BITRISE_DEPLOY_DIR"))
+
+	_, _, err = s.forRepository(input, RepositorySpec{Path: "deps/api", TokenEnv: "MISSING"}, false, "/deploy")
+	assert.EqualError(t, err, "token_env: $MISSING is empty")
+}
+
+func Test_combineResults(t *testing.T) {
+	results := []RepositoryResult{
+		{Path: ".", Result: Result{Outcome: OutcomeNoChanges}},
+		{Path: "deps/api", Result: Result{
+			Outcome: OutcomePushed, AutofixNeeded: true, AutofixPushed: true, FileCount: 1, CommitSHA: "abc",
+			Files: []FileStatus{{Path: "main.go", Status: FileModified}},
+		}},
+		{Path: "deps/ui", Result: Result{
+			Outcome: OutcomeSecurityBlocked, AutofixNeeded: true, FileCount: 2, Error: "blocked",
+			Files: []FileStatus{{Path: "a.ts", Status: FileModified}, {Path: "b.ts", Status: FileAdded}},
+		}},
+	}
+
+	combined := combineResults(results)
+	assert.Equal(t, OutcomeSecurityBlocked, combined.Outcome)
+	assert.True(t, combined.AutofixNeeded)
+	assert.True(t, combined.AutofixPushed)
+	assert.Equal(t, 3, combined.FileCount)
+	assert.Equal(t, "abc", combined.CommitSHA)
+	assert.Equal(t, []FileStatus{
+		{Path: "deps/api/main.go", Status: FileModified},
+		{Path: "deps/ui/a.ts", Status: FileModified},
+		{Path: "deps/ui/b.ts", Status: FileAdded},
+	}, combined.Files)
+	assert.Equal(t, results, combined.Repositories)
+
+	assert.Equal(t, OutcomePushed, combineResults(results[:2]).Outcome)
+	assert.Equal(t, OutcomeNoChanges, combineResults(results[:1]).Outcome)
+}
+
+func Test_combineResults_failsBuild(t *testing.T) {
+	patchOnly := RepositoryResult{Path: ".", Result: Result{Outcome: OutcomeLimitExceeded, AutofixNeeded: true, PatchOnly: true}}
+	pushed := func(onPush OnPushMode) RepositoryResult {
+		return RepositoryResult{Path: "deps/api", Result: Result{Outcome: OutcomePushed, AutofixNeeded: true, AutofixPushed: true, OnPush: onPush}}
+	}
+	dryRun := RepositoryResult{Path: "deps/ui", Result: Result{Outcome: OutcomeDryRun, AutofixNeeded: true, DryRun: true}}
+	noChanges := RepositoryResult{Path: "deps/ui", Result: Result{Outcome: OutcomeNoChanges}}
+
+	tests := []struct {
+		name    string
+		results []RepositoryResult
+		want    bool
+	}{
+		{name: "patch-only and pushed", results: []RepositoryResult{patchOnly, pushed(OnPushFail)}, want: true},
+		{name: "patch-only and pushed with on_push: succeed", results: []RepositoryResult{patchOnly, pushed(OnPushSucceed)}, want: false},
+		{name: "pushed with on_push: succeed and a dry run", results: []RepositoryResult{pushed(OnPushSucceed), dryRun}, want: false},
+		{name: "no changes and pushed", results: []RepositoryResult{noChanges, pushed(OnPushFail)}, want: true},
+		{name: "patch-only and no changes", results: []RepositoryResult{patchOnly, noChanges}, want: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, combineResults(tt.results).FailsBuild())
+		})
+	}
+}
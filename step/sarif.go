@@ -0,0 +1,217 @@
+package step
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"unicode/utf16"
+)
+
+const (
+	sarifFileName = "autofix.sarif"
+	sarifSchema   = "https://json.schemastore.org/sarif-2.1.0.json"
+	sarifRuleID   = "autofix"
+)
+
+// The types below model the subset of SARIF 2.1.0 we emit.
+
+type sarifLog struct {
+	Schema  string     `json:"$schema"`
+	Version string     `json:"version"`
+	Runs    []sarifRun `json:"runs"`
+}
+
+type sarifRun struct {
+	Tool sarifTool `json:"tool"`
+	// ColumnKind says how columns are counted. It is set even though
+	// utf16CodeUnits is the default, since not every consumer knows that.
+	ColumnKind string        `json:"columnKind"`
+	Results    []sarifResult `json:"results"`
+}
+
+type sarifTool struct {
+	Driver sarifDriver `json:"driver"`
+}
+
+type sarifDriver struct {
+	Name           string      `json:"name"`
+	InformationURI string      `json:"informationUri"`
+	Rules          []sarifRule `json:"rules"`
+}
+
+type sarifRule struct {
+	ID               string       `json:"id"`
+	ShortDescription sarifMessage `json:"shortDescription"`
+}
+
+type sarifMessage struct {
+	Text string `json:"text"`
+}
+
+type sarifResult struct {
+	RuleID    string          `json:"ruleId"`
+	Level     string          `json:"level"`
+	Message   sarifMessage    `json:"message"`
+	Locations []sarifLocation `json:"locations"`
+	Fixes     []sarifFix      `json:"fixes"`
+}
+
+type sarifLocation struct {
+	PhysicalLocation sarifPhysicalLocation `json:"physicalLocation"`
+}
+
+type sarifPhysicalLocation struct {
+	ArtifactLocation sarifArtifactLocation `json:"artifactLocation"`
+	Region           sarifRegion           `json:"region"`
+}
+
+type sarifArtifactLocation struct {
+	URI       string `json:"uri"`
+	URIBaseID string `json:"uriBaseId"`
+}
+
+type sarifRegion struct {
+	StartLine   int `json:"startLine"`
+	StartColumn int `json:"startColumn,omitempty"`
+	EndLine     int `json:"endLine,omitempty"`
+	EndColumn   int `json:"endColumn,omitempty"`
+}
+
+type sarifFix struct {
+	Description     sarifMessage          `json:"description"`
+	ArtifactChanges []sarifArtifactChange `json:"artifactChanges"`
+}
+
+type sarifArtifactChange struct {
+	ArtifactLocation sarifArtifactLocation `json:"artifactLocation"`
+	Replacements     []sarifReplacement    `json:"replacements"`
+}
+
+type sarifReplacement struct {
+	DeletedRegion   sarifRegion   `json:"deletedRegion"`
+	InsertedContent *sarifContent `json:"insertedContent,omitempty"`
+}
+
+type sarifContent struct {
+	Text string `json:"text"`
+}
+
+// buildSARIF turns every contiguous edit of the autofix diff into a result
+// whose fix replaces the original lines with the autofixed ones. Locations
+// refer to the files as they were before the autofix ran, renamed files
+// included.
+func buildSARIF(diffs []FileDiff) sarifLog {
+	results := []sarifResult{}
+	for _, d := range diffs {
+		if d.Binary {
+			continue
+		}
+		path := d.Path
+		if d.OldPath != "" {
+			path = d.OldPath
+		}
+		location := sarifArtifactLocation{URI: path, URIBaseID: "%SRCROOT%"}
+		for _, h := range d.Hunks {
+			for _, r := range changeRegions(h) {
+				results = append(results, sarifResult{
+					RuleID:  sarifRuleID,
+					Level:   "warning",
+					Message: sarifMessage{Text: sarifResultMessage(r)},
+					Locations: []sarifLocation{{PhysicalLocation: sarifPhysicalLocation{
+						ArtifactLocation: location,
+						Region:           highlightRegion(r),
+					}}},
+					Fixes: []sarifFix{{
+						Description: sarifMessage{Text: "Apply the autofix"},
+						ArtifactChanges: []sarifArtifactChange{{
+							ArtifactLocation: location,
+							Replacements:     []sarifReplacement{replacement(r)},
+						}},
+					}},
+				})
+			}
+		}
+	}
+
+	return sarifLog{
+		Schema:  sarifSchema,
+		Version: "2.1.0",
+		Runs: []sarifRun{{
+			Tool: sarifTool{Driver: sarifDriver{
+				Name:           "Autofix CI",
+				InformationURI: stepRepoURL,
+				Rules: []sarifRule{{
+					ID:               sarifRuleID,
+					ShortDescription: sarifMessage{Text: "Code that formatters, linters or generators in CI would change"},
+				}},
+			}},
+			ColumnKind: "utf16CodeUnits",
+			Results:    results,
+		}},
+	}
+}
+
+func sarifResultMessage(r changeRegion) string {
+	switch {
+	case len(r.Removed) == 0:
+		return fmt.Sprintf("This would be auto-fixed: %d line(s) inserted", len(r.Added))
+	case len(r.Added) == 0:
+		return fmt.Sprintf("This would be auto-fixed: %d line(s) removed", len(r.Removed))
+	default:
+		return fmt.Sprintf("This would be auto-fixed: %d line(s) replaced with %d line(s)", len(r.Removed), len(r.Added))
+	}
+}
+
+// highlightRegion is the region annotated in UIs: the replaced lines, or the
+// line an insertion follows.
+func highlightRegion(r changeRegion) sarifRegion {
+	if len(r.Removed) == 0 {
+		return sarifRegion{StartLine: max(r.OldStart, 1)}
+	}
+	return sarifRegion{StartLine: r.OldStart, EndLine: r.OldStart + len(r.Removed) - 1}
+}
+
+// replacement deletes the removed lines including their line breaks and
+// inserts the added lines in their place.
+func replacement(r changeRegion) sarifReplacement {
+	var deleted sarifRegion
+	switch {
+	case len(r.Removed) == 0:
+		// Zero-length region at the start of the line after the insertion point.
+		deleted = sarifRegion{StartLine: r.OldStart + 1, StartColumn: 1, EndLine: r.OldStart + 1, EndColumn: 1}
+	case r.OldNoEOL:
+		// The last removed line ends the file, there is no next line to end the region at.
+		last := r.Removed[len(r.Removed)-1]
+		deleted = sarifRegion{StartLine: r.OldStart, StartColumn: 1, EndLine: r.OldStart + len(r.Removed) - 1, EndColumn: len(utf16.Encode([]rune(last))) + 1}
+	default:
+		deleted = sarifRegion{StartLine: r.OldStart, StartColumn: 1, EndLine: r.OldStart + len(r.Removed), EndColumn: 1}
+	}
+
+	rep := sarifReplacement{DeletedRegion: deleted}
+	if len(r.Added) > 0 {
+		text := strings.Join(r.Added, "\n")
+		if !r.NewNoEOL {
+			text += "\n"
+		}
+		rep.InsertedContent = &sarifContent{Text: text}
+	}
+	return rep
+}
+
+func (s Step) writeSARIF(result Result) (string, error) {
+	dir, err := s.outputDir()
+	if err != nil {
+		return "", err
+	}
+	data, err := json.MarshalIndent(buildSARIF(result.Diff), "", "  ")
+	if err != nil {
+		return "", fmt.Errorf("marshal SARIF: %w", err)
+	}
+	path := filepath.Join(dir, sarifFileName)
+	if err := os.WriteFile(path, data, 0644); err != nil {
+		return "", fmt.Errorf("write SARIF: %w", err)
+	}
+	return path, nil
+}
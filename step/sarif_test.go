@@ -0,0 +1,74 @@
+package step
+
+import (
+	"encoding/json"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_buildSARIF(t *testing.T) {
+	diffs := parseUnifiedDiff(sampleDiff)
+
+	log := buildSARIF(diffs)
+
+	require.Len(t, log.Runs, 1)
+	assert.Equal(t, "2.1.0", log.Version)
+	results := log.Runs[0].Results
+	// main.go has two edits, the binary file is skipped, the rename, the new
+	// and the deleted file have one each, the mode change has none.
+	require.Len(t, results, 5)
+
+	first := results[0]
+	assert.Equal(t, sarifRuleID, first.RuleID)
+	loc := first.Locations[0].PhysicalLocation
+	assert.Equal(t, "main.go", loc.ArtifactLocation.URI)
+	assert.Equal(t, sarifRegion{StartLine: 2, EndLine: 2}, loc.Region)
+
+	rep := first.Fixes[0].ArtifactChanges[0].Replacements[0]
+	assert.Equal(t, sarifRegion{StartLine: 2, StartColumn: 1, EndLine: 3, EndColumn: 1}, rep.DeletedRegion)
+	require.NotNil(t, rep.InsertedContent)
+	assert.Equal(t, "func main() {\n\tfmt.Println(\"hi\")\n", rep.InsertedContent.Text)
+
+	renamed := results[2].Locations[0].PhysicalLocation.ArtifactLocation.URI
+	assert.Equal(t, "old name.txt", renamed, "regions of a renamed file have the lines of the old file")
+	assert.Equal(t, renamed, results[2].Fixes[0].ArtifactChanges[0].ArtifactLocation.URI)
+
+	newFile := results[3]
+	assert.Equal(t, "gen/new.go", newFile.Locations[0].PhysicalLocation.ArtifactLocation.URI)
+	assert.Equal(t, sarifRegion{StartLine: 1, StartColumn: 1, EndLine: 1, EndColumn: 1}, newFile.Fixes[0].ArtifactChanges[0].Replacements[0].DeletedRegion)
+
+	deleted := results[4].Fixes[0].ArtifactChanges[0].Replacements[0]
+	assert.Nil(t, deleted.InsertedContent)
+}
+
+func Test_replacement_NoEOL(t *testing.T) {
+	rep := replacement(changeRegion{OldStart: 3, Removed: []string{"last"}, Added: []string{"last"}, OldNoEOL: true})
+
+	assert.Equal(t, sarifRegion{StartLine: 3, StartColumn: 1, EndLine: 3, EndColumn: 5}, rep.DeletedRegion)
+	assert.Equal(t, "last\n", rep.InsertedContent.Text)
+}
+
+func Test_replacement_NoEOLColumnsInUTF16(t *testing.T) {
+	// "é" is 2 bytes in UTF-8 but 1 code unit in UTF-16, "😀" is 4 bytes and 2 code units.
+	rep := replacement(changeRegion{OldStart: 1, Removed: []string{"café 😀"}, Added: []string{"cafe"}, OldNoEOL: true})
+
+	assert.Equal(t, sarifRegion{StartLine: 1, StartColumn: 1, EndLine: 1, EndColumn: 8}, rep.DeletedRegion)
+}
+
+func Test_writeSARIF_NoChanges(t *testing.T) {
+	s := Step{envRepo: fakeEnvRepo{"BITRISE_DEPLOY_DIR": t.TempDir()}}
+
+	path, err := s.writeSARIF(Result{Outcome: OutcomeNoChanges})
+	require.NoError(t, err)
+
+	data, err := os.ReadFile(path)
+	require.NoError(t, err)
+	var log map[string]any
+	require.NoError(t, json.Unmarshal(data, &log))
+	run := log["runs"].([]any)[0].(map[string]any)
+	assert.Equal(t, []any{}, run["results"])
+	assert.Equal(t, "utf16CodeUnits", run["columnKind"])
+}
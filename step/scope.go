@@ -0,0 +1,230 @@
+package step
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/bitrise-io/go-utils/v2/command"
+)
+
+// FixScope selects which of the detected changes may be committed, relative
+// to what the PR itself changes.
+type FixScope string
+
+const (
+	ScopeAll     FixScope = "all"
+	ScopePRFiles FixScope = "pr_files"
+	ScopePRHunks FixScope = "pr_hunks"
+)
+
+const scopeExcludedReason = "not changed by the PR"
+
+// gitPRDiff returns the zero-context diff of the PR: the merge ref against its
+// first parent, the base branch. Renames show up as a deletion and an addition,
+// so every line of a renamed file counts as changed by the PR.
+func (s Step) gitPRDiff() ([]FileDiff, error) {
+	if _, err := s.commandFactory.Create("git", []string{"rev-parse", "--verify", "-q", "HEAD^2"}, nil).RunAndReturnTrimmedOutput(); err != nil {
+		return nil, fmt.Errorf("HEAD is not a PR merge commit with both parents available: check out the PR's merge ref with a clone depth of at least 2")
+	}
+	var outBuf bytes.Buffer
+	args := []string{"-c", "core.quotePath=false", "diff", "-U0", "--no-color", "--no-ext-diff", "--no-textconv", "--no-renames", "HEAD^1", "HEAD"}
+	if err := s.commandFactory.Create("git", args, &command.Opts{Stdout: &outBuf}).Run(); err != nil {
+		return nil, fmt.Errorf("diff the PR: %w", err)
+	}
+	return parseUnifiedDiff(outBuf.String()), nil
+}
+
+// gitPRChangedFiles lists the files the PR changes, except deleted ones.
+func (s Step) gitPRChangedFiles() ([]string, error) {
+	prDiff, err := s.gitPRDiff()
+	if err != nil {
+		return nil, err
+	}
+	var files []string
+	for _, d := range prDiff {
+		if !d.Deleted {
+			files = append(files, d.Path)
+		}
+	}
+	return files, nil
+}
+
+// scopeToPR drops the changes outside the PR's own changes, per file or per
+// hunk, and records them as excluded. Dropped hunks are reverted in the working
+// tree right away, the returned func restores them.
+func (s Step) scopeToPR(changes []FileStatus, scope FixScope, result *Result) ([]FileStatus, func() error, error) {
+	prDiff, err := s.gitPRDiff()
+	if err != nil {
+		return nil, nil, err
+	}
+	changes, excluded := scopeChanges(changes, prDiff)
+	result.Excluded = append(result.Excluded, excluded...)
+	if scope != ScopePRHunks {
+		return changes, func() error { return nil }, nil
+	}
+
+	changes, excluded, restore, err := s.dropHunksOutsidePR(changes, prDiff)
+	if err != nil {
+		return nil, nil, err
+	}
+	result.Excluded = append(result.Excluded, excluded...)
+	return changes, restore, nil
+}
+
+// scopeChanges keeps the changes of files the PR changed. Renames are kept if
+// the PR changed either path.
+func scopeChanges(changes []FileStatus, prDiff []FileDiff) ([]FileStatus, []ExcludedFile) {
+	inPR := map[string]bool{}
+	for _, d := range prDiff {
+		inPR[d.Path] = true
+	}
+	var kept []FileStatus
+	var excluded []ExcludedFile
+	for _, f := range changes {
+		if inPR[f.Path] || (f.OldPath != "" && inPR[f.OldPath]) {
+			kept = append(kept, f)
+		} else {
+			excluded = append(excluded, ExcludedFile{FileStatus: f, Reason: scopeExcludedReason})
+		}
+	}
+	return kept, excluded
+}
+
+// lineSpan is a closed range of line numbers touched by a zero-context hunk.
+type lineSpan struct{ first, last int }
+
+// hunkSpan returns the lines a hunk touches on one side. A hunk without lines
+// on that side sits between two lines, it touches both of them.
+func hunkSpan(start, count int) lineSpan {
+	if count == 0 {
+		return lineSpan{start, start + 1}
+	}
+	return lineSpan{start, start + count - 1}
+}
+
+func (a lineSpan) overlaps(b lineSpan) bool {
+	return a.first <= b.last && b.first <= a.last
+}
+
+// splitHunksByPR splits the zero-context autofix hunks of a file into the ones
+// touching lines the PR changed and the ones that don't. The autofix diff is
+// against HEAD, which is the new side of the PR diff.
+func splitHunksByPR(hunks []Hunk, prHunks []Hunk) (in, out []Hunk) {
+	for _, h := range hunks {
+		span := hunkSpan(h.OldStart, h.OldLines)
+		touched := false
+		for _, p := range prHunks {
+			if span.overlaps(hunkSpan(p.NewStart, p.NewLines)) {
+				touched = true
+				break
+			}
+		}
+		if touched {
+			in = append(in, h)
+		} else {
+			out = append(out, h)
+		}
+	}
+	return in, out
+}
+
+// dropHunksOutsidePR reverts the hunks of modified files that don't touch lines
+// changed by the PR, in the working tree. Files left without any change are
+// removed from the returned changes. The returned func restores the original
+// content of the touched files.
+func (s Step) dropHunksOutsidePR(changes []FileStatus, prDiff []FileDiff) ([]FileStatus, []ExcludedFile, func() error, error) {
+	prHunks := map[string][]Hunk{}
+	for _, d := range prDiff {
+		prHunks[d.Path] = d.Hunks
+	}
+
+	var candidates []string
+	for _, f := range changes {
+		// Only files the PR modified have lines to compare against; new files
+		// are the PR's entirely, renames and binary files are kept as a whole.
+		if f.Status == FileModified && !f.Submodule && len(prHunks[f.Path]) > 0 {
+			candidates = append(candidates, f.Path)
+		}
+	}
+	return s.revertHunks(changes, candidates, func(d FileDiff) (keep, revert []Hunk) {
+		return splitHunksByPR(d.Hunks, prHunks[d.Path])
+	}, scopeExcludedReason)
+}
+
+// revertHunks reverts, in the working tree, the hunks that split selects from
+// the zero-context diff of the candidate files. Files left without any change
+// are removed from the returned changes, and reason describes the reverted
+// hunks in the exclusions. The returned func restores the original content of
+// the touched files.
+func (s Step) revertHunks(changes []FileStatus, candidates []string, split func(FileDiff) (keep, revert []Hunk), reason string) ([]FileStatus, []ExcludedFile, func() error, error) {
+	noop := func() error { return nil }
+	if len(candidates) == 0 {
+		return changes, nil, noop, nil
+	}
+
+	var outBuf bytes.Buffer
+	args := []string{"-c", "core.quotePath=false", "--literal-pathspecs", "diff", "HEAD", "-U0", "--no-color", "--no-ext-diff", "--no-textconv", "--no-renames", "--"}
+	if err := s.commandFactory.Create("git", append(args, candidates...), &command.Opts{Stdout: &outBuf}).Run(); err != nil {
+		return nil, nil, nil, fmt.Errorf("diff changes: %w", err)
+	}
+
+	originals := map[string][]byte{}
+	restore := func() error {
+		for path, data := range originals {
+			if err := os.WriteFile(s.repoFile(path), data, 0644); err != nil {
+				return fmt.Errorf("restore %s: %w", path, err)
+			}
+		}
+		return nil
+	}
+
+	// Number of reverted hunks, and whether they were all of the file's hunks.
+	dropped := map[string]int{}
+	droppedAll := map[string]bool{}
+	var reverse strings.Builder
+	for _, d := range parseUnifiedDiff(outBuf.String()) {
+		if d.Binary {
+			continue
+		}
+		keep, revert := split(d)
+		if len(revert) == 0 {
+			continue
+		}
+		data, err := os.ReadFile(s.repoFile(d.Path))
+		if err != nil {
+			return nil, nil, nil, fmt.Errorf("read %s: %w", d.Path, err)
+		}
+		originals[d.Path] = data
+		d.Hunks = revert
+		reverse.WriteString(d.Patch())
+		dropped[d.Path] = len(revert)
+		droppedAll[d.Path] = len(keep) == 0
+	}
+	if reverse.Len() == 0 {
+		return changes, nil, noop, nil
+	}
+
+	cmd := s.commandFactory.Create("git", []string{"apply", "-R", "--unidiff-zero", "-"}, &command.Opts{Stdin: strings.NewReader(reverse.String())})
+	if out, err := cmd.RunAndReturnTrimmedCombinedOutput(); err != nil {
+		return nil, nil, nil, fmt.Errorf("revert hunks: %w\n%s", err, out)
+	}
+
+	var kept []FileStatus
+	var excluded []ExcludedFile
+	for _, f := range changes {
+		n, ok := dropped[f.Path]
+		switch {
+		case !ok:
+			kept = append(kept, f)
+		case droppedAll[f.Path]:
+			// The file is back to HEAD.
+			excluded = append(excluded, ExcludedFile{FileStatus: f, Reason: reason, Hunks: n})
+		default:
+			kept = append(kept, f)
+			excluded = append(excluded, ExcludedFile{FileStatus: f, Reason: fmt.Sprintf("%d hunk(s) %s", n, reason), Hunks: n})
+		}
+	}
+	return kept, excluded, restore, nil
+}
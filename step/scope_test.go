@@ -0,0 +1,59 @@
+package step
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_scopeChanges(t *testing.T) {
+	prDiff := []FileDiff{{Path: "feature.go"}, {Path: "renamed.go"}}
+	changes := []FileStatus{
+		{Path: "feature.go", Status: FileModified},
+		{Path: "base.go", Status: FileModified},
+		{Path: "moved.go", OldPath: "renamed.go", Status: FileRenamed},
+		{Path: "generated.go", Status: FileUntracked},
+	}
+
+	kept, excluded := scopeChanges(changes, prDiff)
+
+	assert.Equal(t, []FileStatus{changes[0], changes[2]}, kept)
+	assert.Equal(t, []ExcludedFile{
+		{FileStatus: changes[1], Reason: scopeExcludedReason},
+		{FileStatus: changes[3], Reason: scopeExcludedReason},
+	}, excluded)
+}
+
+func Test_splitHunksByPR(t *testing.T) {
+	// The PR changed line 10, inserted lines 20-21 and deleted the lines after line 30.
+	prHunks := []Hunk{
+		{NewStart: 10, NewLines: 1},
+		{NewStart: 20, NewLines: 2},
+		{NewStart: 30, NewLines: 0},
+	}
+	tests := []struct {
+		name   string
+		hunk   Hunk
+		inside bool
+	}{
+		{name: "same line", hunk: Hunk{OldStart: 10, OldLines: 1}, inside: true},
+		{name: "adjacent line", hunk: Hunk{OldStart: 11, OldLines: 1}, inside: false},
+		{name: "overlapping range", hunk: Hunk{OldStart: 18, OldLines: 3}, inside: true},
+		{name: "insertion after a changed line", hunk: Hunk{OldStart: 21, OldLines: 0}, inside: true},
+		{name: "insertion elsewhere", hunk: Hunk{OldStart: 15, OldLines: 0}, inside: false},
+		{name: "line next to a PR deletion", hunk: Hunk{OldStart: 31, OldLines: 1}, inside: true},
+		{name: "unrelated line", hunk: Hunk{OldStart: 40, OldLines: 2}, inside: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			in, out := splitHunksByPR([]Hunk{tt.hunk}, prHunks)
+			if tt.inside {
+				assert.Equal(t, []Hunk{tt.hunk}, in)
+				assert.Empty(t, out)
+			} else {
+				assert.Empty(t, in)
+				assert.Equal(t, []Hunk{tt.hunk}, out)
+			}
+		})
+	}
+}
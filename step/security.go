@@ -6,11 +6,24 @@ import (
 	"strings"
 )
 
+// checkForProtectedPaths aborts if any changed file matches a protected path glob.
+// Like checkForCIConfigChanges, it checks both sides of renames.
+func checkForProtectedPaths(changedFiles []string, patterns []string) error {
+	for _, f := range changedFiles {
+		for _, part := range strings.SplitN(f, " -> ", 2) {
+			if pattern, ok := matchAnyGlob(patterns, part); ok {
+				return fmt.Errorf("changed files include protected path %q (matches %q) — refusing to auto-commit", part, pattern)
+			}
+		}
+	}
+	return nil
+}
+
 // checkForCIConfigChanges aborts if any changed file touches Bitrise CI config,
 // to prevent a malicious PR from sneaking CI config changes through autofix.
 func checkForCIConfigChanges(changedFiles []string) error {
 	for _, f := range changedFiles {
-		// Rename entries from git status --porcelain look like "ORIG_PATH -> NEW_PATH";
+		// Rename entries look like "ORIG_PATH -> NEW_PATH" (see FileStatus.String);
 		// check each side independently so neither endpoint can bypass the block.
 		for _, part := range strings.SplitN(f, " -> ", 2) {
 			base := filepath.Base(part)
@@ -76,3 +76,40 @@ func Test_checkForCIConfigChanges(t *testing.T) {
 		})
 	}
 }
+
+func Test_checkForProtectedPaths(t *testing.T) {
+	patterns := []string{"*.lock", ".autofix.yml"}
+
+	tests := []struct {
+		name         string
+		changedFiles []string
+		wantErr      bool
+	}{
+		{
+			name:         "no protected changes",
+			changedFiles: []string{"main.go", "docs/README.md"},
+			wantErr:      false,
+		},
+		{
+			name:         "protected file changed",
+			changedFiles: []string{"main.go", "ios/Podfile.lock"},
+			wantErr:      true,
+		},
+		{
+			name:         "renamed away from a protected path",
+			changedFiles: []string{".autofix.yml -> autofix.yml"},
+			wantErr:      true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := checkForProtectedPaths(tt.changedFiles, patterns)
+			if tt.wantErr {
+				require.Error(t, err)
+			} else {
+				assert.NoError(t, err)
+			}
+		})
+	}
+}
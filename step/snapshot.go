@@ -0,0 +1,214 @@
+package step
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/bitrise-io/go-utils/v2/command"
+)
+
+// Mode selects what a step instance does.
+type Mode string
+
+const (
+	// ModeRun detects, commits and pushes the changes.
+	ModeRun Mode = "run"
+	// ModeSnapshot records the working tree state that later runs should ignore.
+	ModeSnapshot Mode = "snapshot"
+)
+
+const (
+	snapshotFileName       = "autofix-snapshot.json"
+	snapshotExcludedReason = "unchanged since the snapshot"
+)
+
+// snapshotManifest is the working tree state recorded by the snapshot mode.
+type snapshotManifest struct {
+	HeadSHA string `json:"head_sha"`
+	// Files maps every path that differed from HEAD to the blob of its content,
+	// which is written to the object database, or to "" if it was deleted.
+	Files map[string]string `json:"files"`
+}
+
+// takeSnapshot records every file that differs from HEAD. The contents are
+// stored as git blobs, so a later run can tell the changes made since apart
+// from the ones that were already there.
+func (s Step) takeSnapshot() (string, error) {
+	headSHA, err := s.gitHeadSHA()
+	if err != nil {
+		return "", fmt.Errorf("resolve HEAD: %w", err)
+	}
+	changes, err := s.getChangedFiles(true)
+	if err != nil {
+		return "", fmt.Errorf("detect changes: %w", err)
+	}
+
+	manifest := snapshotManifest{HeadSHA: headSHA, Files: map[string]string{}}
+	for _, f := range changes {
+		if f.OldPath != "" {
+			manifest.Files[f.OldPath] = ""
+		}
+		blob, err := s.gitHashObject(f.Path, true)
+		if err != nil {
+			return "", err
+		}
+		manifest.Files[f.Path] = blob
+	}
+
+	data, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return "", fmt.Errorf("marshal snapshot: %w", err)
+	}
+	path := filepath.Join(os.TempDir(), snapshotFileName)
+	if err := os.WriteFile(path, data, 0644); err != nil {
+		return "", fmt.Errorf("write snapshot: %w", err)
+	}
+	s.logger.Infof("Recorded %d changed file(s) in the snapshot, later runs will ignore these changes", len(manifest.Files))
+	return path, nil
+}
+
+func readSnapshot(path string) (snapshotManifest, error) {
+	var manifest snapshotManifest
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return manifest, fmt.Errorf("read snapshot: %w", err)
+	}
+	if err := json.Unmarshal(data, &manifest); err != nil {
+		return manifest, fmt.Errorf("parse snapshot %s: %w", path, err)
+	}
+	return manifest, nil
+}
+
+// gitHashObject returns the blob SHA of a file's content, "" if it doesn't exist.
+// With write, the blob is also stored in the object database.
+func (s Step) gitHashObject(path string, write bool) (string, error) {
+	info, err := os.Lstat(s.repoFile(path))
+	if os.IsNotExist(err) {
+		return "", nil
+	}
+	if err != nil {
+		return "", err
+	}
+	if info.IsDir() {
+		// Submodules have no content of their own to compare.
+		return "", nil
+	}
+	args := []string{"hash-object", "--no-filters"}
+	if write {
+		args = append(args, "-w")
+	}
+	out, err := s.commandFactory.Create("git", append(args, "--", path), nil).RunAndReturnTrimmedCombinedOutput()
+	if err != nil {
+		return "", fmt.Errorf("hash %s: %w\n%s", path, err, out)
+	}
+	return out, nil
+}
+
+// applySnapshot leaves out what changed before the snapshot was taken. Files
+// that are the same as in the snapshot are excluded. Tracked files that changed
+// both before and after it get the earlier changes undone in the working tree,
+// with a 3-way merge of the current content, the snapshot and HEAD. The
+// returned func restores the original content of the merged files.
+func (s Step) applySnapshot(manifest snapshotManifest, headSHA string, changes []FileStatus) ([]FileStatus, []ExcludedFile, func() error, error) {
+	if manifest.HeadSHA != headSHA {
+		return nil, nil, nil, fmt.Errorf("the snapshot was taken at commit %s, but HEAD is %s now", manifest.HeadSHA, headSHA)
+	}
+
+	originals := map[string][]byte{}
+	restore := func() error {
+		for path, data := range originals {
+			if err := os.WriteFile(s.repoFile(path), data, 0644); err != nil {
+				return fmt.Errorf("restore %s: %w", path, err)
+			}
+		}
+		return nil
+	}
+
+	var kept []FileStatus
+	var excluded []ExcludedFile
+	for _, f := range changes {
+		snapshotBlob, ok := manifest.Files[f.Path]
+		if !ok || f.Submodule {
+			kept = append(kept, f)
+			continue
+		}
+		currentBlob, err := s.gitHashObject(f.Path, false)
+		if err != nil {
+			return nil, nil, nil, err
+		}
+		if currentBlob == snapshotBlob {
+			excluded = append(excluded, ExcludedFile{FileStatus: f, Reason: snapshotExcludedReason})
+			continue
+		}
+		if snapshotBlob == "" || currentBlob == "" || f.Status == FileUntracked {
+			// Created or deleted since the snapshot, or not in HEAD to merge with:
+			// the whole file is a change of its own.
+			kept = append(kept, f)
+			continue
+		}
+
+		merged, unchanged, err := s.withoutSnapshotChanges(f.Path, snapshotBlob)
+		if err != nil {
+			s.logger.Warnf("Cannot separate the changes of %s made before the snapshot, committing all of them: %s", f.Path, err)
+			kept = append(kept, f)
+			continue
+		}
+		original, err := os.ReadFile(s.repoFile(f.Path))
+		if err != nil {
+			return nil, nil, nil, fmt.Errorf("read %s: %w", f.Path, err)
+		}
+		originals[f.Path] = original
+		if err := os.WriteFile(s.repoFile(f.Path), merged, 0644); err != nil {
+			return nil, nil, nil, fmt.Errorf("write %s: %w", f.Path, err)
+		}
+		if unchanged {
+			excluded = append(excluded, ExcludedFile{FileStatus: f, Reason: snapshotExcludedReason})
+			continue
+		}
+		s.logger.Debugf("Left out the changes of %s made before the snapshot", f.Path)
+		kept = append(kept, f)
+	}
+	return kept, excluded, restore, nil
+}
+
+// withoutSnapshotChanges returns the current content of the file without the
+// changes it had at the time of the snapshot: merging the snapshot -> HEAD
+// changes into the current content reverts them. unchanged reports whether
+// nothing is left but the HEAD content.
+func (s Step) withoutSnapshotChanges(path, snapshotBlob string) (content []byte, unchanged bool, err error) {
+	dir, err := os.MkdirTemp("", "autofix-snapshot-merge")
+	if err != nil {
+		return nil, false, err
+	}
+	defer os.RemoveAll(dir)
+
+	snapshotFile := filepath.Join(dir, "snapshot")
+	headFile := filepath.Join(dir, "head")
+	var head []byte
+	for file, object := range map[string]string{snapshotFile: snapshotBlob, headFile: "HEAD:" + path} {
+		var outBuf bytes.Buffer
+		if err := s.commandFactory.Create("git", []string{"cat-file", "blob", object}, &command.Opts{Stdout: &outBuf}).Run(); err != nil {
+			return nil, false, fmt.Errorf("read %s: %w", object, err)
+		}
+		if err := os.WriteFile(file, outBuf.Bytes(), 0644); err != nil {
+			return nil, false, err
+		}
+		if file == headFile {
+			head = outBuf.Bytes()
+		}
+	}
+
+	var outBuf bytes.Buffer
+	// merge-file exits with the number of conflicts, negative on errors.
+	exitCode, err := s.commandFactory.Create("git", []string{"merge-file", "-p", "--", path, snapshotFile, headFile}, &command.Opts{Stdout: &outBuf}).RunAndReturnExitCode()
+	if exitCode > 0 {
+		return nil, false, fmt.Errorf("the changes before and after the snapshot overlap")
+	}
+	if err != nil {
+		return nil, false, fmt.Errorf("git merge-file: %w", err)
+	}
+	return outBuf.Bytes(), bytes.Equal(outBuf.Bytes(), head), nil
+}
@@ -0,0 +1,204 @@
+package step
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_readSnapshot(t *testing.T) {
+	path := filepath.Join(t.TempDir(), snapshotFileName)
+	require.NoError(t, os.WriteFile(path, []byte(`{"head_sha": "abc", "files": {"deps.lock": "e69de29", "removed.txt": ""}}`), 0644))
+
+	manifest, err := readSnapshot(path)
+
+	require.NoError(t, err)
+	assert.Equal(t, snapshotManifest{HeadSHA: "abc", Files: map[string]string{"deps.lock": "e69de29", "removed.txt": ""}}, manifest)
+
+	require.NoError(t, os.WriteFile(path, []byte("not json"), 0644))
+	_, err = readSnapshot(path)
+	assert.Error(t, err)
+
+	_, err = readSnapshot(filepath.Join(t.TempDir(), "missing.json"))
+	assert.Error(t, err)
+}
+
+func Test_applySnapshot(t *testing.T) {
+	head := map[string]string{
+		"same.txt":    "a\n",
+		"both.txt":    "1\n2\n3\n4\n5\n",
+		"overlap.txt": "x\n",
+		"later.txt":   "l\n",
+		"old.txt":     "renamed\n",
+		"moved.txt":   "moved\n",
+	}
+	tests := []struct {
+		name string
+		// before are the changes made before the snapshot, nil content deletes the file.
+		before map[string]*string
+		// after are the changes made after it.
+		after        map[string]*string
+		change       FileStatus
+		wantKept     bool
+		wantContent  *string
+		wantRestored string
+	}{
+		{
+			name:     "unchanged since the snapshot",
+			before:   map[string]*string{"same.txt": ptr("b\n")},
+			change:   FileStatus{Path: "same.txt", Status: FileModified},
+			wantKept: false,
+		},
+		{
+			name:     "changed after the snapshot only",
+			after:    map[string]*string{"later.txt": ptr("L\n")},
+			change:   FileStatus{Path: "later.txt", Status: FileModified},
+			wantKept: true,
+		},
+		{
+			name:         "changed before and after the snapshot",
+			before:       map[string]*string{"both.txt": ptr("one\n2\n3\n4\n5\n")},
+			after:        map[string]*string{"both.txt": ptr("one\n2\n3\n4\nfive\n")},
+			change:       FileStatus{Path: "both.txt", Status: FileModified},
+			wantKept:     true,
+			wantContent:  ptr("1\n2\n3\n4\nfive\n"),
+			wantRestored: "one\n2\n3\n4\nfive\n",
+		},
+		{
+			name:        "overlapping changes are committed as they are",
+			before:      map[string]*string{"overlap.txt": ptr("y\n")},
+			after:       map[string]*string{"overlap.txt": ptr("z\n")},
+			change:      FileStatus{Path: "overlap.txt", Status: FileModified},
+			wantKept:    true,
+			wantContent: ptr("z\n"),
+		},
+		{
+			name:     "deleted after the snapshot",
+			before:   map[string]*string{"same.txt": ptr("b\n")},
+			after:    map[string]*string{"same.txt": nil},
+			change:   FileStatus{Path: "same.txt", Status: FileDeleted},
+			wantKept: true,
+		},
+		{
+			name:     "renamed before the snapshot",
+			before:   map[string]*string{"old.txt": nil, "new.txt": ptr("renamed\n")},
+			change:   FileStatus{Path: "new.txt", OldPath: "old.txt", Status: FileRenamed},
+			wantKept: false,
+		},
+		{
+			name:     "renamed after the snapshot",
+			after:    map[string]*string{"moved.txt": nil, "moved-new.txt": ptr("moved\n")},
+			change:   FileStatus{Path: "moved-new.txt", OldPath: "moved.txt", Status: FileRenamed},
+			wantKept: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s, headSHA := gitTestRepo(t, head)
+			apply := func(changes map[string]*string) {
+				for path, content := range changes {
+					if content == nil {
+						require.NoError(t, os.Remove(s.repoFile(path)))
+					} else {
+						require.NoError(t, os.WriteFile(s.repoFile(path), []byte(*content), 0644))
+					}
+				}
+			}
+			apply(tt.before)
+			manifest := snapshotManifest{HeadSHA: headSHA, Files: map[string]string{}}
+			for path := range tt.before {
+				blob, err := s.gitHashObject(path, true)
+				require.NoError(t, err)
+				manifest.Files[path] = blob
+			}
+			apply(tt.after)
+
+			kept, excluded, restore, err := s.applySnapshot(manifest, headSHA, []FileStatus{tt.change})
+
+			require.NoError(t, err)
+			if tt.wantKept {
+				assert.Equal(t, []FileStatus{tt.change}, kept)
+				assert.Empty(t, excluded)
+			} else {
+				assert.Empty(t, kept)
+				assert.Equal(t, []ExcludedFile{{FileStatus: tt.change, Reason: snapshotExcludedReason}}, excluded)
+			}
+			if tt.wantContent != nil {
+				data, err := os.ReadFile(s.repoFile(tt.change.Path))
+				require.NoError(t, err)
+				assert.Equal(t, *tt.wantContent, string(data))
+			}
+			require.NoError(t, restore())
+			if tt.wantRestored != "" {
+				data, err := os.ReadFile(s.repoFile(tt.change.Path))
+				require.NoError(t, err)
+				assert.Equal(t, tt.wantRestored, string(data))
+			}
+		})
+	}
+}
+
+func Test_applySnapshot_otherHead(t *testing.T) {
+	s, _ := gitTestRepo(t, map[string]string{"a.txt": "a\n"})
+
+	_, _, _, err := s.applySnapshot(snapshotManifest{HeadSHA: "abc"}, "def", nil)
+
+	assert.EqualError(t, err, "the snapshot was taken at commit abc, but HEAD is def now")
+}
+
+func Test_withoutSnapshotChanges(t *testing.T) {
+	tests := []struct {
+		name          string
+		snapshot      string
+		current       string
+		want          string
+		wantUnchanged bool
+		wantErr       string
+	}{
+		{
+			name:     "changes after the snapshot are kept",
+			snapshot: "one\n2\n3\n4\n5\n",
+			current:  "one\n2\n3\n4\nfive\n",
+			want:     "1\n2\n3\n4\nfive\n",
+		},
+		{
+			name:          "only the changes of the snapshot",
+			snapshot:      "one\n2\n3\n4\n5\n",
+			current:       "one\n2\n3\n4\n5\n",
+			want:          "1\n2\n3\n4\n5\n",
+			wantUnchanged: true,
+		},
+		{
+			name:     "overlapping changes",
+			snapshot: "one\n2\n3\n4\n5\n",
+			current:  "uno\n2\n3\n4\n5\n",
+			wantErr:  "the changes before and after the snapshot overlap",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s, _ := gitTestRepo(t, map[string]string{"file.txt": "1\n2\n3\n4\n5\n"})
+			require.NoError(t, os.WriteFile(s.repoFile("file.txt"), []byte(tt.snapshot), 0644))
+			blob, err := s.gitHashObject("file.txt", true)
+			require.NoError(t, err)
+			require.NoError(t, os.WriteFile(s.repoFile("file.txt"), []byte(tt.current), 0644))
+
+			content, unchanged, err := s.withoutSnapshotChanges("file.txt", blob)
+
+			if tt.wantErr != "" {
+				assert.EqualError(t, err, tt.wantErr)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, string(content))
+			assert.Equal(t, tt.wantUnchanged, unchanged)
+		})
+	}
+}
+
+func ptr(s string) *string {
+	return &s
+}
@@ -1,7 +1,12 @@
 package step
 
 import (
+	"errors"
 	"fmt"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/bitrise-io/go-steputils/v2/stepconf"
 	"github.com/bitrise-io/go-utils/v2/command"
@@ -10,20 +15,106 @@ import (
 )
 
 type Input struct {
-	GitUsername      string `env:"git_username"`
-	GitToken         string `env:"git_token"`
-	GitRemoteURL     string `env:"git_remote_url"`
-	CommitSubject    string `env:"commit_subject,required"`
-	IncludeUntracked bool   `env:"include_untracked,required"`
-	DryRun           bool   `env:"dry_run,required"`
-	Verbose          bool   `env:"verbose,required"`
+	GitUsername       string          `env:"git_username"`
+	GitToken          string          `env:"git_token"`
+	RepoPath          string          `env:"repo_path"`
+	Pathspec          []string        `env:"pathspec,multiline"`
+	Repositories      []string        `env:"repositories,multiline"`
+	GitRemoteURL      string          `env:"git_remote_url"`
+	WebhookURL        stepconf.Secret `env:"webhook_url"`
+	WebhookSecret     stepconf.Secret `env:"webhook_secret"`
+	Mode              Mode            `env:"mode,opt[run,snapshot]"`
+	SnapshotPath      string          `env:"snapshot_path"`
+	ConfigFile        string          `env:"config_file"`
+	CommitSubject     string          `env:"commit_subject"`
+	CommitTemplate    string          `env:"commit_template"`
+	IncludePaths      []string        `env:"include_paths,multiline"`
+	ExcludePaths      []string        `env:"exclude_paths,multiline"`
+	ProtectedPaths    []string        `env:"protected_paths,multiline"`
+	MaxAutofixCommits *int            `env:"max_autofix_commits"`
+	FixCommands       []string        `env:"fix_commands,multiline"`
+	FixUntilStable    bool            `env:"fix_until_stable,required"`
+	FixMaxPasses      int             `env:"fix_max_passes,required"`
+	PreCommit         PreCommitMode   `env:"pre_commit,opt[off,all_files,pr_files]"`
+	Scope             FixScope        `env:"scope,opt[all,pr_files,pr_hunks]"`
+	ValidateCommands  []string        `env:"validate_commands,multiline"`
+	PreCommitCommand  string          `env:"pre_commit_command"`
+	PrePushCommand    string          `env:"pre_push_command"`
+	PostPushCommand   string          `env:"post_push_command"`
+	MaxFiles          int             `env:"max_files,required"`
+	MaxChangedLines   int             `env:"max_changed_lines,required"`
+	MaxFileSizeKB     int             `env:"max_file_size_kb,required"`
+	AllowBinaryFiles  bool            `env:"allow_binary_files,required"`
+	OnLimitExceeded   LimitAction     `env:"on_limit_exceeded,opt[fail,patch_only]"`
+	ExcludeNoise      bool            `env:"exclude_noise,required"`
+	IgnoreHunks       []string        `env:"ignore_hunk_patterns,multiline"`
+	IgnoreHunkMode    IgnoreHunkMode  `env:"ignore_hunk_mode,opt[file,hunk]"`
+	ValidateSyntax    bool            `env:"validate_syntax,required"`
+	Deletions         DeletionPolicy  `env:"deletions,opt[allow,deny,report_only]"`
+	DeletionAllowlist []string        `env:"deletion_allowlist,multiline"`
+	Submodules        SubmodulePolicy `env:"submodules,opt[ignore,refuse,recurse]"`
+	IncludeUntracked  bool            `env:"include_untracked,required"`
+	StepSummary       bool            `env:"step_summary,required"`
+	JUnitReport       bool            `env:"junit_report,required"`
+	SARIFReport       bool            `env:"sarif_report,required"`
+	DiffMaxLines      int             `env:"diff_max_lines"`
+	DiffMaxFileLines  int             `env:"diff_max_lines_per_file"`
+	CheckOnly         bool            `env:"check_only,required"`
+	OnPush            string          `env:"on_push,opt[fail,succeed,succeed_with_skip_ci]"`
+	DryRun            bool            `env:"dry_run,required"`
+	Verbose           bool            `env:"verbose,required"`
 }
 
 type Result struct {
-	AutofixNeeded bool
-	AutofixPushed bool
-	FileCount     int
-	DryRun        bool
+	Outcome       Outcome `json:"outcome"`
+	AutofixNeeded bool    `json:"autofix_needed"`
+	AutofixPushed bool    `json:"autofix_pushed"`
+	FileCount     int     `json:"file_count"`
+	DryRun        bool    `json:"dry_run"`
+	// OnPush is how the build should end after a successful push, only set when the step pushed.
+	OnPush OnPushMode `json:"on_push,omitempty"`
+	// SkipCI is set when the autofix commit asks CI not to build it.
+	SkipCI bool `json:"skip_ci"`
+	// Branch is the push target, empty if the run ended before it was resolved.
+	Branch string `json:"branch,omitempty"`
+	// HeadSHA is the commit the build was running on before the step made any changes.
+	HeadSHA string `json:"head_sha,omitempty"`
+	// CommitSHA is the autofix commit, set once it has been created. With commit
+	// groups it is the last one.
+	CommitSHA string `json:"commit_sha,omitempty"`
+	// Commits are the commits of each commit group, only set when there are groups.
+	Commits []AutofixCommit `json:"commits,omitempty"`
+	Files   []FileStatus    `json:"files"`
+	// Excluded are changes that were detected but left out of the commit by the
+	// policy, the submodule policy, the snapshot, the scope, the noise filter,
+	// the ignore patterns or the deletion policy.
+	Excluded []ExcludedFile `json:"excluded,omitempty"`
+	// LimitViolations are the size limits the changes crossed.
+	LimitViolations []LimitViolation `json:"limit_violations,omitempty"`
+	// InvalidFiles are the changed files that no longer parse or changed encoding.
+	InvalidFiles []InvalidFile `json:"invalid_files,omitempty"`
+	// LFSFiles are the changed files tracked by Git LFS.
+	LFSFiles []string `json:"lfs_files,omitempty"`
+	// Submodules are the autofix commits made in submodules.
+	Submodules []SubmoduleCommit `json:"submodules,omitempty"`
+	// Repositories are the results of each repository when the step ran in several.
+	Repositories []RepositoryResult `json:"repositories,omitempty"`
+	// PatchOnly is set when the changes crossed a limit and were only delivered as a patch.
+	PatchOnly bool          `json:"patch_only,omitempty"`
+	Timings   []PhaseTiming `json:"timings"`
+	Error     string        `json:"error,omitempty"`
+	// Diff is the parsed autofix diff, used to render the summary.
+	Diff []FileDiff `json:"-"`
+	// ReportPath is where the JSON report of this result was written.
+	ReportPath string `json:"-"`
+	// SummaryPath is where the Markdown summary of this result was written.
+	SummaryPath string `json:"-"`
+	// SARIFPath is where the SARIF report of the changes was written.
+	SARIFPath string `json:"-"`
+	// PatchPath is where the autofix diff was written as a git apply-able patch.
+	PatchPath string `json:"-"`
+	// SnapshotPath is where snapshot mode wrote the manifest of the working tree.
+	SnapshotPath string `json:"-"`
 }
 
 type Step struct {
@@ -31,6 +122,11 @@ type Step struct {
 	inputParser    stepconf.InputParser
 	commandFactory command.Factory
 	envRepo        env.Repository
+	// pathspec limits git status, add and commit to a part of the repository.
+	pathspec []string
+	// repoDir is the top level of the repository the step works in, empty for
+	// the working directory.
+	repoDir string
 }
 
 func New(
@@ -52,116 +148,586 @@ func (s Step) Run() (Result, error) {
 	if err := s.inputParser.Parse(&input); err != nil {
 		return Result{}, fmt.Errorf("parse inputs: %w", err)
 	}
+	// stepconf can't validate open-ended ranges, only ones with both limits.
+	if input.DiffMaxLines < 0 || input.DiffMaxFileLines < 0 {
+		return Result{}, fmt.Errorf("parse inputs: diff_max_lines and diff_max_lines_per_file must not be negative")
+	}
+	if input.MaxFiles < 0 || input.MaxChangedLines < 0 || input.MaxFileSizeKB < 0 {
+		return Result{}, fmt.Errorf("parse inputs: max_files, max_changed_lines and max_file_size_kb must not be negative")
+	}
+	if input.FixMaxPasses < 1 {
+		return Result{}, fmt.Errorf("parse inputs: fix_max_passes must be at least 1")
+	}
+	if input.FixUntilStable && input.FixMaxPasses < 2 {
+		// The last pass has to change nothing, so a single pass fails whenever the fix did anything.
+		return Result{}, fmt.Errorf("parse inputs: fix_max_passes must be at least 2 with fix_until_stable")
+	}
+	if input.MaxAutofixCommits != nil && *input.MaxAutofixCommits < 0 {
+		return Result{}, fmt.Errorf("parse inputs: max_autofix_commits must not be negative")
+	}
+	repositories, err := parseRepositories(input.Repositories)
+	if err != nil {
+		return Result{}, fmt.Errorf("parse inputs: %w", err)
+	}
+	if len(repositories) > 0 && (input.RepoPath != "" || input.Mode == ModeSnapshot) {
+		return Result{}, fmt.Errorf("parse inputs: repositories can't be combined with repo_path or snapshot mode")
+	}
+	if len(repositories) > 0 && input.SnapshotPath != "" {
+		// A manifest is taken of one repository, every other one would fail on its HEAD.
+		return Result{}, fmt.Errorf("parse inputs: repositories can't be combined with snapshot_path")
+	}
 	stepconf.Print(input)
 	s.logger.EnableDebugLog(input.Verbose)
 
-	if input.GitRemoteURL != "" {
-		if err := s.setRemoteURL(input.GitRemoteURL); err != nil {
-			return Result{}, fmt.Errorf("set remote URL: %w", err)
+	var result Result
+	if input.RepoPath != "" {
+		var repo Step
+		if repo, err = s.inRepo(input.RepoPath); err == nil {
+			s = repo
 		}
 	}
+	if err == nil {
+		s.pathspec = firstNonEmptyList(input.Pathspec)
 
-	remoteURL, err := s.getRemoteURL()
+		if input.Mode == ModeSnapshot {
+			s.logger.Println()
+			path, err := s.takeSnapshot()
+			if err != nil {
+				return Result{Outcome: OutcomeError, Error: err.Error()}, fmt.Errorf("take snapshot: %w", err)
+			}
+			return Result{Outcome: OutcomeSnapshot, SnapshotPath: path}, nil
+		}
+
+		if len(repositories) > 0 {
+			result, err = s.runRepositories(input, repositories)
+		} else {
+			result, err = s.run(input)
+		}
+	}
 	if err != nil {
-		return Result{}, fmt.Errorf("detect remote URL: %w", err)
+		if result.Outcome == "" {
+			result.Outcome = OutcomeError
+		}
+		result.Error = err.Error()
 	}
 
-	s.logger.Println()
-	useSSH := isSSHRemote(remoteURL)
-	if useSSH {
-		s.logger.Infof("Using SSH authentication (because of remote URL: %s)", remoteURL)
-		s.logger.Warnf("Warning: SSH keys usually only have read-only access to the repo. This use-case requires write access as well, so the push may fail.")
-		s.logger.Warnf("Consider switching to HTTPS authentication with a read-write token. To do so, set the git_token and git_remote_url inputs of this step and ensure the remote URL is an HTTPS one")
-	} else {
-		s.logger.Infof("Using HTTPS authentication (because of remote URL: %s)", remoteURL)
+	s.writeReports(input, &result)
+
+	if input.WebhookURL != "" {
+		s.notify(input, result)
 	}
-	s.logger.Println()
+	return result, err
+}
 
-	// The step.yml defaults expand $GIT_HTTP_USERNAME/$GIT_HTTP_PASSWORD before the binary runs,
-	// so these are already resolved by the time we get here.
-	// Username is optional: GitHub App installations provide only a short-lived token.
-	if !useSSH && input.GitToken == "" {
-		return Result{}, fmt.Errorf("git token is required for authentication: set git_token input or ensure $GIT_HTTP_PASSWORD is available in the environment")
+func (s Step) run(input Input) (Result, error) {
+	var result Result
+	var err error
+
+	setupStart := time.Now()
+	// Check-only mode never talks to the remote, so it needs no credentials.
+	if !input.CheckOnly {
+		if err := s.setupRemote(input); err != nil {
+			return result, err
+		}
 	}
 
 	gitBranch := s.envRepo.Get("BITRISE_GIT_BRANCH")
+	result.recordPhase("setup", setupStart)
 
-	if !s.isPRBuild() {
+	// Check-only mode doesn't push, so it is also useful outside of PRs,
+	// e.g. to guard protected branches.
+	if !input.CheckOnly && !s.isPRBuild() {
 		s.logger.Println()
 		s.logger.Infof("Skipping: this step is intended for PR builds only (BITRISE_PULL_REQUEST is not set).")
-		return Result{}, nil
+		result.Outcome = OutcomeNotPR
+		return result, nil
 	}
 
-	if s.isForkPR() {
+	if !input.CheckOnly && s.isForkPR() {
 		s.logger.Println()
 		s.logger.Infof("Skipping: this build is for a fork PR. Autofix cannot push to a forked repository.")
-		return Result{}, nil
+		result.Outcome = OutcomeFork
+		return result, nil
 	}
 
-	changedFiles, err := s.getChangedFiles(input.IncludeUntracked)
+	configStart := time.Now()
+	policy, err := s.loadPolicy(input)
+	if err != nil {
+		return result, fmt.Errorf("load config: %w", err)
+	}
+	ignorePatterns, err := compileHunkPatterns(firstNonEmptyList(input.IgnoreHunks))
 	if err != nil {
-		return Result{}, fmt.Errorf("detect changes: %w", err)
+		return result, err
 	}
+	result.recordPhase("config", configStart)
 
-	if len(changedFiles) == 0 {
+	var attribution map[string][]string
+	if fixCommands := parseFixCommands(input.FixCommands); len(fixCommands) > 0 {
+		fixStart := time.Now()
+		if attribution, err = s.runFixCommands(fixCommands, input.FixUntilStable, input.FixMaxPasses); err != nil {
+			result.Outcome = OutcomeFixFailed
+			return result, err
+		}
+		result.recordPhase("fix", fixStart)
+	}
+
+	var toolSummary []string
+	if input.PreCommit != PreCommitOff {
+		preCommitStart := time.Now()
+		preCommitAttribution, summary, err := s.runPreCommit(input.PreCommit, input.GitUsername, input.GitToken, input.CheckOnly)
+		if err != nil {
+			result.Outcome = OutcomeFixFailed
+			return result, fmt.Errorf("pre-commit: %w", err)
+		}
+		if attribution == nil {
+			attribution = map[string][]string{}
+		}
+		for path, tools := range preCommitAttribution {
+			for _, t := range tools {
+				attribution[path] = appendUnique(attribution[path], t)
+			}
+		}
+		toolSummary = summary
+		result.recordPhase("pre-commit", preCommitStart)
+	}
+
+	detectStart := time.Now()
+	// Recorded before any changes are committed so reports can refer to the
+	// commit the build was started on. Not fatal: it's only informational.
+	if result.HeadSHA, err = s.gitHeadSHA(); err != nil {
+		s.logger.Debugf("Failed to resolve HEAD: %s", err)
+	}
+
+	changes, err := s.getChangedFiles(input.IncludeUntracked)
+	if err != nil {
+		return result, fmt.Errorf("detect changes: %w", err)
+	}
+	if err := s.markSubmodules(changes); err != nil {
+		return result, err
+	}
+	result.recordPhase("detect", detectStart)
+
+	changes, result.Excluded = filterChanges(changes, policy)
+	var submoduleBranches map[string]string
+	var excludedSubmodules []ExcludedFile
+	if changes, excludedSubmodules, submoduleBranches, err = s.applySubmodulePolicy(changes, input, gitBranch); err != nil {
+		if errors.Is(err, errSubmodulesRefused) {
+			result.Outcome = OutcomeSubmoduleBlocked
+		}
+		return result, err
+	}
+	result.Excluded = append(result.Excluded, excludedSubmodules...)
+	// Leaving out part of a file's changes rewrites it in the working tree.
+	// Check-only mode puts everything back the way the previous steps left it.
+	var restores []func() error
+	if input.CheckOnly {
+		defer func() {
+			for i := len(restores) - 1; i >= 0; i-- {
+				if err := restores[i](); err != nil {
+					s.logger.Warnf("Failed to restore the working tree: %s", err)
+				}
+			}
+		}()
+	}
+	if input.SnapshotPath != "" {
+		manifest, err := readSnapshot(input.SnapshotPath)
+		if err != nil {
+			return result, err
+		}
+		var excluded []ExcludedFile
+		var restore func() error
+		if changes, excluded, restore, err = s.applySnapshot(manifest, result.HeadSHA, changes); err != nil {
+			return result, fmt.Errorf("apply snapshot: %w", err)
+		}
+		restores = append(restores, restore)
+		result.Excluded = append(result.Excluded, excluded...)
+	}
+	if input.Scope != ScopeAll {
+		var restore func() error
+		if changes, restore, err = s.scopeToPR(changes, input.Scope, &result); err != nil {
+			return result, fmt.Errorf("scope changes to the PR: %w", err)
+		}
+		restores = append(restores, restore)
+	}
+	if input.ExcludeNoise {
+		var noise []ExcludedFile
+		if changes, noise, err = s.excludeNoise(changes); err != nil {
+			return result, fmt.Errorf("exclude noise changes: %w", err)
+		}
+		result.Excluded = append(result.Excluded, noise...)
+	}
+	if len(ignorePatterns) > 0 {
+		var ignored []ExcludedFile
+		var restore func() error
+		if changes, ignored, restore, err = s.dropIgnoredHunks(changes, ignorePatterns, input.IgnoreHunkMode); err != nil {
+			return result, fmt.Errorf("revert ignored hunks: %w", err)
+		}
+		restores = append(restores, restore)
+		result.Excluded = append(result.Excluded, ignored...)
+	}
+	var notAllowed []ExcludedFile
+	if changes, notAllowed, err = s.checkDeletions(changes, input.Deletions, firstNonEmptyList(input.DeletionAllowlist)); err != nil {
+		result.Outcome = OutcomeDeletionBlocked
+		return result, err
+	}
+	result.Excluded = append(result.Excluded, notAllowed...)
+	if len(result.Excluded) > 0 {
+		s.logger.Println()
+		s.logger.Infof("Excluded %d changed file(s) from the autofix commit:", len(result.Excluded))
+		for _, f := range result.Excluded {
+			s.logger.Printf("  %s (%s)", f.String(), f.Reason)
+		}
+	}
+
+	if len(changes) == 0 {
 		s.logger.Println()
-		if !input.IncludeUntracked {
+		if len(result.Excluded) > 0 {
+			s.logger.Infof("No changes left after the exclusions, nothing to commit.")
+		} else if !input.IncludeUntracked {
 			s.logger.Infof("No changes detected, nothing to commit. (untracked files are not included, see the include_untracked input)")
 		} else {
 			s.logger.Infof("No changes detected, nothing to commit.")
 		}
-		return Result{}, nil
+		result.Outcome = OutcomeNoChanges
+		return result, nil
 	}
 
+	changedFiles := filePaths(changes)
 	s.logger.Println()
 	s.logger.Infof("Detected %d changed file(s):", len(changedFiles))
 	for _, f := range changedFiles {
 		s.logger.Printf("  %s", f)
 	}
 
+	result.AutofixNeeded = true
+	result.Branch = gitBranch
+	result.Files = changes
+	annotateTools(result.Files, attribution)
+
+	var patch string
+	if result.Diff, patch, err = s.getAutofixDiff(changes); err != nil {
+		return result, fmt.Errorf("compute diff: %w", err)
+	}
+	annotateDiffStats(result.Files, result.Diff)
+	s.logDiff(result.Diff, input.DiffMaxFileLines, input.DiffMaxLines)
+
+	if result.PatchPath, err = s.writePatch(patch); err != nil {
+		// The patch is a convenience for reproducing the fix, not needed for committing it.
+		s.logger.Warnf("Failed to write patch: %s", err)
+	}
+
 	if err := checkForCIConfigChanges(changedFiles); err != nil {
-		return Result{AutofixNeeded: true}, fmt.Errorf("security check failed: %w", err)
+		result.Outcome = OutcomeSecurityBlocked
+		return result, fmt.Errorf("security check failed: %w", err)
+	}
+	if err := checkForProtectedPaths(changedFiles, policy.ProtectedPaths); err != nil {
+		result.Outcome = OutcomeSecurityBlocked
+		return result, fmt.Errorf("security check failed: %w", err)
+	}
+
+	if input.CheckOnly {
+		s.logger.Println()
+		s.logger.Errorf("Check failed: %d file(s) are not up to date with the formatters, linters and generators of this workflow.", len(changedFiles))
+		s.logger.Errorf("Run them locally and commit the result.")
+		if result.PatchPath != "" {
+			s.logger.Errorf("Alternatively, download the patch from the build artifacts and apply it with: git apply %s", filepath.Base(result.PatchPath))
+		}
+		result.Outcome = OutcomeCheckFailed
+		result.FileCount = len(changedFiles)
+		return result, nil
+	}
+
+	sizes, err := s.fileSizes(changes)
+	if err != nil {
+		return result, fmt.Errorf("check change limits: %w", err)
+	}
+	result.LimitViolations = checkLimits(result.Files, sizes, changeLimits{
+		MaxFiles:        input.MaxFiles,
+		MaxChangedLines: input.MaxChangedLines,
+		MaxFileSizeKB:   input.MaxFileSizeKB,
+		AllowBinary:     input.AllowBinaryFiles,
+	})
+	if len(result.LimitViolations) > 0 {
+		s.logger.Println()
+		s.logger.Errorf("The changes exceed the configured limits:")
+		for _, v := range result.LimitViolations {
+			s.logger.Errorf("  %s", v)
+		}
+		result.Outcome = OutcomeLimitExceeded
+		if input.OnLimitExceeded == LimitActionPatchOnly && result.PatchPath != "" {
+			s.logger.Warnf("Nothing was committed (on_limit_exceeded: %s). Review the changes, then download the patch from the build artifacts and apply it with: git apply %s", input.OnLimitExceeded, filepath.Base(result.PatchPath))
+			result.PatchOnly = true
+			return result, nil
+		}
+		return result, fmt.Errorf("the changes exceed %d limit(s), nothing was committed: a tool probably misbehaved", len(result.LimitViolations))
+	}
+
+	if result.LFSFiles, err = s.lfsTrackedPaths(result.Files); err != nil {
+		return result, err
+	}
+	if len(result.LFSFiles) > 0 {
+		s.logger.Println()
+		s.logger.Infof("%d changed file(s) are tracked by Git LFS", len(result.LFSFiles))
+		if err := s.setupGitLFS(); err != nil {
+			return result, fmt.Errorf("changed files are tracked by Git LFS (%s): %w", strings.Join(result.LFSFiles, ", "), err)
+		}
+	}
+
+	if input.ValidateSyntax {
+		result.InvalidFiles = s.checkSyntax(result.Files, result.LFSFiles)
+		if len(result.InvalidFiles) > 0 {
+			s.logger.Println()
+			s.logger.Errorf("Changed files are broken:")
+			for _, f := range result.InvalidFiles {
+				s.logger.Errorf("  %s", f)
+			}
+			result.Outcome = OutcomeInvalidFiles
+			return result, fmt.Errorf("%d changed file(s) no longer parse or changed encoding, nothing was committed: a tool probably left them half-written", len(result.InvalidFiles))
+		}
 	}
 
 	if gitBranch == "" {
-		return Result{AutofixNeeded: true}, fmt.Errorf("could not determine push target branch: BITRISE_GIT_BRANCH is empty")
+		return result, fmt.Errorf("could not determine push target branch: BITRISE_GIT_BRANCH is empty")
 	}
 
 	s.logger.Println()
 	s.logger.Infof("Committing and pushing changes to branch: %s", gitBranch)
 
-	if err := s.gitFetchAndCheckout(gitBranch, input.GitUsername, input.GitToken); err != nil {
-		return Result{AutofixNeeded: true}, fmt.Errorf("checkout branch: %w", err)
+	checkoutStart := time.Now()
+	if len(result.Excluded) > 0 {
+		excluded := make([]FileStatus, 0, len(result.Excluded))
+		for _, f := range result.Excluded {
+			if f.Hunks == 0 {
+				excluded = append(excluded, f.FileStatus)
+			}
+		}
+		if err := s.revertChanges(excluded); err != nil {
+			return result, fmt.Errorf("revert excluded changes: %w", err)
+		}
+	}
+	var submodules []string
+	for _, f := range changes {
+		if _, ok := submoduleBranches[f.Path]; ok {
+			submodules = append(submodules, f.Path)
+		}
+	}
+	if err := s.checkSubmoduleChanges(submodules, input, policy, &result); err != nil {
+		return result, err
+	}
+	for _, path := range submodules {
+		commit, err := s.commitSubmodule(path, submoduleBranches[path], policy.CommitSubject, input.GitUsername, input.GitToken)
+		if err != nil {
+			if errors.Is(err, errCherryPickConflict) {
+				result.Outcome = OutcomeConflict
+			}
+			return result, err
+		}
+		if commit != nil {
+			result.Submodules = append(result.Submodules, *commit)
+		}
+	}
+	unstaged := excludedSubmodulePaths(result.Excluded)
+	if err := s.gitFetchAndCheckout(gitBranch, input.GitUsername, input.GitToken, unstaged...); err != nil {
+		if errors.Is(err, errCherryPickConflict) {
+			result.Outcome = OutcomeConflict
+		}
+		return result, fmt.Errorf("checkout branch: %w", err)
 	}
+	result.recordPhase("checkout", checkoutStart)
 
+	// The fetched branch tip tells how many autofix commits it already ends with.
+	round, err := s.gitAutofixRound()
+	if err != nil {
+		s.logger.Debugf("Failed to read the autofix round of %s: %s", gitBranch, err)
+	}
+	if policy.MaxAutofixCommits > 0 && round >= policy.MaxAutofixCommits {
+		result.Outcome = OutcomeLoopLimit
+		return result, fmt.Errorf("%s already ends with %d consecutive autofix commit(s) (max_autofix_commits: %d): the tools probably keep changing each other's output, fix them locally", gitBranch, round, policy.MaxAutofixCommits)
+	}
+
+	commitStart := time.Now()
+	hasPreCommitHook := strings.TrimSpace(input.PreCommitCommand) != ""
+	var treeBeforeHook string
+	if hasPreCommitHook {
+		if treeBeforeHook, err = s.gitWriteTree(); err != nil {
+			return result, fmt.Errorf("record the changes before the %s hook: %w", hookPreCommit, err)
+		}
+	}
+	if err := s.runHook(hookPreCommit, input.PreCommitCommand, result); err != nil {
+		result.Outcome = OutcomeHookFailed
+		return result, err
+	}
+	if hasPreCommitHook {
+		// Whatever the hook changed, e.g. a generated changelog, goes into the autofix commit.
+		if err := s.gitAddAll(unstaged...); err != nil {
+			return result, fmt.Errorf("stage changes of the %s hook: %w", hookPreCommit, err)
+		}
+		if err := s.checkHookChanges(treeBeforeHook, input, policy, &result); err != nil {
+			return result, err
+		}
+	}
+
+	onPush := OnPushMode(input.OnPush)
+	var pushOptions []string
+	if onPush == OnPushSucceedWithSkipCI {
+		result.SkipCI = true
+		if s.isGitLabRemote() {
+			// GitLab doesn't start pipelines for pushes with this option, even if
+			// the commit message is rewritten later (e.g. squashed).
+			pushOptions = append(pushOptions, gitLabSkipCIPushOption)
+		}
+	}
 	// gitFetchAndCheckout already staged the changes via cherry-pick --no-commit.
-	if err := s.gitCommit(buildCommitMessage(input.CommitSubject, changedFiles)); err != nil {
-		return Result{AutofixNeeded: true}, fmt.Errorf("git commit: %w", err)
+	if err := s.checkLFSPointers(result.LFSFiles); err != nil {
+		return result, err
+	}
+	groups := groupFiles(result.Files, policy.CommitGroups)
+	commitCount := 0
+	for i, group := range groups {
+		subject := group.subject(policy.CommitSubject)
+		if result.SkipCI {
+			subject = withSkipCI(subject)
+		}
+		message, err := renderCommitMessage(policy.CommitTemplate, commitMessageData{
+			Subject:     subject,
+			Group:       group.name(),
+			Files:       commitFileList(group.files),
+			Deleted:     deletedFileList(group.files),
+			ToolSummary: toolSummary,
+			Branch:      gitBranch,
+			StepURL:     stepRepoURL,
+		})
+		if err != nil {
+			return result, fmt.Errorf("render commit message: %w", err)
+		}
+		// Every commit of the run is the same round, the loop limit counts runs.
+		message = withTrailer(message, autofixRoundTrailer, strconv.Itoa(round+1))
+
+		// The last commit takes whatever is left staged, including what the pre-commit hook added.
+		var paths []string
+		if i < len(groups)-1 {
+			paths = group.paths()
+		}
+		if err := s.gitCommit(message, paths...); err != nil {
+			return result, fmt.Errorf("git commit: %w", err)
+		}
+		commitCount++
+
+		if result.CommitSHA, err = s.gitHeadSHA(); err != nil {
+			s.logger.Debugf("Failed to resolve autofix commit: %s", err)
+		}
+		if len(policy.CommitGroups) > 0 {
+			s.logger.Infof("Committed %d file(s) as %q", len(group.files), subject)
+			commit := AutofixCommit{Group: group.name(), Subject: subject, CommitSHA: result.CommitSHA}
+			for _, f := range group.files {
+				commit.Files = append(commit.Files, f.Path)
+			}
+			result.Commits = append(result.Commits, commit)
+		}
+	}
+	result.recordPhase("commit", commitStart)
+
+	if validateCommands := parseFixCommands(input.ValidateCommands); len(validateCommands) > 0 {
+		validateStart := time.Now()
+		if err := s.runValidateCommands(validateCommands); err != nil {
+			result.Outcome = OutcomeValidationFailed
+			if discardErr := s.gitDiscardCommits(commitCount); discardErr != nil {
+				s.logger.Warnf("Failed to discard the autofix commit: %s", discardErr)
+			} else {
+				result.CommitSHA = ""
+				result.Commits = nil
+			}
+			return result, fmt.Errorf("validation failed, the autofix commit was discarded: %w", err)
+		}
+		result.recordPhase("validate", validateStart)
+		s.logger.Donef("Validation passed")
 	}
 
 	if input.DryRun {
 		s.logger.Println()
 		s.logger.Infof("Dry run: skipping git push. The commit was created locally but not pushed.")
-		return Result{
-			AutofixNeeded: true,
-			AutofixPushed: false,
-			FileCount:     len(changedFiles),
-			DryRun:        true,
-		}, nil
+		result.Outcome = OutcomeDryRun
+		result.FileCount = len(result.Files)
+		result.DryRun = true
+		return result, nil
 	}
 
-	if err := s.gitPush(input.GitUsername, input.GitToken, gitBranch); err != nil {
-		return Result{AutofixNeeded: true}, fmt.Errorf("git push: %w", err)
+	if err := s.runHook(hookPrePush, input.PrePushCommand, result); err != nil {
+		result.Outcome = OutcomeHookFailed
+		return result, err
 	}
 
+	pushStart := time.Now()
+	if err := s.pushSubmodules(result.Submodules, input.GitUsername, input.GitToken); err != nil {
+		result.Outcome = OutcomePushFailed
+		return result, fmt.Errorf("git push: %w", err)
+	}
+	if len(result.LFSFiles) > 0 {
+		if err := s.gitLFSPush(input.GitUsername, input.GitToken, gitBranch); err != nil {
+			result.Outcome = OutcomePushFailed
+			return result, fmt.Errorf("git lfs push: %w", err)
+		}
+	}
+	if err := s.gitPush(input.GitUsername, input.GitToken, gitBranch, pushOptions...); err != nil {
+		result.Outcome = OutcomePushFailed
+		return result, fmt.Errorf("git push: %w", err)
+	}
+	result.recordPhase("push", pushStart)
+
 	s.logger.Println()
 	s.logger.Donef("Successfully pushed autofix commit to %s", gitBranch)
+	switch onPush {
+	case OnPushSucceed:
+		s.logger.Infof("The step succeeds (on_push: %s). Make sure the build triggered by the push runs, or that your trigger ignores bot commits.", onPush)
+	case OnPushSucceedWithSkipCI:
+		s.logger.Infof("The step succeeds (on_push: %s). The autofix commit is marked with [skip ci], so it won't be built.", onPush)
+	}
+
+	result.Outcome = OutcomePushed
+	result.AutofixPushed = true
+	result.FileCount = len(result.Files)
+	result.OnPush = onPush
+
+	// The commit is already on the remote, a failing hook can't undo that.
+	if err := s.runHook(hookPostPush, input.PostPushCommand, result); err != nil {
+		s.logger.Warnf("%s", err)
+	}
+	return result, nil
+}
+
+// setupRemote points origin to the configured remote URL and checks that the
+// credentials needed to push to it are available.
+func (s Step) setupRemote(input Input) error {
+	if input.GitRemoteURL != "" {
+		if err := s.setRemoteURL(input.GitRemoteURL); err != nil {
+			return fmt.Errorf("set remote URL: %w", err)
+		}
+	}
+
+	remoteURL, err := s.getRemoteURL()
+	if err != nil {
+		return fmt.Errorf("detect remote URL: %w", err)
+	}
+
+	s.logger.Println()
+	useSSH := isSSHRemote(remoteURL)
+	if useSSH {
+		s.logger.Infof("Using SSH authentication (because of remote URL: %s)", remoteURL)
+		s.logger.Warnf("Warning: SSH keys usually only have read-only access to the repo. This use-case requires write access as well, so the push may fail.")
+		s.logger.Warnf("Consider switching to HTTPS authentication with a read-write token. To do so, set the git_token and git_remote_url inputs of this step and ensure the remote URL is an HTTPS one")
+	} else {
+		s.logger.Infof("Using HTTPS authentication (because of remote URL: %s)", remoteURL)
+	}
+	s.logger.Println()
+
+	// The step.yml defaults expand $GIT_HTTP_USERNAME/$GIT_HTTP_PASSWORD before the binary runs,
+	// so these are already resolved by the time we get here.
+	// Username is optional: GitHub App installations provide only a short-lived token.
+	if !useSSH && input.GitToken == "" {
+		return fmt.Errorf("git token is required for authentication: set git_token input or ensure $GIT_HTTP_PASSWORD is available in the environment")
+	}
 
-	return Result{
-		AutofixNeeded: true,
-		AutofixPushed: true,
-		FileCount:     len(changedFiles),
-	}, nil
+	return nil
 }
 
 func (s Step) isPRBuild() bool {
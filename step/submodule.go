@@ -0,0 +1,418 @@
+package step
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/bitrise-io/go-utils/v2/command"
+)
+
+// SubmodulePolicy decides what happens to changes inside submodules.
+type SubmodulePolicy string
+
+const (
+	// SubmodulesIgnore leaves submodules out of the autofix commit.
+	SubmodulesIgnore SubmodulePolicy = "ignore"
+	// SubmodulesRefuse fails the step when a submodule changed.
+	SubmodulesRefuse SubmodulePolicy = "refuse"
+	// SubmodulesRecurse commits and pushes the changes of each submodule to its
+	// own branch, then commits the new submodule commit in the superproject.
+	SubmodulesRecurse SubmodulePolicy = "recurse"
+)
+
+const (
+	submoduleIgnoredReason       = "submodule (submodules: ignore)"
+	submoduleUnknownBranchReason = "submodule with no known branch to push to"
+	gitlinkMode                  = "160000"
+	gitmodulesFile               = ".gitmodules"
+)
+
+// errSubmodulesRefused is returned when submodules changed while they are refused.
+var errSubmodulesRefused = fmt.Errorf("submodules changed, which is not allowed (submodules: %s)", SubmodulesRefuse)
+
+// SubmoduleCommit is the autofix commit made in a submodule.
+type SubmoduleCommit struct {
+	Path      string `json:"path"`
+	Branch    string `json:"branch"`
+	CommitSHA string `json:"commit_sha,omitempty"`
+	Pushed    bool   `json:"pushed"`
+}
+
+// dirCommandFactory runs commands in dir with env added to their environment,
+// so the git helpers of Step work on another repository or a submodule.
+type dirCommandFactory struct {
+	command.Factory
+	dir string
+	env []string
+}
+
+func (f dirCommandFactory) Create(name string, args []string, opts *command.Opts) command.Command {
+	var o command.Opts
+	if opts != nil {
+		o = *opts
+	}
+	if o.Dir == "" {
+		o.Dir = f.dir
+	}
+	if len(f.env) > 0 {
+		// Later values win, so the command's own env still overrides these.
+		o.Env = append(append([]string{}, f.env...), o.Env...)
+	}
+	return f.Factory.Create(name, args, &o)
+}
+
+// inSubmodule returns a Step whose git commands run in the submodule at path.
+func (s Step) inSubmodule(path string) Step {
+	sub := s
+	sub.repoDir = s.repoFile(path)
+	sub.commandFactory = dirCommandFactory{Factory: s.commandFactory, dir: sub.repoDir}
+	// The superproject's pathspec doesn't apply inside the submodule.
+	sub.pathspec = nil
+	return sub
+}
+
+// markSubmodules sets Submodule on the changes that are submodule gitlinks in the index.
+func (s Step) markSubmodules(changes []FileStatus) error {
+	var outBuf bytes.Buffer
+	if err := s.commandFactory.Create("git", []string{"ls-files", "--stage", "-z"}, &command.Opts{Stdout: &outBuf}).Run(); err != nil {
+		return fmt.Errorf("list submodules: %w", err)
+	}
+	gitlinks := parseGitlinks(outBuf.String())
+	for i := range changes {
+		changes[i].Submodule = gitlinks[changes[i].Path]
+	}
+	return nil
+}
+
+// parseGitlinks returns the submodule paths of `git ls-files --stage -z` output,
+// where each entry is "<mode> <object> <stage>\t<path>".
+func parseGitlinks(output string) map[string]bool {
+	gitlinks := map[string]bool{}
+	for _, entry := range strings.Split(output, "\x00") {
+		info, path, ok := strings.Cut(entry, "\t")
+		if ok && strings.HasPrefix(info, gitlinkMode+" ") {
+			gitlinks[path] = true
+		}
+	}
+	return gitlinks
+}
+
+// submoduleBranches returns the branch set in .gitmodules for each submodule
+// path, "." meaning the superproject's branch. .gitmodules is read with
+// readTrustedFile: the one in the working tree is under the control of the PR
+// author, who could point the push to any branch of the submodule's remote.
+// It also returns where .gitmodules was read from.
+func (s Step) submoduleBranches(username, token string, checkOnly bool) (map[string]string, string, error) {
+	data, source, err := s.readTrustedFile(gitmodulesFile, username, token, checkOnly)
+	if err != nil || data == nil {
+		return nil, source, err
+	}
+	file, err := os.CreateTemp("", "gitmodules-*")
+	if err != nil {
+		return nil, source, err
+	}
+	defer os.Remove(file.Name())
+	_, err = file.Write(data)
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return nil, source, fmt.Errorf("write %s: %w", gitmodulesFile, err)
+	}
+
+	args := []string{"config", "-z", "-f", file.Name(), "--get-regexp", `^submodule\..*\.(path|branch)$`}
+	out, err := s.commandFactory.Create("git", args, nil).RunAndReturnTrimmedOutput()
+	if err != nil {
+		// Also when nothing matches: no branch is known then.
+		s.logger.Debugf("Failed to read the submodule branches from %s: %s", source, err)
+		return nil, source, nil
+	}
+	return parseGitmodulesBranches(out), source, nil
+}
+
+// parseGitmodulesBranches maps submodule paths to their branch in
+// `git config -z --get-regexp` output of .gitmodules, where each entry is
+// "submodule.<name>.<key>\n<value>". Submodules without a branch are left out.
+func parseGitmodulesBranches(output string) map[string]string {
+	paths := map[string]string{}
+	branches := map[string]string{}
+	for _, entry := range strings.Split(output, "\x00") {
+		key, value, _ := strings.Cut(entry, "\n")
+		i := strings.LastIndex(key, ".")
+		if i < 0 {
+			continue
+		}
+		name := strings.TrimPrefix(key[:i], "submodule.")
+		switch key[i+1:] {
+		case "path":
+			paths[name] = value
+		case "branch":
+			branches[name] = value
+		}
+	}
+	byPath := map[string]string{}
+	for name, path := range paths {
+		if branch := branches[name]; branch != "" {
+			byPath[path] = branch
+		}
+	}
+	return byPath
+}
+
+// applySubmodulePolicy handles the changed submodules. In recurse mode it
+// returns the branch of each submodule to commit in; submodules without a
+// known branch are excluded.
+func (s Step) applySubmodulePolicy(changes []FileStatus, input Input, superBranch string) ([]FileStatus, []ExcludedFile, map[string]string, error) {
+	var kept []FileStatus
+	var excluded []ExcludedFile
+	var refused []string
+	branches := map[string]string{}
+	// Read on the first changed submodule, so the other policies don't fetch.
+	var configured map[string]string
+	var source string
+	loaded := false
+	for _, f := range changes {
+		if !f.Submodule {
+			kept = append(kept, f)
+			continue
+		}
+		switch input.Submodules {
+		case SubmodulesRefuse:
+			refused = append(refused, f.Path)
+		case SubmodulesRecurse:
+			if !loaded {
+				var err error
+				if configured, source, err = s.submoduleBranches(input.GitUsername, input.GitToken, input.CheckOnly); err != nil {
+					return nil, nil, nil, fmt.Errorf("read the submodule branches: %w", err)
+				}
+				loaded = true
+			}
+			branch := configured[f.Path]
+			if branch == "." {
+				branch = superBranch
+			}
+			if branch == "" {
+				s.logger.Warnf("Submodule %s has no branch set in the %s of %s, leaving it out", f.Path, gitmodulesFile, source)
+				excluded = append(excluded, ExcludedFile{FileStatus: f, Reason: submoduleUnknownBranchReason})
+				continue
+			}
+			branches[f.Path] = branch
+			kept = append(kept, f)
+		default:
+			excluded = append(excluded, ExcludedFile{FileStatus: f, Reason: submoduleIgnoredReason})
+		}
+	}
+	if len(refused) > 0 {
+		return nil, nil, nil, fmt.Errorf("%w:\n  %s", errSubmodulesRefused, strings.Join(refused, "\n  "))
+	}
+	return kept, excluded, branches, nil
+}
+
+// excludedSubmodulePaths returns the paths of the excluded submodules. Reverting
+// doesn't touch a submodule's own checkout, so they have to be kept out of git add.
+func excludedSubmodulePaths(excluded []ExcludedFile) []string {
+	var paths []string
+	for _, f := range excluded {
+		if f.Submodule {
+			paths = append(paths, f.Path)
+		}
+	}
+	return paths
+}
+
+// checkSubmoduleChanges puts the changes inside the submodules at paths
+// through the same checks as the superproject's changes, before anything is
+// committed. Paths are matched with the submodule path as a prefix, the way the
+// superproject's config sees them. What the noise filter, the path filters or
+// the deletion policy leave out is reverted in the submodule and added to
+// result.Excluded.
+func (s Step) checkSubmoduleChanges(paths []string, input Input, policy Policy, result *Result) error {
+	files := append([]FileStatus{}, result.Files...)
+	sizes, err := s.fileSizes(result.Files)
+	if err != nil {
+		return fmt.Errorf("check change limits: %w", err)
+	}
+	for _, path := range paths {
+		sub := s.inSubmodule(path)
+		changes, err := sub.getChangedFiles(true)
+		if err != nil {
+			return fmt.Errorf("detect changes in submodule %s: %w", path, err)
+		}
+		if len(changes) == 0 {
+			continue
+		}
+
+		var excluded []ExcludedFile
+		if input.ExcludeNoise {
+			var noise []ExcludedFile
+			if changes, noise, err = sub.excludeNoise(changes); err != nil {
+				return fmt.Errorf("exclude noise changes in submodule %s: %w", path, err)
+			}
+			for _, f := range noise {
+				f.FileStatus = prefixPath(path, f.FileStatus)
+				excluded = append(excluded, f)
+			}
+		}
+		kept, filtered := filterChanges(prefixPaths(path, changes), policy)
+		excluded = append(excluded, filtered...)
+		kept, notAllowed, err := s.checkDeletions(kept, input.Deletions, firstNonEmptyList(input.DeletionAllowlist))
+		if err != nil {
+			result.Outcome = OutcomeDeletionBlocked
+			return fmt.Errorf("submodule %s: %w", path, err)
+		}
+		excluded = append(excluded, notAllowed...)
+		if len(excluded) > 0 {
+			reverted := make([]FileStatus, 0, len(excluded))
+			for _, f := range excluded {
+				reverted = append(reverted, trimPathPrefix(path, f.FileStatus))
+			}
+			if err := sub.revertChanges(reverted); err != nil {
+				return fmt.Errorf("revert excluded changes in submodule %s: %w", path, err)
+			}
+			s.logger.Println()
+			s.logger.Infof("Excluded %d changed file(s) of submodule %s from its autofix commit:", len(excluded), path)
+			for _, f := range excluded {
+				s.logger.Printf("  %s (%s)", f.String(), f.Reason)
+			}
+			result.Excluded = append(result.Excluded, excluded...)
+		}
+		if len(kept) == 0 {
+			continue
+		}
+
+		// The submodule's own CI config is at its top level.
+		changes = make([]FileStatus, 0, len(kept))
+		for _, f := range kept {
+			changes = append(changes, trimPathPrefix(path, f))
+		}
+		if err := checkForCIConfigChanges(append(filePaths(changes), filePaths(kept)...)); err != nil {
+			result.Outcome = OutcomeSecurityBlocked
+			return fmt.Errorf("security check failed: submodule %s: %w", path, err)
+		}
+		if err := checkForProtectedPaths(filePaths(kept), policy.ProtectedPaths); err != nil {
+			result.Outcome = OutcomeSecurityBlocked
+			return fmt.Errorf("security check failed: submodule %s: %w", path, err)
+		}
+
+		diffs, _, err := sub.getAutofixDiff(changes)
+		if err != nil {
+			return fmt.Errorf("compute diff of submodule %s: %w", path, err)
+		}
+		annotateDiffStats(changes, diffs)
+		subSizes, err := sub.fileSizes(changes)
+		if err != nil {
+			return fmt.Errorf("check change limits: %w", err)
+		}
+		for p, size := range subSizes {
+			sizes[filepath.ToSlash(filepath.Join(path, p))] = size
+		}
+		files = append(files, prefixPaths(path, changes)...)
+
+		if input.ValidateSyntax {
+			lfsFiles, err := sub.lfsTrackedPaths(changes)
+			if err != nil {
+				return err
+			}
+			for _, f := range sub.checkSyntax(changes, lfsFiles) {
+				f.Path = filepath.ToSlash(filepath.Join(path, f.Path))
+				result.InvalidFiles = append(result.InvalidFiles, f)
+			}
+		}
+	}
+
+	if len(result.InvalidFiles) > 0 {
+		s.logger.Println()
+		s.logger.Errorf("Changed files in submodules are broken:")
+		for _, f := range result.InvalidFiles {
+			s.logger.Errorf("  %s", f)
+		}
+		result.Outcome = OutcomeInvalidFiles
+		return fmt.Errorf("%d changed file(s) in submodules no longer parse or changed encoding, nothing was committed: a tool probably left them half-written", len(result.InvalidFiles))
+	}
+	result.LimitViolations = checkLimits(files, sizes, changeLimits{
+		MaxFiles:        input.MaxFiles,
+		MaxChangedLines: input.MaxChangedLines,
+		MaxFileSizeKB:   input.MaxFileSizeKB,
+		AllowBinary:     input.AllowBinaryFiles,
+	})
+	if len(result.LimitViolations) > 0 {
+		s.logger.Println()
+		s.logger.Errorf("With the changes in submodules the changes exceed the configured limits:")
+		for _, v := range result.LimitViolations {
+			s.logger.Errorf("  %s", v)
+		}
+		result.Outcome = OutcomeLimitExceeded
+		return fmt.Errorf("with the changes in submodules the changes exceed %d limit(s), nothing was committed: a tool probably misbehaved", len(result.LimitViolations))
+	}
+	return nil
+}
+
+// prefixPath returns f with its paths relative to the superproject, for a
+// change inside the submodule at dir.
+func prefixPath(dir string, f FileStatus) FileStatus {
+	f.Path = filepath.ToSlash(filepath.Join(dir, f.Path))
+	if f.OldPath != "" {
+		f.OldPath = filepath.ToSlash(filepath.Join(dir, f.OldPath))
+	}
+	return f
+}
+
+func prefixPaths(dir string, files []FileStatus) []FileStatus {
+	prefixed := make([]FileStatus, 0, len(files))
+	for _, f := range files {
+		prefixed = append(prefixed, prefixPath(dir, f))
+	}
+	return prefixed
+}
+
+// trimPathPrefix undoes prefixPath.
+func trimPathPrefix(dir string, f FileStatus) FileStatus {
+	prefix := filepath.ToSlash(filepath.Clean(dir)) + "/"
+	f.Path = strings.TrimPrefix(f.Path, prefix)
+	f.OldPath = strings.TrimPrefix(f.OldPath, prefix)
+	return f
+}
+
+// commitSubmodule commits the changes inside a submodule on top of its
+// branch. A submodule with no changes of its own, only a different checked out
+// commit, is left as it is and nil is returned.
+func (s Step) commitSubmodule(path, branch, subject, username, token string) (*SubmoduleCommit, error) {
+	sub := s.inSubmodule(path)
+	changes, err := sub.getChangedFiles(true)
+	if err != nil {
+		return nil, err
+	}
+	if len(changes) == 0 {
+		return nil, nil
+	}
+
+	s.logger.Println()
+	s.logger.Infof("Committing %d changed file(s) in submodule %s on branch %s", len(changes), path, branch)
+	if err := sub.gitFetchAndCheckout(branch, username, token); err != nil {
+		return nil, fmt.Errorf("check out branch %s of submodule %s: %w", branch, path, err)
+	}
+	if err := sub.gitCommit(buildCommitMessage(subject, commitFileList(changes), deletedFileList(changes))); err != nil {
+		return nil, fmt.Errorf("git commit in submodule %s: %w", path, err)
+	}
+	commit := &SubmoduleCommit{Path: path, Branch: branch}
+	if commit.CommitSHA, err = sub.gitHeadSHA(); err != nil {
+		return nil, err
+	}
+	return commit, nil
+}
+
+// pushSubmodules pushes the submodule commits, before the superproject commit
+// that refers to them.
+func (s Step) pushSubmodules(commits []SubmoduleCommit, username, token string) error {
+	for i, c := range commits {
+		if err := s.inSubmodule(c.Path).gitPush(username, token, c.Branch); err != nil {
+			return fmt.Errorf("submodule %s: %w", c.Path, err)
+		}
+		commits[i].Pushed = true
+		s.logger.Donef("Pushed the autofix commit of submodule %s to %s", c.Path, c.Branch)
+	}
+	return nil
+}
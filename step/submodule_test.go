@@ -0,0 +1,84 @@
+package step
+
+import (
+	"testing"
+
+	"github.com/bitrise-io/go-utils/v2/log"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_parseGitlinks(t *testing.T) {
+	output := "100644 8f3a 0\tREADME.md\x00160000 1b2c 0\tlibs/core\x00100755 9d4e 0\tscripts/lint.sh\x00"
+
+	assert.Equal(t, map[string]bool{"libs/core": true}, parseGitlinks(output))
+}
+
+func Test_applySubmodulePolicy(t *testing.T) {
+	changes := []FileStatus{
+		{Path: "main.go", Status: FileModified},
+		{Path: "libs/core", Status: FileModified, Submodule: true},
+	}
+	factory := &fakeCommandFactory{responses: map[string]string{
+		"--get-regexp": "submodule.core.path\nlibs/core\x00submodule.core.branch\nmain\x00",
+	}}
+	s := Step{commandFactory: factory, logger: log.NewLogger(), envRepo: fakeEnvRepo{}}
+
+	kept, excluded, branches, err := s.applySubmodulePolicy(changes, Input{Submodules: SubmodulesIgnore}, "feature")
+	require.NoError(t, err)
+	assert.Equal(t, changes[:1], kept)
+	assert.Equal(t, []ExcludedFile{{FileStatus: changes[1], Reason: submoduleIgnoredReason}}, excluded)
+	assert.Empty(t, branches)
+	assert.Equal(t, []string{"libs/core"}, excludedSubmodulePaths(excluded))
+
+	assert.Empty(t, factory.calls, "only recurse mode needs the submodule branches")
+
+	_, _, _, err = s.applySubmodulePolicy(changes, Input{Submodules: SubmodulesRefuse}, "feature")
+	assert.ErrorIs(t, err, errSubmodulesRefused)
+	assert.ErrorContains(t, err, "libs/core")
+
+	kept, excluded, branches, err = s.applySubmodulePolicy(changes, Input{Submodules: SubmodulesRecurse}, "feature")
+	require.NoError(t, err)
+	assert.Equal(t, changes, kept)
+	assert.Empty(t, excluded)
+	assert.Equal(t, map[string]string{"libs/core": "main"}, branches)
+}
+
+func Test_applySubmodulePolicy_branchFromBaseBranch(t *testing.T) {
+	changes := []FileStatus{{Path: "libs/core", Status: FileModified, Submodule: true}}
+	factory := &fakeCommandFactory{responses: map[string]string{
+		"--get-regexp": "submodule.core.path\nlibs/core\x00submodule.core.branch\n.\x00",
+	}}
+	s := Step{commandFactory: factory, logger: log.NewLogger(), envRepo: fakeEnvRepo{
+		"BITRISE_PULL_REQUEST":      "42",
+		"BITRISEIO_GIT_BRANCH_DEST": "main",
+	}}
+
+	_, _, branches, err := s.applySubmodulePolicy(changes, Input{Submodules: SubmodulesRecurse}, "feature")
+
+	require.NoError(t, err)
+	assert.Equal(t, map[string]string{"libs/core": "feature"}, branches)
+	show, ok := factory.findCall("show")
+	require.True(t, ok)
+	assert.Equal(t, []string{"show", "FETCH_HEAD:.gitmodules"}, show.args)
+	config, ok := factory.findCall("--get-regexp")
+	require.True(t, ok)
+	assert.NotContains(t, config.args, ".gitmodules", "the working tree's .gitmodules must not be read")
+}
+
+func Test_parseGitmodulesBranches(t *testing.T) {
+	output := "submodule.core.path\nlibs/core\x00submodule.core.branch\nmain\x00" +
+		"submodule.ui.kit.path\nlibs/ui\x00submodule.ui.kit.branch\n.\x00" +
+		"submodule.docs.path\ndocs\x00"
+
+	assert.Equal(t, map[string]string{"libs/core": "main", "libs/ui": "."}, parseGitmodulesBranches(output))
+}
+
+func Test_prefixPath(t *testing.T) {
+	f := FileStatus{Path: "new.go", OldPath: "old.go", Status: FileRenamed}
+
+	prefixed := prefixPath("libs/core", f)
+
+	assert.Equal(t, FileStatus{Path: "libs/core/new.go", OldPath: "libs/core/old.go", Status: FileRenamed}, prefixed)
+	assert.Equal(t, f, trimPathPrefix("libs/core", prefixed))
+}
@@ -0,0 +1,258 @@
+package step
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+const (
+	summaryFileName = "autofix-summary.md"
+	// maxSummaryDiffLines keeps the summary readable in annotation UIs, which
+	// usually have a size limit well below what a large reformat produces.
+	maxSummaryDiffLines = 300
+	// annotationContext identifies our annotation so re-runs replace it instead of stacking up.
+	annotationContext = "autofix-ci"
+)
+
+// buildSummary renders a Markdown summary of the run: the outcome, a diffstat
+// table, the (truncated) diff and a command to reproduce the changes locally.
+func buildSummary(result Result) string {
+	var sb strings.Builder
+	sb.WriteString("## Autofix CI: ")
+	sb.WriteString(summaryHeadline(result))
+	sb.WriteString("\n\n")
+
+	if result.Error != "" {
+		fence := codeFence(result.Error)
+		sb.WriteString(fence + "\n")
+		sb.WriteString(result.Error)
+		sb.WriteString("\n" + fence + "\n\n")
+	}
+
+	if len(result.LimitViolations) > 0 {
+		sb.WriteString("Limits exceeded:\n\n")
+		for _, v := range result.LimitViolations {
+			fmt.Fprintf(&sb, "- %s\n", v)
+		}
+		sb.WriteString("\n")
+	}
+
+	if len(result.InvalidFiles) > 0 {
+		sb.WriteString("Broken files:\n\n")
+		for _, f := range result.InvalidFiles {
+			fmt.Fprintf(&sb, "- %s\n", f)
+		}
+		sb.WriteString("\n")
+	}
+
+	if len(result.Files) == 0 {
+		return sb.String()
+	}
+
+	sb.WriteString("| File | Status | Added | Removed |\n")
+	sb.WriteString("|---|---|---:|---:|\n")
+	for _, f := range result.Files {
+		added, removed := fmt.Sprintf("+%d", f.Added), fmt.Sprintf("-%d", f.Removed)
+		if f.Binary {
+			added, removed = "binary", ""
+		}
+		fmt.Fprintf(&sb, "| `%s` | %s | %s | %s |\n", f.String(), f.Status, added, removed)
+	}
+	sb.WriteString("\n")
+
+	patch, truncated := summaryPatch(result.Diff, maxSummaryDiffLines)
+	if patch != "" {
+		fence := codeFence(patch)
+		sb.WriteString("<details><summary>Diff</summary>\n\n" + fence + "diff\n")
+		sb.WriteString(patch)
+		if truncated {
+			sb.WriteString("... (truncated)\n")
+		}
+		sb.WriteString(fence + "\n\n</details>\n\n")
+	}
+
+	sb.WriteString("### Reproduce locally\n\n")
+	sb.WriteString(reproduceInstructions(result, patch, truncated))
+	return sb.String()
+}
+
+func summaryHeadline(result Result) string {
+	switch result.Outcome {
+	case OutcomeNotPR:
+		return "skipped, not a PR build"
+	case OutcomeFork:
+		return "skipped, the PR comes from a fork"
+	case OutcomeNoChanges:
+		return "no changes, nothing to commit"
+	case OutcomeSecurityBlocked:
+		return "blocked, the changes touch CI config"
+	case OutcomeConflict:
+		return "failed, the changes conflict with the PR branch"
+	case OutcomePushed:
+		return fmt.Sprintf("pushed %s to `%s`", shortSHA(result.CommitSHA), result.Branch)
+	case OutcomeDryRun:
+		return "dry run, the autofix commit was not pushed"
+	case OutcomePushFailed:
+		return "failed to push the autofix commit"
+	case OutcomeCheckFailed:
+		return "check failed, run the formatters locally"
+	case OutcomeLoopLimit:
+		return "stopped, too many consecutive autofix commits"
+	case OutcomeFixFailed:
+		return "failed to run the fix commands"
+	case OutcomeValidationFailed:
+		return "not pushed, the fixed code failed validation"
+	case OutcomeHookFailed:
+		return "not pushed, a hook failed"
+	case OutcomeDeletionBlocked:
+		return "blocked, the changes delete files"
+	case OutcomeSubmoduleBlocked:
+		return "blocked, a submodule changed"
+	case OutcomeInvalidFiles:
+		return "blocked, changed files are broken"
+	case OutcomeLimitExceeded:
+		if result.PatchOnly {
+			return "too large to commit, delivered as a patch"
+		}
+		return "stopped, the changes exceed the limits"
+	default:
+		return "failed"
+	}
+}
+
+// summaryPatch joins the file diffs, stopping after maxLines lines.
+func summaryPatch(diffs []FileDiff, maxLines int) (string, bool) {
+	var lines []string
+	for _, d := range diffs {
+		lines = append(lines, strings.Split(strings.TrimSuffix(d.Patch(), "\n"), "\n")...)
+	}
+	if len(lines) == 0 {
+		return "", false
+	}
+	if len(lines) > maxLines {
+		return strings.Join(lines[:maxLines], "\n") + "\n", true
+	}
+	return strings.Join(lines, "\n") + "\n", false
+}
+
+func reproduceInstructions(result Result, patch string, truncated bool) string {
+	if result.Outcome == OutcomePushed {
+		return fmt.Sprintf("The fix is already on the branch, pull it before pushing again:\n\n```sh\ngit pull origin %s\n```\n", result.Branch)
+	}
+
+	hasBinary := false
+	for _, d := range result.Diff {
+		hasBinary = hasBinary || d.Binary
+	}
+	if patch == "" || truncated || hasBinary {
+		return "Run the same formatters, linters and generators locally, then commit the result.\n"
+	}
+	// A quoted heredoc delimiter disables expansion, so the patch is applied verbatim.
+	// It is random, so no line of the patch can end the heredoc early.
+	delimiter := heredocDelimiter(patch)
+	fence := codeFence(patch)
+	return fmt.Sprintf("Apply the same changes on top of your branch:\n\n%ssh\ngit apply <<'%s'\n%s%s\n%s\n", fence, delimiter, patch, delimiter, fence)
+}
+
+// codeFence returns a Markdown code fence longer than any run of backticks in
+// content, so the content can't close it.
+func codeFence(content string) string {
+	longest, run := 0, 0
+	for _, c := range content {
+		if c != '`' {
+			run = 0
+			continue
+		}
+		run++
+		if run > longest {
+			longest = run
+		}
+	}
+	return strings.Repeat("`", max(3, longest+1))
+}
+
+// heredocDelimiter returns a random delimiter that is not a line of content.
+func heredocDelimiter(content string) string {
+	delimiter := "AUTOFIX_PATCH"
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err == nil {
+		delimiter += "_" + hex.EncodeToString(b)
+	}
+	for strings.Contains("\n"+content, "\n"+delimiter+"\n") {
+		delimiter += "_"
+	}
+	return delimiter
+}
+
+func shortSHA(sha string) string {
+	if len(sha) > 7 {
+		return sha[:7]
+	}
+	return sha
+}
+
+// writeSummary writes the summary next to the JSON report and publishes it
+// where CI systems look for step summaries. Publishing is best effort.
+func (s Step) writeSummary(result Result) (string, error) {
+	summary := buildSummary(result)
+
+	dir, err := s.outputDir()
+	if err != nil {
+		return "", err
+	}
+	path := filepath.Join(dir, summaryFileName)
+	if err := os.WriteFile(path, []byte(summary), 0644); err != nil {
+		return "", fmt.Errorf("write summary: %w", err)
+	}
+
+	// GitHub Actions and compatible runners render this file on the run page.
+	if ghSummary := s.envRepo.Get("GITHUB_STEP_SUMMARY"); ghSummary != "" {
+		if err := appendFile(ghSummary, summary); err != nil {
+			s.logger.Warnf("Failed to append to GITHUB_STEP_SUMMARY: %s", err)
+		}
+	}
+
+	// Skips are routine, only annotate the build when there was something to fix.
+	if result.AutofixNeeded {
+		s.annotateBuild(summary, annotationStyle(result.Outcome))
+	}
+
+	return path, nil
+}
+
+func (s Step) annotateBuild(markdown, style string) {
+	args := []string{":annotations", "annotate", markdown, "--style", style, "--context", annotationContext}
+	out, err := s.commandFactory.Create("bitrise", args, nil).RunAndReturnTrimmedCombinedOutput()
+	if err != nil {
+		// Older CLI versions and non-Bitrise environments don't support annotations.
+		s.logger.Debugf("Failed to annotate build: %s\n%s", err, out)
+	}
+}
+
+func annotationStyle(outcome Outcome) string {
+	switch outcome {
+	case OutcomeDryRun:
+		return "info"
+	case OutcomePushed:
+		// The build fails on purpose, the annotation explains why.
+		return "warning"
+	default:
+		return "error"
+	}
+}
+
+func appendFile(path, content string) error {
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	if _, err := f.WriteString(content); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
@@ -0,0 +1,91 @@
+package step
+
+import (
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_buildSummary(t *testing.T) {
+	diffs := parseUnifiedDiff(sampleDiff)[:1]
+	result := Result{
+		Outcome:       OutcomeDryRun,
+		AutofixNeeded: true,
+		Branch:        "feature",
+		Files:         []FileStatus{{Path: "main.go", Status: FileModified, Added: 3, Removed: 2}},
+		Diff:          diffs,
+	}
+
+	summary := buildSummary(result)
+
+	assert.True(t, strings.HasPrefix(summary, "## Autofix CI: dry run"))
+	assert.Contains(t, summary, "| `main.go` | modified | +3 | -2 |")
+	assert.Contains(t, summary, "```diff\n"+diffs[0].Patch()+"```")
+	assert.Regexp(t, "git apply <<'(AUTOFIX_PATCH_[0-9a-f]{16})'\n"+regexp.QuoteMeta(diffs[0].Patch())+"AUTOFIX_PATCH_[0-9a-f]{16}\n", summary)
+}
+
+func Test_buildSummary_PatchCannotEndItsBlocks(t *testing.T) {
+	patch := "+AUTOFIX_PATCH\n+```\n+````go\n"
+	diffs := []FileDiff{{Path: "README.md", Hunks: []Hunk{{Header: "@@ -0,0 +1,3 @@", Lines: strings.Split(strings.TrimSuffix(patch, "\n"), "\n")}}}}
+	result := Result{
+		Outcome:       OutcomeDryRun,
+		AutofixNeeded: true,
+		Files:         []FileStatus{{Path: "README.md", Status: FileModified, Added: 3}},
+		Diff:          diffs,
+	}
+
+	summary := buildSummary(result)
+
+	assert.Contains(t, summary, "`````diff\n"+diffs[0].Patch()+"`````\n")
+	delimiter := regexp.MustCompile(`git apply <<'([^']+)'`).FindStringSubmatch(summary)
+	require.Len(t, delimiter, 2)
+	assert.NotEqual(t, "AUTOFIX_PATCH", delimiter[1])
+	assert.Contains(t, summary, "`````sh\ngit apply <<'"+delimiter[1]+"'\n"+diffs[0].Patch()+delimiter[1]+"\n`````\n")
+}
+
+func Test_heredocDelimiter(t *testing.T) {
+	a, b := heredocDelimiter(""), heredocDelimiter("")
+	assert.NotEqual(t, a, b, "the delimiter should be random")
+	assert.NotContains(t, heredocDelimiter("x\n"+a+"\n"), "\n")
+}
+
+func Test_buildSummary_Pushed(t *testing.T) {
+	summary := buildSummary(Result{
+		Outcome:   OutcomePushed,
+		Branch:    "feature",
+		CommitSHA: "0123456789abcdef",
+		Files:     []FileStatus{{Path: "main.go", Status: FileModified}},
+	})
+
+	assert.Contains(t, summary, "pushed 0123456 to `feature`")
+	assert.Contains(t, summary, "git pull origin feature")
+}
+
+func Test_buildSummary_Skipped(t *testing.T) {
+	summary := buildSummary(Result{Outcome: OutcomeFork})
+
+	assert.Equal(t, "## Autofix CI: skipped, the PR comes from a fork\n\n", summary)
+}
+
+func Test_buildSummary_TruncatedDiffHasNoPatchCommand(t *testing.T) {
+	var lines []string
+	for i := 0; i < maxSummaryDiffLines; i++ {
+		lines = append(lines, "+line")
+	}
+	result := Result{
+		Outcome:       OutcomeSecurityBlocked,
+		AutofixNeeded: true,
+		Error:         "security check failed",
+		Files:         []FileStatus{{Path: "big.txt", Status: FileUntracked}},
+		Diff:          []FileDiff{{Path: "big.txt", Hunks: []Hunk{{Header: "@@ -0,0 +1,300 @@", Lines: lines}}}},
+	}
+
+	summary := buildSummary(result)
+
+	assert.Contains(t, summary, "```\nsecurity check failed\n```")
+	assert.Contains(t, summary, "... (truncated)")
+	assert.NotContains(t, summary, "git apply")
+}
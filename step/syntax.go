@@ -0,0 +1,189 @@
+package step
+
+import (
+	"bytes"
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/BurntSushi/toml"
+	"github.com/bitrise-io/go-utils/v2/command"
+	"gopkg.in/yaml.v3"
+	"howett.net/plist"
+)
+
+// Text encodings told apart by checkEncoding. Anything else is encodingOther.
+const (
+	encodingUTF8    = "UTF-8"
+	encodingUTF16LE = "UTF-16LE"
+	encodingUTF16BE = "UTF-16BE"
+	encodingOther   = "not UTF-8 or UTF-16"
+)
+
+// InvalidFile is a changed file the step refuses to commit.
+type InvalidFile struct {
+	Path    string `json:"path"`
+	Problem string `json:"problem"`
+}
+
+func (f InvalidFile) String() string {
+	return f.Path + ": " + f.Problem
+}
+
+// structuredParsers check the syntax of the file types that a half-written
+// generator output most often breaks, by extension.
+var structuredParsers = map[string]func([]byte) error{
+	".json":         parseJSON,
+	".yml":          parseYAML,
+	".yaml":         parseYAML,
+	".xml":          parseXML,
+	".xib":          parseXML,
+	".storyboard":   parseXML,
+	".xcscheme":     parseXML,
+	".plist":        parsePlist,
+	".entitlements": parsePlist,
+	".pbxproj":      parsePlist,
+	".toml":         parseTOML,
+}
+
+func parseJSON(data []byte) error {
+	var v any
+	return json.Unmarshal(data, &v)
+}
+
+func parseYAML(data []byte) error {
+	dec := yaml.NewDecoder(bytes.NewReader(data))
+	for {
+		var v any
+		if err := dec.Decode(&v); err != nil {
+			if errors.Is(err, io.EOF) {
+				return nil
+			}
+			return err
+		}
+	}
+}
+
+func parseXML(data []byte) error {
+	dec := xml.NewDecoder(bytes.NewReader(data))
+	for {
+		if _, err := dec.Token(); err != nil {
+			if errors.Is(err, io.EOF) {
+				return nil
+			}
+			return err
+		}
+	}
+}
+
+// parsePlist accepts XML, binary and the old-style (OpenStep) format of .pbxproj files.
+func parsePlist(data []byte) error {
+	var v any
+	_, err := plist.Unmarshal(data, &v)
+	return err
+}
+
+func parseTOML(data []byte) error {
+	var v map[string]any
+	_, err := toml.Decode(string(data), &v)
+	return err
+}
+
+// textEncoding tells UTF-8 and UTF-16 apart, by the byte order mark or, for
+// UTF-16 without one, by the zero bytes of ASCII characters.
+func textEncoding(data []byte) string {
+	switch {
+	case bytes.HasPrefix(data, []byte{0xFF, 0xFE}):
+		return encodingUTF16LE
+	case bytes.HasPrefix(data, []byte{0xFE, 0xFF}):
+		return encodingUTF16BE
+	case len(data) >= 2 && data[0] != 0 && data[1] == 0:
+		return encodingUTF16LE
+	case len(data) >= 2 && data[0] == 0 && data[1] != 0:
+		return encodingUTF16BE
+	case utf8.Valid(data):
+		return encodingUTF8
+	default:
+		return encodingOther
+	}
+}
+
+func isUTF16(encoding string) bool {
+	return encoding == encodingUTF16LE || encoding == encodingUTF16BE
+}
+
+// checkSyntax returns the changed files that no longer parse, or whose text
+// encoding changed. Files that didn't parse in HEAD either are only warned about.
+// Binary and Git LFS files are skipped: they aren't text, and the HEAD blob of an
+// LFS file is its pointer. git calls UTF-16 text binary too, so that is still checked.
+func (s Step) checkSyntax(files []FileStatus, lfsFiles []string) []InvalidFile {
+	lfs := make(map[string]bool, len(lfsFiles))
+	for _, p := range lfsFiles {
+		lfs[p] = true
+	}
+	var invalid []InvalidFile
+	for _, f := range files {
+		if f.Status == FileDeleted || f.Submodule || lfs[f.Path] {
+			continue
+		}
+		// A file that can't be read, e.g. a dangling symlink, isn't worth failing the run for.
+		current, err := os.ReadFile(s.repoFile(f.Path))
+		if err != nil {
+			s.logger.Warnf("Skipping the syntax check of %s: %s", f.Path, err)
+			continue
+		}
+		var head []byte
+		inHead := f.Status != FileUntracked && f.Status != FileAdded
+		if inHead {
+			headPath := f.Path
+			if f.OldPath != "" {
+				headPath = f.OldPath
+			}
+			if head, err = s.gitHeadBlob(headPath); err != nil {
+				s.logger.Warnf("Skipping the syntax check of %s: %s", f.Path, err)
+				continue
+			}
+		}
+
+		before, after := textEncoding(head), textEncoding(current)
+		if f.Binary && !isUTF16(before) && !isUTF16(after) {
+			continue
+		}
+		if inHead && len(head) > 0 && len(current) > 0 {
+			if before != encodingOther && before != after {
+				invalid = append(invalid, InvalidFile{Path: f.Path, Problem: fmt.Sprintf("the encoding changed from %s to %s", before, after)})
+				continue
+			}
+		}
+
+		parse, ok := structuredParsers[strings.ToLower(filepath.Ext(f.Path))]
+		if !ok {
+			continue
+		}
+		parseErr := parse(current)
+		if parseErr == nil {
+			continue
+		}
+		if inHead && parse(head) != nil {
+			s.logger.Warnf("%s doesn't parse, but it didn't parse before the changes either: %s", f.Path, parseErr)
+			continue
+		}
+		invalid = append(invalid, InvalidFile{Path: f.Path, Problem: "doesn't parse: " + parseErr.Error()})
+	}
+	return invalid
+}
+
+// gitHeadBlob returns the content of the file in HEAD.
+func (s Step) gitHeadBlob(path string) ([]byte, error) {
+	var outBuf bytes.Buffer
+	if err := s.commandFactory.Create("git", []string{"cat-file", "blob", "HEAD:" + path}, &command.Opts{Stdout: &outBuf}).Run(); err != nil {
+		return nil, fmt.Errorf("read HEAD:%s: %w", path, err)
+	}
+	return outBuf.Bytes(), nil
+}
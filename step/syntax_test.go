@@ -0,0 +1,122 @@
+package step
+
+import (
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_structuredParsers(t *testing.T) {
+	tests := []struct {
+		ext     string
+		valid   string
+		invalid string
+	}{
+		{ext: ".json", valid: `{"a": [1, 2]}`, invalid: `{"a": [1, 2}`},
+		{ext: ".yaml", valid: "a: 1\n---\nb: [2]\n", invalid: "a: 1\n---\nb: [2\n"},
+		{ext: ".xml", valid: `<?xml version="1.0"?><a><b/></a>`, invalid: `<a><b></a>`},
+		{ext: ".plist", valid: `<?xml version="1.0"?><plist version="1.0"><dict><key>a</key><string>b</string></dict></plist>`, invalid: `<plist version="1.0"><dict><key>a</key>`},
+		{ext: ".pbxproj", valid: "// !$*UTF8*$!\n{\n\tarchiveVersion = 1;\n\tobjects = {\n\t\tAB12 /* main.swift */ = {isa = PBXFileReference; path = main.swift; };\n\t};\n}\n", invalid: "// !$*UTF8*$!\n{\n\tarchiveVersion = 1;\n\tobjects = {\n"},
+		{ext: ".toml", valid: "[tool]\nname = \"x\"\n", invalid: "[tool\nname = \"x\"\n"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.ext, func(t *testing.T) {
+			parse := structuredParsers[tt.ext]
+			assert.NoError(t, parse([]byte(tt.valid)))
+			assert.Error(t, parse([]byte(tt.invalid)))
+		})
+	}
+}
+
+func Test_textEncoding(t *testing.T) {
+	tests := []struct {
+		name string
+		data []byte
+		want string
+	}{
+		{name: "ASCII", data: []byte("hello"), want: encodingUTF8},
+		{name: "UTF-8 with BOM", data: []byte("\xef\xbb\xbfhéllo"), want: encodingUTF8},
+		{name: "UTF-16LE with BOM", data: []byte("\xff\xfeh\x00i\x00"), want: encodingUTF16LE},
+		{name: "UTF-16BE with BOM", data: []byte("\xfe\xff\x00h\x00i"), want: encodingUTF16BE},
+		{name: "UTF-16LE without BOM", data: []byte("h\x00i\x00"), want: encodingUTF16LE},
+		{name: "Latin-1", data: []byte("h\xe9llo"), want: encodingOther},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, textEncoding(tt.data))
+		})
+	}
+}
+
+func Test_checkSyntax(t *testing.T) {
+	const lfsPointer = "version https://git-lfs.github.com/spec/v1\noid sha256:4d7a214614ab2935c943f9e0ff69d22eadbb8f32b1258daaa5e2ca24d17e2393\nsize 12345\n"
+	png := "\x89PNG\r\n\x1a\n\xff\xd8"
+	tests := []struct {
+		name     string
+		head     string
+		current  string
+		file     FileStatus
+		lfsFiles []string
+		want     []InvalidFile
+	}{
+		{
+			name:    "text that is no longer UTF-8",
+			head:    "h\xc3\xa9llo\n",
+			current: "h\xe9llo\n",
+			file:    FileStatus{Path: "file", Status: FileModified},
+			want:    []InvalidFile{{Path: "file", Problem: "the encoding changed from UTF-8 to not UTF-8 or UTF-16"}},
+		},
+		{
+			name:    "UTF-16 text git calls binary",
+			head:    "\xff\xfe\"\x00a\x00\"\x00",
+			current: `"a" = "b";`,
+			file:    FileStatus{Path: "file", Status: FileModified, Binary: true},
+			want:    []InvalidFile{{Path: "file", Problem: "the encoding changed from UTF-16LE to UTF-8"}},
+		},
+		{
+			name:     "LFS file whose HEAD blob is the pointer",
+			head:     lfsPointer,
+			current:  png,
+			file:     FileStatus{Path: "file", Status: FileModified},
+			lfsFiles: []string{"file"},
+		},
+		{
+			name:    "binary file",
+			head:    "placeholder\n",
+			current: png,
+			file:    FileStatus{Path: "file", Status: FileModified, Binary: true},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s, _ := gitTestRepo(t, map[string]string{"file": tt.head})
+			require.NoError(t, os.WriteFile(s.repoFile("file"), []byte(tt.current), 0644))
+
+			invalid := s.checkSyntax([]FileStatus{tt.file}, tt.lfsFiles)
+
+			assert.Equal(t, tt.want, invalid)
+		})
+	}
+}
+
+func Test_checkSyntax_pathWithSpace(t *testing.T) {
+	s, _ := gitTestRepo(t, map[string]string{"app config.json": `{"a": 1}`})
+	require.NoError(t, os.WriteFile(s.repoFile("app config.json"), []byte(`{"a": `), 0644))
+
+	invalid := s.checkSyntax([]FileStatus{{Path: "app config.json", Status: FileModified}}, nil)
+
+	require.Len(t, invalid, 1)
+	assert.Equal(t, "app config.json", invalid[0].Path)
+}
+
+func Test_checkSyntax_unreadableFile(t *testing.T) {
+	s, _ := gitTestRepo(t, map[string]string{"config.json": `{"a": 1}`})
+	require.NoError(t, os.Remove(s.repoFile("config.json")))
+	require.NoError(t, os.Symlink("missing.json", s.repoFile("config.json")))
+
+	invalid := s.checkSyntax([]FileStatus{{Path: "config.json", Status: FileTypeChanged}}, nil)
+
+	assert.Empty(t, invalid, "a file that can't be read is skipped, not reported")
+}
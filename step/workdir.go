@@ -0,0 +1,80 @@
+package step
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/bitrise-io/go-utils/v2/command"
+)
+
+// inRepo returns a Step that works in the repository at path: its commands run
+// in the top level of the repository, and file paths are relative to it.
+func (s Step) inRepo(path string) (Step, error) {
+	if _, err := os.Stat(path); err != nil {
+		return s, fmt.Errorf("repo_path: %w", err)
+	}
+	top, err := s.commandFactory.Create("git", []string{"rev-parse", "--show-toplevel"}, &command.Opts{Dir: path}).RunAndReturnTrimmedCombinedOutput()
+	if err != nil {
+		return s, fmt.Errorf("repo_path: %s is not in a git repository: %w\n%s", path, err, top)
+	}
+	s.logger.Infof("Working in the repository at %s", top)
+	s.repoDir = top
+	s.commandFactory = dirCommandFactory{Factory: s.commandFactory, dir: top}
+	return s, nil
+}
+
+// repoFile returns the path of a file of the repository, which git reports
+// relative to its top level, as the step's file system calls need it.
+func (s Step) repoFile(path string) string {
+	return filepath.Join(s.repoDir, path)
+}
+
+// withPathspec appends the pathspec that limits the step to a part of the
+// repository, if there is one.
+func (s Step) withPathspec(args []string) []string {
+	if len(s.pathspec) == 0 {
+		return args
+	}
+	return append(append(args, "--"), s.pathspec...)
+}
+
+// gitStagedOutsidePathspec returns the staged paths the pathspec doesn't cover,
+// e.g. ones a previous step staged for another project of the monorepo.
+func (s Step) gitStagedOutsidePathspec() ([]string, error) {
+	if len(s.pathspec) == 0 {
+		return nil, nil
+	}
+	list := func(args []string) (map[string]bool, error) {
+		out, err := s.commandFactory.Create("git", args, nil).RunAndReturnTrimmedOutput()
+		if err != nil {
+			return nil, fmt.Errorf("list staged files: %w\n%s", err, out)
+		}
+		paths := map[string]bool{}
+		for _, p := range strings.Split(out, "\x00") {
+			if p != "" {
+				paths[p] = true
+			}
+		}
+		return paths, nil
+	}
+	args := []string{"diff", "--cached", "--name-only", "-z", "--no-renames"}
+	all, err := list(args)
+	if err != nil {
+		return nil, err
+	}
+	inside, err := list(s.withPathspec(args))
+	if err != nil {
+		return nil, err
+	}
+	var outside []string
+	for p := range all {
+		if !inside[p] {
+			outside = append(outside, p)
+		}
+	}
+	sort.Strings(outside)
+	return outside, nil
+}
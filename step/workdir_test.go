@@ -0,0 +1,29 @@
+package step
+
+import (
+	"testing"
+
+	"github.com/bitrise-io/go-utils/v2/log"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_withPathspec(t *testing.T) {
+	s := Step{}
+	assert.Equal(t, []string{"add", "--all"}, s.withPathspec([]string{"add", "--all"}))
+
+	s.pathspec = []string{"apps/ios", ":(exclude)apps/ios/Pods"}
+	assert.Equal(t, []string{"add", "--all", "--", "apps/ios", ":(exclude)apps/ios/Pods"}, s.withPathspec([]string{"add", "--all"}))
+}
+
+func Test_getChangedFiles_pathspec(t *testing.T) {
+	factory := &fakeCommandFactory{}
+	s := Step{commandFactory: factory, logger: log.NewLogger(), envRepo: fakeEnvRepo{}, pathspec: []string{"apps/ios"}}
+
+	_, err := s.getChangedFiles(true)
+	require.NoError(t, err)
+
+	call, ok := factory.findCall("status")
+	require.True(t, ok)
+	assert.Equal(t, []string{"status", "--porcelain", "-z", "--untracked-files=all", "--", "apps/ios"}, call.args)
+}
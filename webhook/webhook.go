@@ -0,0 +1,191 @@
+// Package webhook delivers signed JSON notifications about autofix runs to an
+// arbitrary HTTP endpoint (Slack workflow triggers, internal dashboards, etc.).
+package webhook
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+// Event is the kind of outcome a notification describes.
+type Event string
+
+const (
+	EventSkipped         Event = "skipped"
+	EventPushed          Event = "pushed"
+	EventDryRun          Event = "dry_run"
+	EventSecurityBlocked Event = "security_blocked"
+	EventConflict        Event = "conflict"
+	EventPushFailed      Event = "push_failed"
+	EventCheckFailed     Event = "check_failed"
+	EventError           Event = "error"
+)
+
+const (
+	// SignatureHeader carries the hex encoded HMAC-SHA256 of the request body,
+	// prefixed with "sha256=". It is only sent when a secret is configured.
+	SignatureHeader = "X-Autofix-Signature-256"
+	// EventHeader duplicates Payload.Event so receivers can route requests
+	// without parsing the body.
+	EventHeader = "X-Autofix-Event"
+
+	userAgent = "bitrise-step-autofix-ci"
+
+	defaultTimeout     = 10 * time.Second
+	defaultMaxAttempts = 3
+	defaultRetryDelay  = 2 * time.Second
+)
+
+// Payload is the JSON body of a notification.
+type Payload struct {
+	Event Event `json:"event"`
+	// Outcome is the outcome of the step, as in the AUTOFIX_OUTCOME output.
+	// It is finer grained than Event: e.g. every failure without its own event is an "error" event.
+	Outcome string `json:"outcome,omitempty"`
+	// Reason is a human readable explanation, set for skips and failures.
+	Reason string `json:"reason,omitempty"`
+	Branch string `json:"branch,omitempty"`
+	// HeadSHA is the commit the build was running on before the step made any changes.
+	HeadSHA string `json:"head_sha,omitempty"`
+	// CommitSHA is the autofix commit, set once it has been created.
+	CommitSHA string   `json:"commit_sha,omitempty"`
+	Files     []string `json:"files"`
+	// Repositories are the results of each repository when the step ran in several.
+	Repositories []Repository `json:"repositories,omitempty"`
+	Build        Build        `json:"build"`
+}
+
+// Repository is the result of one repository of a multi-repository run.
+type Repository struct {
+	Path      string   `json:"path"`
+	Outcome   string   `json:"outcome"`
+	Branch    string   `json:"branch,omitempty"`
+	HeadSHA   string   `json:"head_sha,omitempty"`
+	CommitSHA string   `json:"commit_sha,omitempty"`
+	Files     []string `json:"files"`
+}
+
+// Build identifies the CI build that produced the notification.
+type Build struct {
+	AppSlug     string `json:"app_slug,omitempty"`
+	BuildSlug   string `json:"build_slug,omitempty"`
+	BuildNumber string `json:"build_number,omitempty"`
+	BuildURL    string `json:"build_url,omitempty"`
+	PullRequest string `json:"pull_request,omitempty"`
+	Workflow    string `json:"workflow,omitempty"`
+}
+
+// Client posts payloads to a single webhook URL.
+type Client struct {
+	URL    string
+	Secret string
+	// HTTPClient is used for every attempt; its Timeout bounds a single attempt.
+	HTTPClient *http.Client
+	// MaxAttempts is the total number of attempts, including the first one.
+	MaxAttempts int
+	// RetryDelay is the wait before the first retry; it doubles after each attempt.
+	RetryDelay time.Duration
+}
+
+// NewClient returns a Client with the default timeout and retry policy.
+// An empty secret disables request signing.
+func NewClient(url, secret string) Client {
+	return Client{
+		URL:         url,
+		Secret:      secret,
+		HTTPClient:  &http.Client{Timeout: defaultTimeout},
+		MaxAttempts: defaultMaxAttempts,
+		RetryDelay:  defaultRetryDelay,
+	}
+}
+
+// Send posts the payload, retrying on network errors, 5xx and 429 responses.
+// Other 4xx responses are returned immediately since retrying them won't help.
+func (c Client) Send(p Payload) error {
+	body, err := json.Marshal(p)
+	if err != nil {
+		return fmt.Errorf("marshal payload: %w", err)
+	}
+
+	attempts := c.MaxAttempts
+	if attempts < 1 {
+		attempts = 1
+	}
+	delay := c.RetryDelay
+
+	var lastErr error
+	for i := 0; i < attempts; i++ {
+		if i > 0 {
+			time.Sleep(delay)
+			delay *= 2
+		}
+
+		retryable, err := c.post(p.Event, body)
+		if err == nil {
+			return nil
+		}
+		lastErr = err
+		if !retryable {
+			break
+		}
+	}
+	return lastErr
+}
+
+func (c Client) post(event Event, body []byte) (retryable bool, err error) {
+	req, err := http.NewRequest(http.MethodPost, c.URL, bytes.NewReader(body))
+	if err != nil {
+		return false, fmt.Errorf("create request: %w", withoutURL(err))
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", userAgent)
+	req.Header.Set(EventHeader, string(event))
+	if c.Secret != "" {
+		req.Header.Set(SignatureHeader, Sign(c.Secret, body))
+	}
+
+	httpClient := c.HTTPClient
+	if httpClient == nil {
+		httpClient = &http.Client{Timeout: defaultTimeout}
+	}
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return true, fmt.Errorf("send request: %w", withoutURL(err))
+	}
+	defer resp.Body.Close()
+	// Drain the body so the connection can be reused by the next attempt.
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 64*1024)) //nolint:errcheck
+
+	if resp.StatusCode >= 200 && resp.StatusCode < 300 {
+		return false, nil
+	}
+	retryable = resp.StatusCode >= 500 || resp.StatusCode == http.StatusTooManyRequests
+	return retryable, fmt.Errorf("unexpected response status: %s", resp.Status)
+}
+
+// withoutURL drops the URL from the errors of net/url and net/http. Webhook
+// URLs, like the ones of Slack, often are credentials themselves.
+func withoutURL(err error) error {
+	var urlErr *url.Error
+	if errors.As(err, &urlErr) {
+		return fmt.Errorf("%s: %w", urlErr.Op, urlErr.Err)
+	}
+	return err
+}
+
+// Sign returns the value of SignatureHeader for body. Receivers should compute
+// the same HMAC over the raw request body and compare in constant time.
+func Sign(secret string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
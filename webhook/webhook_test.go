@@ -0,0 +1,139 @@
+package webhook
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func testClient(url, secret string) Client {
+	c := NewClient(url, secret)
+	c.RetryDelay = time.Millisecond
+	return c
+}
+
+func TestSend_SignsPayload(t *testing.T) {
+	const secret = "s3cr3t"
+	var gotBody []byte
+	var gotSignature, gotEvent string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gotBody, _ = io.ReadAll(r.Body)
+		gotSignature = r.Header.Get(SignatureHeader)
+		gotEvent = r.Header.Get(EventHeader)
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer srv.Close()
+
+	err := testClient(srv.URL, secret).Send(Payload{
+		Event:  EventPushed,
+		Branch: "feature",
+		Files:  []string{"main.go"},
+	})
+	require.NoError(t, err)
+
+	// The receiver must be able to verify the signature over the exact bytes it received.
+	assert.Equal(t, Sign(secret, gotBody), gotSignature)
+	assert.Equal(t, string(EventPushed), gotEvent)
+
+	var p Payload
+	require.NoError(t, json.Unmarshal(gotBody, &p))
+	assert.Equal(t, EventPushed, p.Event)
+	assert.Equal(t, "feature", p.Branch)
+	assert.Equal(t, []string{"main.go"}, p.Files)
+}
+
+func TestSend_NoSecretNoSignature(t *testing.T) {
+	var hasSignature bool
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, hasSignature = r.Header[SignatureHeader]
+	}))
+	defer srv.Close()
+
+	require.NoError(t, testClient(srv.URL, "").Send(Payload{Event: EventSkipped}))
+	assert.False(t, hasSignature)
+}
+
+func TestSend_RetriesServerErrors(t *testing.T) {
+	var calls atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if calls.Add(1) < 3 {
+			w.WriteHeader(http.StatusBadGateway)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer srv.Close()
+
+	require.NoError(t, testClient(srv.URL, "").Send(Payload{Event: EventDryRun}))
+	assert.Equal(t, int32(3), calls.Load())
+}
+
+func TestSend_GivesUpAfterMaxAttempts(t *testing.T) {
+	var calls atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls.Add(1)
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer srv.Close()
+
+	err := testClient(srv.URL, "").Send(Payload{Event: EventPushFailed})
+	require.Error(t, err)
+	assert.Equal(t, int32(defaultMaxAttempts), calls.Load())
+}
+
+func TestSend_DoesNotRetryClientErrors(t *testing.T) {
+	var calls atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls.Add(1)
+		w.WriteHeader(http.StatusUnauthorized)
+	}))
+	defer srv.Close()
+
+	err := testClient(srv.URL, "").Send(Payload{Event: EventConflict})
+	require.Error(t, err)
+	assert.Equal(t, int32(1), calls.Load())
+}
+
+func TestSend_Timeout(t *testing.T) {
+	var calls atomic.Int32
+	release := make(chan struct{})
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls.Add(1)
+		<-release
+	}))
+	defer srv.Close()
+	defer close(release)
+
+	c := testClient(srv.URL, "")
+	c.HTTPClient = &http.Client{Timeout: 20 * time.Millisecond}
+	c.MaxAttempts = 2
+
+	err := c.Send(Payload{Event: EventSecurityBlocked})
+	require.Error(t, err)
+	// Timeouts are treated like network errors and retried.
+	assert.Equal(t, int32(2), calls.Load())
+}
+
+func TestSend_ErrorsDoNotContainTheURL(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	unreachable := srv.URL + "/services/T000/B000/XXXXSECRETXXXX"
+	srv.Close()
+
+	for _, u := range []string{unreachable, "http://[::1/services/T000/B000/XXXXSECRETXXXX"} {
+		c := testClient(u, "")
+		c.MaxAttempts = 1
+
+		err := c.Send(Payload{Event: EventPushed})
+
+		require.Error(t, err)
+		assert.False(t, strings.Contains(err.Error(), "XXXXSECRETXXXX"), err.Error())
+	}
+}